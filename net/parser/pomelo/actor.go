@@ -122,6 +122,9 @@ func (*Actor) SetHeartbeat(t time.Duration) {
 }
 
 func (*Actor) SetSysData(key string, value interface{}) {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
 	cmd.sysData[key] = value
 }
 
@@ -132,6 +135,7 @@ func (p *Actor) SetOnNewAgent(fn OnNewAgentFunc) {
 func (*Actor) SetOnDataRoute(fn DataRouteFunc) {
 	if fn != nil {
 		cmd.onDataRouteFunc = fn
+		cmd.buildDataRouteChain()
 	}
 }
 
@@ -3,9 +3,11 @@ package pomelo
 import (
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	cerr "github.com/cherry-game/cherry/error"
 	cnet "github.com/cherry-game/cherry/extend/net"
 	ctime "github.com/cherry-game/cherry/extend/time"
 	cutils "github.com/cherry-game/cherry/extend/utils"
@@ -14,6 +16,7 @@ import (
 	pomeloMessage "github.com/cherry-game/cherry/net/parser/pomelo/message"
 	pomeloPacket "github.com/cherry-game/cherry/net/parser/pomelo/packet"
 	cproto "github.com/cherry-game/cherry/net/proto"
+	jsoniter "github.com/json-iterator/go"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -22,6 +25,16 @@ const (
 	AgentWaitAck int32 = 1
 	AgentWorking int32 = 2
 	AgentClosed  int32 = 3
+	AgentClosing int32 = 4 // 已发送 Kick 包，写出后关闭连接
+)
+
+// agent 关闭的原因，见 OnAgentClosed
+const (
+	CloseReasonClient    = "client"    // 客户端断开连接或读取数据出错
+	CloseReasonKick      = "kick"      // 发送 Kick 包后关闭
+	CloseReasonTimeout   = "timeout"   // 心跳超时或握手超时
+	CloseReasonHandshake = "handshake" // 拒绝握手后关闭
+	CloseReasonServer    = "server"    // 服务端调用 Close 关闭
 )
 
 type (
@@ -35,6 +48,13 @@ type (
 		chWrite              chan []byte          // push bytes queue
 		lastAt               int64                // last heartbeat unix time stamp
 		onCloseFunc          []OnCloseFunc        // on close agent
+		dictDisabled         int32                // 1 = client disabled route dictionary compression
+		limiter              rateLimiter          // data packet rate limiter
+		droppedPackets       int64                // data packets dropped by rate limiter
+		serializerIndex      int32                // 握手时客户端选择的序列化器在 cmd.serializers 中的下标+1，0 = app 默认序列化器
+		sessionValues        *sync.Map            // 路由处理函数保存的连接级数据，断开连接时清空
+		closeReason          atomic.Value         // 关闭原因，只记录第一次关闭的原因
+		handshakeTimer       atomic.Value         // 握手超时计时器（*time.Timer），收到 HandshakeAck 或关闭时停止
 	}
 
 	pendingMessage struct {
@@ -43,22 +63,31 @@ type (
 		mid     uint               // response message id(response)
 		payload interface{}        // payload
 		err     bool               // if it's an error
+		encoded bool               // payload 为已序列化的 []byte，不经过序列化器
+		packet  []byte             // 已编码的完整数据包（如 BroadcastData），直接写出
 	}
 
 	OnCloseFunc func(*Agent)
+
+	// errorPayload SendError 发送的错误消息内容
+	errorPayload struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
 )
 
 func NewAgent(app cfacade.IApplication, conn net.Conn, session *cproto.Session) Agent {
 	agent := Agent{
-		IApplication: app,
-		conn:         conn,
-		state:        AgentInit,
-		session:      session,
-		chDie:        make(chan struct{}),
-		chPending:    make(chan *pendingMessage, cmd.writeBacklog),
-		chWrite:      make(chan []byte, cmd.writeBacklog),
-		lastAt:       0,
-		onCloseFunc:  nil,
+		IApplication:  app,
+		conn:          conn,
+		state:         AgentInit,
+		session:       session,
+		chDie:         make(chan struct{}),
+		chPending:     make(chan *pendingMessage, cmd.writeBacklog),
+		chWrite:       make(chan []byte, cmd.writeBacklog),
+		lastAt:        0,
+		onCloseFunc:   nil,
+		sessionValues: &sync.Map{},
 	}
 
 	agent.session.Ip = agent.RemoteAddr()
@@ -77,7 +106,7 @@ func NewAgent(app cfacade.IApplication, conn net.Conn, session *cproto.Session)
 }
 
 func (a *Agent) State() int32 {
-	return a.state
+	return atomic.LoadInt32(&a.state)
 }
 
 func (a *Agent) SetState(state int32) bool {
@@ -109,12 +138,108 @@ func (a *Agent) Unbind() {
 	Unbind(a.SID())
 }
 
+// DroppedPackets 因超过限流被丢弃的 data 包数量
+func (a *Agent) DroppedPackets() int64 {
+	return atomic.LoadInt64(&a.droppedPackets)
+}
+
+// UseDict 推送消息时是否使用字典压缩路由，默认启用
+func (a *Agent) UseDict() bool {
+	return atomic.LoadInt32(&a.dictDisabled) == 0
+}
+
+// SetUseDict 设置推送消息时是否使用字典压缩路由，由客户端握手时的 sys.useDict 协商
+func (a *Agent) SetUseDict(useDict bool) {
+	var disabled int32
+	if !useDict {
+		disabled = 1
+	}
+	atomic.StoreInt32(&a.dictDisabled, disabled)
+}
+
+// Serializer 返回客户端握手时选择的序列化器，未选择或选择的不受支持时为 app 默认序列化器
+// 只影响本 agent 编码的 Response/Push，其他节点的 actor 仍使用 app 默认序列化器编码
+func (a *Agent) Serializer() cfacade.ISerializer {
+	if index := atomic.LoadInt32(&a.serializerIndex); index > 0 {
+		return cmd.serializers[index-1]
+	}
+	return a.IApplication.Serializer()
+}
+
+// SetSerializer 按名称选择序列化器，名称不在 AddSerializer 注册的列表中时返回 false，继续使用 app 默认序列化器
+func (a *Agent) SetSerializer(name string) bool {
+	index, found := cmd.serializerIndex(name)
+	if !found {
+		return false
+	}
+	atomic.StoreInt32(&a.serializerIndex, int32(index+1))
+	return true
+}
+
+// startHandshakeTimer 开始握手超时计时，超时仍未进入 AgentWorking 状态时关闭 agent
+// 在读协程中处理握手包时调用，stopHandshakeTimer 还会在关闭时由写协程调用
+func (a *Agent) startHandshakeTimer(timeout time.Duration) {
+	timer := time.AfterFunc(timeout, func() {
+		if a.State() != AgentWaitAck {
+			return
+		}
+
+		if clog.PrintLevel(zapcore.DebugLevel) {
+			clog.Debugf("[sid = %s,uid = %d] Handshake timeout. [timeout = %v, address = %s]",
+				a.SID(),
+				a.UID(),
+				timeout,
+				a.RemoteAddr(),
+			)
+		}
+
+		a.closeWithReason(CloseReasonTimeout)
+	})
+
+	if old, _ := a.handshakeTimer.Swap(timer).(*time.Timer); old != nil {
+		old.Stop()
+	}
+
+	// 期间 agent 已关闭时 closeProcess 可能已经停止过计时器
+	if a.State() == AgentClosed {
+		a.stopHandshakeTimer()
+	}
+}
+
+// stopHandshakeTimer 停止握手超时计时
+func (a *Agent) stopHandshakeTimer() {
+	if timer, _ := a.handshakeTimer.Swap((*time.Timer)(nil)).(*time.Timer); timer != nil {
+		timer.Stop()
+	}
+}
+
+// CloseReason agent 关闭的原因，未关闭时为空
+func (a *Agent) CloseReason() string {
+	reason, _ := a.closeReason.Load().(string)
+	return reason
+}
+
+// SetSessionValue 保存连接级数据（如登录 token、选择的角色），供同一连接后续的路由处理函数读取
+// 并发安全，断开连接时（OnClose 回调执行之后）清空
+func (a *Agent) SetSessionValue(key string, v interface{}) {
+	a.sessionValues.Store(key, v)
+}
+
+// GetSessionValue 读取 SetSessionValue 保存的连接级数据
+func (a *Agent) GetSessionValue(key string) (interface{}, bool) {
+	return a.sessionValues.Load(key)
+}
+
 func (a *Agent) SetLastAt() {
 	atomic.StoreInt64(&a.lastAt, ctime.Now().ToSecond())
 }
 
+// SendRaw 将已编码的数据包写入发送队列，agent 关闭后不再阻塞
 func (a *Agent) SendRaw(bytes []byte) {
-	a.chWrite <- bytes
+	select {
+	case a.chWrite <- bytes:
+	case <-a.chDie:
+	}
 }
 
 func (a *Agent) SendPacket(typ pomeloPacket.Type, data []byte) {
@@ -127,6 +252,13 @@ func (a *Agent) SendPacket(typ pomeloPacket.Type, data []byte) {
 }
 
 func (a *Agent) Close() {
+	a.closeWithReason(CloseReasonServer)
+}
+
+// closeWithReason 关闭 agent，只记录第一次关闭的原因
+func (a *Agent) closeWithReason(reason string) {
+	a.closeReason.CompareAndSwap(nil, reason)
+
 	if a.SetState(AgentClosed) {
 		select {
 		case <-a.chDie:
@@ -150,7 +282,7 @@ func (a *Agent) readChan() {
 			)
 		}
 
-		a.Close()
+		a.closeWithReason(CloseReasonClient)
 	}()
 
 	for {
@@ -170,7 +302,7 @@ func (a *Agent) readChan() {
 }
 
 func (a *Agent) writeChan() {
-	ticker := time.NewTicker(cmd.heartbeatTime)
+	ticker := time.NewTicker(cmd.heartbeatInterval())
 	defer func() {
 		if clog.PrintLevel(zapcore.DebugLevel) {
 			clog.Debugf("[sid = %s,uid = %d] Agent write chan exit.", a.SID(), a.UID())
@@ -192,11 +324,12 @@ func (a *Agent) writeChan() {
 		case <-ticker.C:
 			{
 				lastAt = atomic.LoadInt64(&a.lastAt)
-				deadline = time.Now().Add(-cmd.heartbeatTime).Unix()
+				deadline = time.Now().Add(-cmd.heartbeatInterval()).Unix()
 				if lastAt < deadline {
 					if clog.PrintLevel(zapcore.DebugLevel) {
 						clog.Debugf("[sid = %s,uid = %d] Check heartbeat timeout.", a.SID(), a.UID())
 					}
+					a.closeReason.CompareAndSwap(nil, CloseReasonTimeout)
 					return
 				}
 			}
@@ -207,6 +340,12 @@ func (a *Agent) writeChan() {
 		case bytes := <-a.chWrite:
 			{
 				a.write(bytes)
+
+				// SendKick 发送的 Kick 包、拒绝握手的响应写出后关闭连接
+				// 关闭原因已在 SendKick、sendHandshakeCode 中记录
+				if len(bytes) > 0 && (bytes[0] == pomeloPacket.Kick || bytes[0] == pomeloPacket.Handshake) && a.State() == AgentClosing {
+					return
+				}
 			}
 		}
 	}
@@ -221,7 +360,15 @@ func (a *Agent) closeProcess() {
 		clog.Warn(errString)
 	})
 
+	// 握手未完成时关闭，停止计时器，避免计时器到期前一直持有 agent
+	a.stopHandshakeTimer()
+
+	// 未记录原因时（如 Close 之外的方式结束）视为服务端关闭
+	a.closeReason.CompareAndSwap(nil, CloseReasonServer)
+	cmd.runAgentClosedHooks(a, a.CloseReason())
+
 	a.Unbind()
+	a.sessionValues.Clear()
 
 	if err := a.conn.Close(); err != nil {
 		clog.Debugf("[sid = %s,uid = %d] Agent connect closed. [error = %s]",
@@ -240,8 +387,7 @@ func (a *Agent) closeProcess() {
 		)
 	}
 
-	close(a.chPending)
-	close(a.chWrite)
+	// chPending、chWrite 不关闭，关闭后仍可能有其他 goroutine 发送，未发送的消息随 agent 一起回收
 }
 
 func (a *Agent) write(bytes []byte) {
@@ -283,16 +429,27 @@ func (p *pendingMessage) String() string {
 }
 
 func (a *Agent) processPending(data *pendingMessage) {
-	payload, err := a.Serializer().Marshal(data.payload)
-	if err != nil {
-		clog.Warnf("[sid = %s,uid = %d] Payload marshal error. [data = %s]",
-			a.SID(),
-			a.UID(),
-			data.String(),
-		)
+	if data.packet != nil {
+		a.SendRaw(data.packet)
 		return
 	}
 
+	var payload []byte
+	if data.encoded {
+		payload, _ = data.payload.([]byte)
+	} else {
+		var err error
+		payload, err = a.Serializer().Marshal(data.payload)
+		if err != nil {
+			clog.Warnf("[sid = %s,uid = %d] Payload marshal error. [data = %s]",
+				a.SID(),
+				a.UID(),
+				data.String(),
+			)
+			return
+		}
+	}
+
 	// construct message and encode
 	m := &pomeloMessage.Message{
 		Type:  data.typ,
@@ -303,7 +460,7 @@ func (a *Agent) processPending(data *pendingMessage) {
 	}
 
 	// encode message
-	em, err := pomeloMessage.Encode(m)
+	em, err := pomeloMessage.EncodeWithDict(m, a.UseDict())
 	if err != nil {
 		clog.Warn(err)
 		return
@@ -314,41 +471,45 @@ func (a *Agent) processPending(data *pendingMessage) {
 }
 
 func (a *Agent) sendPending(typ pomeloMessage.Type, route string, mid uint32, v interface{}, isError bool) {
-	if a.state == AgentClosed {
-		clog.Warnf("[sid = %s,uid = %d] Session is closed. [typ = %v, route = %s, mid = %d, val = %+v, err = %v]",
-			a.SID(),
-			a.UID(),
-			typ,
-			route,
-			mid,
-			v,
-			isError,
-		)
-		return
+	pending := &pendingMessage{
+		typ:     typ,
+		mid:     uint(mid),
+		route:   route,
+		payload: v,
+		err:     isError,
 	}
 
-	if len(a.chPending) >= cmd.writeBacklog {
-		clog.Warnf("[sid = %s,uid = %d] send buffer exceed. [typ = %v, route = %s, mid = %d, val = %+v, err = %v]",
-			a.SID(),
-			a.UID(),
+	if err := a.enqueuePending(pending); err != nil {
+		clog.Warnf("%v [typ = %v, route = %s, mid = %d, val = %+v, err = %v]",
+			err,
 			typ,
 			route,
 			mid,
 			v,
 			isError,
 		)
-		return
 	}
+}
 
-	pending := &pendingMessage{
-		typ:     typ,
-		mid:     uint(mid),
-		route:   route,
-		payload: v,
-		err:     isError,
+// enqueuePending 将消息放入待发送队列，由 writeChan 按入队顺序编码发送
+// agent 已关闭或队列已满时不阻塞，返回错误
+func (a *Agent) enqueuePending(pending *pendingMessage) error {
+	if a.State() == AgentClosed {
+		return cerr.Errorf("[sid = %s,uid = %d] Session is closed.", a.SID(), a.UID())
+	}
+
+	if len(a.chPending) >= cmd.writeBacklog {
+		return cerr.Errorf("[sid = %s,uid = %d] send buffer exceed.", a.SID(), a.UID())
 	}
 
-	a.chPending <- pending
+	select {
+	case a.chPending <- pending:
+		return nil
+	case <-a.chDie:
+		return cerr.Errorf("[sid = %s,uid = %d] Session is closed.", a.SID(), a.UID())
+	default:
+		return cerr.Errorf("[sid = %s,uid = %d] send buffer exceed.", a.SID(), a.UID())
+	}
 }
 
 func (a *Agent) Response(session *cproto.Session, v interface{}, isError ...bool) {
@@ -391,6 +552,121 @@ func (a *Agent) Push(route string, val interface{}) {
 	}
 }
 
+// PushBytes 向客户端推送 route 对应的消息，data 为已序列化的消息内容，不经过序列化器
+// 客户端握手时声明使用字典且路由在字典中时使用压缩的路由编号，否则发送路由字符串；
+// 与 Push 共用发送队列，按调用顺序发送；route 为空、agent 不在 AgentWorking 状态或队列已满时不发送并返回错误
+func (a *Agent) PushBytes(route string, data []byte) error {
+	if route == "" {
+		return cerr.Errorf("[sid = %s,uid = %d] push route is empty.", a.SID(), a.UID())
+	}
+
+	if state := a.State(); state != AgentWorking {
+		return cerr.Errorf("[sid = %s,uid = %d] agent is not working. [state = %d]", a.SID(), a.UID(), state)
+	}
+
+	m := &pomeloMessage.Message{
+		Type:  pomeloMessage.Push,
+		Route: route,
+		Data:  data,
+	}
+
+	if err := a.sendMessage(m); err != nil {
+		return err
+	}
+
+	if clog.PrintLevel(zapcore.DebugLevel) {
+		clog.Debugf("[sid = %s,uid = %d] PushBytes ok. [route = %s]",
+			a.SID(),
+			a.UID(),
+			route,
+		)
+	}
+
+	return nil
+}
+
+// SendError 向客户端推送 route 对应的错误消息，内容为 JSON 编码的 {"code": code, "msg": message}，消息带 error 标记
+// agent 不在 AgentWorking 状态时不发送并返回错误
+func (a *Agent) SendError(route string, code int, message string) error {
+	if state := a.State(); state != AgentWorking {
+		return cerr.Errorf("[sid = %s,uid = %d] agent is not working. [state = %d]", a.SID(), a.UID(), state)
+	}
+
+	data, err := jsoniter.Marshal(errorPayload{Code: code, Msg: message})
+	if err != nil {
+		return err
+	}
+
+	m := &pomeloMessage.Message{
+		Type:  pomeloMessage.Push,
+		Route: route,
+		Data:  data,
+		Error: true,
+	}
+
+	if err := a.sendMessage(m); err != nil {
+		return err
+	}
+
+	if clog.PrintLevel(zapcore.DebugLevel) {
+		clog.Debugf("[sid = %s,uid = %d] SendError ok. [route = %s, code = %d, msg = %s]",
+			a.SID(),
+			a.UID(),
+			route,
+			code,
+			message,
+		)
+	}
+
+	return nil
+}
+
+// ResponseBytes 使用已编码的 data 回复请求，reqID 为请求消息的 ID（pmessage.Message.ID，即 session.GetMID()）
+// 客户端根据该 ID 匹配请求的回调，notify 消息没有 ID（reqID 为 0）时返回错误；
+// agent 不在 AgentWorking 状态时不发送并返回错误
+func (a *Agent) ResponseBytes(reqID uint, data []byte) error {
+	if reqID == 0 {
+		return cerr.Errorf("[sid = %s,uid = %d] response requires a request message id.", a.SID(), a.UID())
+	}
+
+	if state := a.State(); state != AgentWorking {
+		return cerr.Errorf("[sid = %s,uid = %d] agent is not working. [state = %d]", a.SID(), a.UID(), state)
+	}
+
+	m := &pomeloMessage.Message{
+		Type: pomeloMessage.Response,
+		ID:   reqID,
+		Data: data,
+	}
+
+	if err := a.sendMessage(m); err != nil {
+		return err
+	}
+
+	if clog.PrintLevel(zapcore.DebugLevel) {
+		clog.Debugf("[sid = %s,uid = %d] ResponseBytes ok. [mid = %d]",
+			a.SID(),
+			a.UID(),
+			reqID,
+		)
+	}
+
+	return nil
+}
+
+// sendMessage 将 Data 已序列化的消息放入待发送队列，不经过序列化器
+// 与 Push、Response 共用队列，保证同一 agent 的消息按调用顺序发送
+func (a *Agent) sendMessage(m *pomeloMessage.Message) error {
+	return a.enqueuePending(&pendingMessage{
+		typ:     m.Type,
+		mid:     m.ID,
+		route:   m.Route,
+		payload: m.Data,
+		err:     m.Error,
+		encoded: true,
+	})
+}
+
 func (a *Agent) Kick(reason interface{}, closed bool) {
 	bytes, err := a.Serializer().Marshal(reason)
 	if err != nil {
@@ -426,8 +702,59 @@ func (a *Agent) Kick(reason interface{}, closed bool) {
 	a.SendRaw(pkg)
 
 	if closed {
+		a.closeWithReason(CloseReasonKick)
+	}
+}
+
+// SendKick 发送 Kick 包通知客户端断开连接（如封禁、停服维护），reason 以 JSON 编码
+// 发送后 agent 进入 AgentClosing 状态，Kick 包写出后关闭连接；agent 已关闭或正在关闭时忽略
+func (a *Agent) SendKick(reason map[string]interface{}) {
+	for {
+		state := a.State()
+		if state == AgentClosed || state == AgentClosing {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&a.state, state, AgentClosing) {
+			break
+		}
+	}
+
+	// 在决定踢下线时记录原因，客户端收到 Kick 包后立即断开时不会被记录为客户端关闭
+	a.closeReason.CompareAndSwap(nil, CloseReasonKick)
+
+	bytes, err := jsoniter.Marshal(reason)
+	if err != nil {
+		clog.Warnf("[sid = %s,uid = %d] SendKick marshal fail. [reason = {%+v}, err = %s]",
+			a.SID(),
+			a.UID(),
+			reason,
+			err,
+		)
 		a.Close()
+		return
 	}
+
+	pkg, err := pomeloPacket.Encode(pomeloPacket.Kick, bytes)
+	if err != nil {
+		clog.Warnf("[sid = %s,uid = %d] SendKick packet encode error.[reason = %+v, err = %s]",
+			a.SID(),
+			a.UID(),
+			reason,
+			err,
+		)
+		a.Close()
+		return
+	}
+
+	if clog.PrintLevel(zapcore.DebugLevel) {
+		clog.Debugf("[sid = %s,uid = %d] SendKick ok. [reason = %+v]",
+			a.SID(),
+			a.UID(),
+			reason,
+		)
+	}
+
+	a.SendRaw(pkg)
 }
 
 func (a *Agent) AddOnClose(fn OnCloseFunc) {
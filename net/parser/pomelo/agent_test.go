@@ -0,0 +1,548 @@
+package pomelo
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pmessage "github.com/cherry-game/cherry/net/parser/pomelo/message"
+	ppacket "github.com/cherry-game/cherry/net/parser/pomelo/packet"
+	cproto "github.com/cherry-game/cherry/net/proto"
+	cserializer "github.com/cherry-game/cherry/net/serializer"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// nextPacket 模拟 writeChan 处理一条待发送消息，返回编码后的数据包
+func nextPacket(t *testing.T, agent *Agent) []byte {
+	t.Helper()
+
+	select {
+	case pending := <-agent.chPending:
+		agent.processPending(pending)
+	default:
+		t.Fatal("no pending message")
+	}
+	return <-agent.chWrite
+}
+
+// useJSONSerializer 测试中没有 app，为 agent 注册 JSON 序列化器用于编码 Push/Response
+func useJSONSerializer(t *testing.T, agent *Agent) {
+	t.Helper()
+
+	serializer := cserializer.NewJSON()
+	AddSerializer(serializer)
+	t.Cleanup(func() { cmd.serializers = nil })
+
+	if !agent.SetSerializer(serializer.Name()) {
+		t.Fatal("set serializer failed")
+	}
+}
+
+func TestAgentSendKick(t *testing.T) {
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetState(AgentWorking)
+
+	agent.SendKick(map[string]interface{}{"reason": "maintenance"})
+
+	if agent.State() != AgentClosing {
+		t.Fatalf("state = %d, want %d", agent.State(), AgentClosing)
+	}
+
+	pkg, err := ppacket.Decode(<-agent.chWrite)
+	if err != nil || len(pkg) != 1 {
+		t.Fatalf("decode kick failed. err = %v", err)
+	}
+	if pkg[0].Type() != ppacket.Kick {
+		t.Fatalf("packet type = %d, want %d", pkg[0].Type(), ppacket.Kick)
+	}
+
+	var reason map[string]interface{}
+	if err := jsoniter.Unmarshal(pkg[0].Data(), &reason); err != nil || reason["reason"] != "maintenance" {
+		t.Fatalf("reason = %v, err = %v", reason, err)
+	}
+
+	// 正在关闭或已关闭时忽略
+	agent.SendKick(map[string]interface{}{"reason": "again"})
+	agent.Close()
+	agent.SendKick(map[string]interface{}{"reason": "closed"})
+
+	if len(agent.chWrite) != 0 {
+		t.Fatalf("kick should be ignored, pending = %d", len(agent.chWrite))
+	}
+	if agent.State() != AgentClosed {
+		t.Fatalf("state = %d, want %d", agent.State(), AgentClosed)
+	}
+}
+
+func TestAgentSendKickThenAck(t *testing.T) {
+	// 踢下线后处理的握手确认包不会让 agent 回到 AgentWorking
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetState(AgentWaitAck)
+
+	agent.SendKick(map[string]interface{}{"reason": "maintenance"})
+	handshakeACKCommand(&agent, nil)
+	handshakeCommand(&agent, nil)
+
+	if agent.State() != AgentClosing {
+		t.Fatalf("state = %d, want %d", agent.State(), AgentClosing)
+	}
+	if pkg, err := ppacket.Decode(<-agent.chWrite); err != nil || len(pkg) != 1 || pkg[0].Type() != ppacket.Kick {
+		t.Fatalf("kick packet = %v, err = %v", pkg, err)
+	}
+	if len(agent.chWrite) != 0 {
+		t.Fatalf("handshake should be ignored, pending = %d", len(agent.chWrite))
+	}
+
+	// reason 无法编码时不发送 Kick 包，直接关闭
+	agent = NewAgent(nil, nil, &cproto.Session{})
+	agent.SetState(AgentWorking)
+	agent.SendKick(map[string]interface{}{"reason": func() {}})
+
+	if agent.State() != AgentClosed || len(agent.chWrite) != 0 {
+		t.Fatalf("state = %d, pending = %d", agent.State(), len(agent.chWrite))
+	}
+}
+
+func TestAgentSendError(t *testing.T) {
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetUseDict(false)
+
+	if err := agent.SendError("game.hero.upgrade", 1001, "hero not found"); err == nil {
+		t.Fatal("agent not working, error should not be sent")
+	}
+	if len(agent.chPending) != 0 {
+		t.Fatalf("error should be dropped, pending = %d", len(agent.chPending))
+	}
+
+	// 与之前的 Push 按调用顺序发送
+	useJSONSerializer(t, &agent)
+	agent.SetState(AgentWorking)
+	agent.Push("game.hero.info", map[string]interface{}{"id": 1})
+	if err := agent.SendError("game.hero.upgrade", 1001, "hero not found"); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := ppacket.Decode(nextPacket(t, &agent))
+	if err != nil || len(pkg) != 1 {
+		t.Fatalf("decode packet failed. pkg = %v, err = %v", pkg, err)
+	}
+	if msg, err := pmessage.Decode(pkg[0].Data()); err != nil || msg.Route != "game.hero.info" || msg.Error {
+		t.Fatalf("first message = %v, err = %v", msg.String(), err)
+	}
+
+	pkg, err = ppacket.Decode(nextPacket(t, &agent))
+	if err != nil || len(pkg) != 1 || pkg[0].Type() != ppacket.Data {
+		t.Fatalf("decode packet failed. pkg = %v, err = %v", pkg, err)
+	}
+
+	msg, err := pmessage.Decode(pkg[0].Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != pmessage.Push || msg.Route != "game.hero.upgrade" || !msg.Error {
+		t.Fatalf("message = %v", msg.String())
+	}
+	if string(msg.Data) != `{"code":1001,"msg":"hero not found"}` {
+		t.Fatalf("payload = %s", msg.Data)
+	}
+
+	// 关闭后返回错误，不阻塞
+	agent.Close()
+	if err := agent.SendError("game.hero.upgrade", 1001, "hero not found"); err == nil {
+		t.Fatal("agent closed, error should not be sent")
+	}
+}
+
+func TestBroadcastData(t *testing.T) {
+	agents := make([]*Agent, 3)
+	for i := range agents {
+		agent := NewAgent(nil, nil, &cproto.Session{})
+		agents[i] = &agent
+	}
+	agents[0].SetState(AgentWorking)
+	agents[1].SetState(AgentWorking)
+	agents[2].SetState(AgentWaitAck)
+
+	if err := BroadcastData(agents, "game.roomHandler.onChat", []byte(`{"msg":"hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(agents[2].chPending) != 0 {
+		t.Fatal("agent not in working state should be skipped")
+	}
+
+	first, second := nextPacket(t, agents[0]), nextPacket(t, agents[1])
+	if !bytes.Equal(first, second) {
+		t.Fatal("working agents should receive identical bytes")
+	}
+
+	pkg, err := ppacket.Decode(first)
+	if err != nil || len(pkg) != 1 || pkg[0].Type() != ppacket.Data {
+		t.Fatalf("decode packet failed. err = %v", err)
+	}
+
+	msg, err := pmessage.Decode(pkg[0].Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != pmessage.Push || msg.Route != "game.roomHandler.onChat" || string(msg.Data) != `{"msg":"hi"}` {
+		t.Fatalf("msg = %+v", msg)
+	}
+
+	// 与之前的 Push 按调用顺序发送
+	useJSONSerializer(t, agents[0])
+	agents[0].Push("game.roomHandler.onJoin", map[string]interface{}{"id": 1})
+	if err := BroadcastData(agents[:1], "game.roomHandler.onChat", []byte(`{"msg":"hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range []string{"game.roomHandler.onJoin", "game.roomHandler.onChat"} {
+		pkg, err := ppacket.Decode(nextPacket(t, agents[0]))
+		if err != nil || len(pkg) != 1 {
+			t.Fatalf("decode packet failed. err = %v", err)
+		}
+		if msg, err := pmessage.Decode(pkg[0].Data()); err != nil || msg.Route != route {
+			t.Fatalf("msg = %v, want route %s", msg.String(), route)
+		}
+	}
+}
+
+func TestChannelGroupPush(t *testing.T) {
+	group := NewChannelGroup("room-1")
+
+	agents := make([]*Agent, 3)
+	for i := range agents {
+		agent := NewAgent(nil, nil, &cproto.Session{Sid: fmt.Sprintf("sid-%d", i)})
+		agent.SetState(AgentWorking)
+		agents[i] = &agent
+	}
+
+	var wg sync.WaitGroup
+	for _, agent := range agents {
+		wg.Add(1)
+		go func(agent *Agent) {
+			defer wg.Done()
+			group.Add(agent)
+		}(agent)
+	}
+	wg.Wait()
+
+	if group.Count() != 3 {
+		t.Fatalf("count = %d, want 3", group.Count())
+	}
+
+	// 断开连接的成员在推送时移除
+	agents[1].Close()
+
+	if err := group.Push("game.roomHandler.onChat", []byte(`{"msg":"hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(agents[1].chPending) != 0 {
+		t.Fatal("closed agent should not receive push")
+	}
+	if group.Contains("sid-1") || group.Count() != 2 {
+		t.Fatalf("closed agent should be pruned, count = %d", group.Count())
+	}
+
+	for _, i := range []int{0, 2} {
+		pkg, err := ppacket.Decode(nextPacket(t, agents[i]))
+		if err != nil || len(pkg) != 1 {
+			t.Fatalf("decode packet failed. err = %v", err)
+		}
+
+		msg, err := pmessage.Decode(pkg[0].Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != pmessage.Push || msg.Route != "game.roomHandler.onChat" || string(msg.Data) != `{"msg":"hi"}` {
+			t.Fatalf("msg = %+v", msg)
+		}
+	}
+
+	group.Remove("sid-0")
+	if group.Contains("sid-0") || group.Count() != 1 {
+		t.Fatalf("count = %d, want 1", group.Count())
+	}
+}
+
+func TestOnAgentClosed(t *testing.T) {
+	defer func() {
+		cmd.agentClosedHooks = nil
+	}()
+
+	type closedEvent struct {
+		sid    string
+		reason string
+	}
+	closed := make(chan closedEvent, 4)
+	OnAgentClosed(func(agent *Agent, reason string) {
+		closed <- closedEvent{sid: agent.SID(), reason: reason}
+	})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	agent := NewAgent(nil, conn, &cproto.Session{Sid: "kick-1"})
+	agent.SetState(AgentWorking)
+	agent.Run()
+
+	agent.SendKick(map[string]interface{}{"reason": "banned"})
+
+	// 客户端读取 Kick 包后连接关闭
+	pkgs, _, err := ppacket.Read(peer)
+	if err != nil || len(pkgs) != 1 || pkgs[0].Type() != ppacket.Kick {
+		t.Fatalf("read kick packet failed. pkgs = %v, err = %v", pkgs, err)
+	}
+
+	select {
+	case event := <-closed:
+		if event.sid != "kick-1" || event.reason != CloseReasonKick {
+			t.Fatalf("closed event = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent closed hook not called")
+	}
+
+	if agent.CloseReason() != CloseReasonKick {
+		t.Fatalf("close reason = %s", agent.CloseReason())
+	}
+
+	// 客户端读取 Kick 包后立即断开，仍记录为踢下线
+	conn3, peer3 := net.Pipe()
+	agent3 := NewAgent(nil, conn3, &cproto.Session{Sid: "kick-2"})
+	agent3.SetState(AgentWorking)
+	agent3.Run()
+
+	agent3.SendKick(map[string]interface{}{"reason": "banned"})
+	if pkgs, _, err := ppacket.Read(peer3); err != nil || len(pkgs) != 1 || pkgs[0].Type() != ppacket.Kick {
+		t.Fatalf("read kick packet failed. pkgs = %v, err = %v", pkgs, err)
+	}
+	peer3.Close()
+
+	select {
+	case event := <-closed:
+		if event.sid != "kick-2" || event.reason != CloseReasonKick {
+			t.Fatalf("closed event = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent closed hook not called")
+	}
+
+	// 客户端断开连接
+	conn2, peer2 := net.Pipe()
+	agent2 := NewAgent(nil, conn2, &cproto.Session{Sid: "client-1"})
+	agent2.SetState(AgentWorking)
+	agent2.Run()
+	peer2.Close()
+
+	select {
+	case event := <-closed:
+		if event.sid != "client-1" || event.reason != CloseReasonClient {
+			t.Fatalf("closed event = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent closed hook not called")
+	}
+
+	select {
+	case event := <-closed:
+		t.Fatalf("hook should be called once per agent, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAgentResponseBytes(t *testing.T) {
+	agent := NewAgent(nil, nil, &cproto.Session{Data: map[string]string{}})
+	agent.SetUseDict(false)
+	agent.SetState(AgentWorking)
+
+	if err := agent.ResponseBytes(0, []byte(`{"code":0}`)); err == nil {
+		t.Fatal("notify message has no id, response should fail")
+	}
+	if len(agent.chPending) != 0 {
+		t.Fatalf("response should be dropped, pending = %d", len(agent.chPending))
+	}
+
+	// 请求消息的 ID 由 BuildSession 记录到 session 中
+	BuildSession(&agent, &pmessage.Message{Type: pmessage.Request, ID: 42, Route: "game.hero.info"})
+	if err := agent.ResponseBytes(uint(agent.Session().GetMID()), []byte(`{"code":0}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := ppacket.Decode(nextPacket(t, &agent))
+	if err != nil || len(pkg) != 1 || pkg[0].Type() != ppacket.Data {
+		t.Fatalf("decode packet failed. pkg = %v, err = %v", pkg, err)
+	}
+
+	msg, err := pmessage.Decode(pkg[0].Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != pmessage.Response || msg.ID != 42 || msg.Error {
+		t.Fatalf("message = %v", msg.String())
+	}
+	if string(msg.Data) != `{"code":0}` {
+		t.Fatalf("payload = %s", msg.Data)
+	}
+
+	// 待发送队列已满时返回错误，不阻塞
+	for len(agent.chPending) < cmd.writeBacklog {
+		agent.ResponseMID(1, nil)
+	}
+	if err := agent.ResponseBytes(42, []byte(`{"code":0}`)); err == nil {
+		t.Fatal("send buffer exceed, response should fail")
+	}
+}
+
+func TestAgentSessionValue(t *testing.T) {
+	onDataRoute := cmd.onDataRouteFunc
+	defer func() {
+		cmd.onDataRouteFunc = onDataRoute
+	}()
+
+	// 第一个包保存选择的角色，后续的包读取
+	cmd.onDataRouteFunc = func(agent *Agent, route *pmessage.Route, msg *pmessage.Message) {
+		if route.Method() == "select" {
+			agent.SetSessionValue("role", string(msg.Data))
+			return
+		}
+		if _, found := agent.GetSessionValue("role"); !found {
+			t.Error("role should be stored by previous packet")
+		}
+	}
+
+	newPacket := func(route, data string) *ppacket.Packet {
+		msg, err := pmessage.Encode(&pmessage.Message{Type: pmessage.Notify, Route: route, Data: []byte(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := &ppacket.Packet{}
+		pkg.SetData(msg)
+		return pkg
+	}
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	agent := NewAgent(nil, conn, &cproto.Session{})
+	agent.SetState(AgentWorking)
+
+	dataCommand(&agent, newPacket("game.roleHandler.select", "warrior"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			dataCommand(&agent, newPacket("game.roleHandler.info", ""))
+		}()
+		go func() {
+			defer wg.Done()
+			dataCommand(&agent, newPacket("game.roleHandler.select", "warrior"))
+		}()
+	}
+	wg.Wait()
+
+	if role, found := agent.GetSessionValue("role"); !found || role != "warrior" {
+		t.Fatalf("role = %v, found = %v", role, found)
+	}
+
+	// 断开连接时清空，OnClose 回调中仍可读取
+	var closedRole interface{}
+	agent.AddOnClose(func(a *Agent) {
+		closedRole, _ = a.GetSessionValue("role")
+	})
+	agent.closeProcess()
+
+	if closedRole != "warrior" {
+		t.Fatalf("role in OnClose = %v", closedRole)
+	}
+	if _, found := agent.GetSessionValue("role"); found {
+		t.Fatal("session values should be cleared on disconnect")
+	}
+}
+
+func TestAgentPushBytes(t *testing.T) {
+	pmessage.SetDictionary(map[string]uint16{"game.roomHandler.onPushDict": 201})
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetUseDict(true)
+
+	if err := agent.PushBytes("game.roomHandler.onPushDict", []byte(`{}`)); err == nil {
+		t.Fatal("agent not working, push should fail")
+	}
+
+	agent.SetState(AgentWorking)
+	if err := agent.PushBytes("", []byte(`{}`)); err == nil {
+		t.Fatal("empty route should return error")
+	}
+	if len(agent.chPending) != 0 {
+		t.Fatalf("push should be dropped, pending = %d", len(agent.chPending))
+	}
+
+	tests := []struct {
+		route      string
+		compressed bool
+	}{
+		{"game.roomHandler.onPushDict", true},
+		{"game.roomHandler.onPushRaw", false},
+	}
+
+	for _, tt := range tests {
+		if err := agent.PushBytes(tt.route, []byte(`{"msg":"hi"}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		pkg, err := ppacket.Decode(nextPacket(t, &agent))
+		if err != nil || len(pkg) != 1 || pkg[0].Type() != ppacket.Data {
+			t.Fatalf("decode packet failed. pkg = %v, err = %v", pkg, err)
+		}
+
+		msg, err := pmessage.Decode(pkg[0].Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != pmessage.Push || msg.Route != tt.route || msg.RouteCompressed() != tt.compressed {
+			t.Fatalf("route = %s, message = %v", tt.route, msg.String())
+		}
+		if string(msg.Data) != `{"msg":"hi"}` {
+			t.Fatalf("payload = %s", msg.Data)
+		}
+	}
+}
+
+func TestAgentPushBytesOrder(t *testing.T) {
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetUseDict(false)
+	agent.SetState(AgentWorking)
+	useJSONSerializer(t, &agent)
+
+	routes := []string{"game.roomHandler.onA", "game.roomHandler.onB", "game.roomHandler.onC", "game.roomHandler.onD"}
+	for i, route := range routes {
+		if i%2 == 0 {
+			agent.Push(route, map[string]interface{}{"i": i})
+			continue
+		}
+		if err := agent.PushBytes(route, []byte(fmt.Sprintf(`{"i":%d}`, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, route := range routes {
+		pkg, err := ppacket.Decode(nextPacket(t, &agent))
+		if err != nil || len(pkg) != 1 {
+			t.Fatalf("decode packet failed. pkg = %v, err = %v", pkg, err)
+		}
+
+		msg, err := pmessage.Decode(pkg[0].Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Route != route || string(msg.Data) != fmt.Sprintf(`{"i":%d}`, i) {
+			t.Fatalf("message %d = %v, want route %s", i, msg.String(), route)
+		}
+	}
+}
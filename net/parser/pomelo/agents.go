@@ -6,6 +6,8 @@ import (
 	cerr "github.com/cherry-game/cherry/error"
 	cfacade "github.com/cherry-game/cherry/facade"
 	clog "github.com/cherry-game/cherry/logger"
+	pomeloMessage "github.com/cherry-game/cherry/net/parser/pomelo/message"
+	pomeloPacket "github.com/cherry-game/cherry/net/parser/pomelo/packet"
 )
 
 var (
@@ -158,3 +160,51 @@ func Count() int {
 
 	return count
 }
+
+// BroadcastData 向多个 agent 推送同一条消息，data 为已序列化的消息内容
+// 消息和数据包只编码一次（是否使用字典压缩路由各编码一次），跳过非 AgentWorking 状态的 agent
+// 数据包进入各 agent 的待发送队列，与 Push 等按调用顺序发送；队列已满的 agent 不阻塞，本次不推送
+func BroadcastData(agents []*Agent, route string, data []byte) error {
+	var packets [2][]byte // 0: 路由未压缩, 1: 路由字典压缩
+
+	for _, agent := range agents {
+		if agent == nil || agent.State() != AgentWorking {
+			continue
+		}
+
+		idx := 0
+		if agent.UseDict() {
+			idx = 1
+		}
+
+		if packets[idx] == nil {
+			m := &pomeloMessage.Message{
+				Type:  pomeloMessage.Push,
+				Route: route,
+				Data:  data,
+			}
+
+			em, err := pomeloMessage.EncodeWithDict(m, idx == 1)
+			if err != nil {
+				return err
+			}
+
+			pkg, err := pomeloPacket.Encode(pomeloPacket.Data, em)
+			if err != nil {
+				return err
+			}
+			packets[idx] = pkg
+		}
+
+		pending := &pendingMessage{
+			typ:    pomeloMessage.Push,
+			route:  route,
+			packet: packets[idx],
+		}
+		if err := agent.enqueuePending(pending); err != nil {
+			clog.Warnf("%v [route = %s]", err, route)
+		}
+	}
+
+	return nil
+}
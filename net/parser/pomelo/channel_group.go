@@ -0,0 +1,92 @@
+package pomelo
+
+import (
+	"sync"
+
+	cfacade "github.com/cherry-game/cherry/facade"
+)
+
+// ChannelGroup 按逻辑分组（如房间、公会）管理 agent，向组内成员推送消息
+// 成员的增删和推送可以并发调用，已关闭的成员在推送时移除
+type ChannelGroup struct {
+	name    string
+	mutex   sync.RWMutex
+	members map[cfacade.SID]*Agent
+}
+
+// NewChannelGroup 创建分组
+func NewChannelGroup(name string) *ChannelGroup {
+	return &ChannelGroup{
+		name:    name,
+		members: make(map[cfacade.SID]*Agent),
+	}
+}
+
+// Name 分组名称
+func (g *ChannelGroup) Name() string {
+	return g.name
+}
+
+// Add 添加成员，sid 相同的成员会被替换
+func (g *ChannelGroup) Add(agent *Agent) {
+	if agent == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	g.members[agent.SID()] = agent
+	g.mutex.Unlock()
+}
+
+// Remove 移除成员
+func (g *ChannelGroup) Remove(sid string) {
+	g.mutex.Lock()
+	delete(g.members, sid)
+	g.mutex.Unlock()
+}
+
+// Contains 是否包含成员
+func (g *ChannelGroup) Contains(sid string) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	_, found := g.members[sid]
+	return found
+}
+
+// Count 成员数量，包括尚未移除的已关闭成员
+func (g *ChannelGroup) Count() int {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return len(g.members)
+}
+
+// Push 向组内 AgentWorking 状态的成员推送消息，data 为已序列化的消息内容，只编码一次（见 BroadcastData）
+// 推送时移除已关闭（AgentClosed）的成员，其他状态的成员保留但本次不推送
+func (g *ChannelGroup) Push(route string, data []byte) error {
+	g.mutex.RLock()
+	agents := make([]*Agent, 0, len(g.members))
+	var closed []*Agent
+	for _, agent := range g.members {
+		if agent.State() == AgentClosed {
+			closed = append(closed, agent)
+			continue
+		}
+		agents = append(agents, agent)
+	}
+	g.mutex.RUnlock()
+
+	if len(closed) > 0 {
+		g.mutex.Lock()
+		for _, agent := range closed {
+			// 期间可能以相同 sid 重新添加了成员
+			if g.members[agent.SID()] == agent {
+				delete(g.members, agent.SID())
+			}
+		}
+		g.mutex.Unlock()
+	}
+
+	return BroadcastData(agents, route, data)
+}
@@ -0,0 +1,149 @@
+package pomelo
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	clog "github.com/cherry-game/cherry/logger"
+	ppacket "github.com/cherry-game/cherry/net/parser/pomelo/packet"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	CodeOK        = 200 // 握手成功，与 pomelo 客户端的 RES_OK 一致
+	CodeFail      = 500 // 握手失败，与 pomelo 客户端的 RES_FAIL 一致
+	CodeOldClient = 501 // 客户端版本不在允许范围内，与 pomelo 客户端的 RES_OLD_CLIENT 一致
+)
+
+// SetClientVersionRange 设置允许握手的客户端版本范围（包含边界），min 或 max 为空时该侧不限制
+// 版本号为以 . 分隔的数字，如 1.2.10；配置后握手请求中 sys.version 缺失或不在范围内的客户端
+// 会收到 code=501 的握手响应，随后断开连接。可在运行期间调用，对之后的握手生效
+func SetClientVersionRange(min, max string) {
+	for _, version := range []string{min, max} {
+		if _, ok := parseVersion(version); version != "" && !ok {
+			clog.Warnf("[initCommand] client version format error. [version = %s]", version)
+			return
+		}
+	}
+
+	if min != "" && max != "" && compareVersion(min, max) > 0 {
+		clog.Warnf("[initCommand] client version range error. [min = %s, max = %s]", min, max)
+		return
+	}
+
+	cmd.mutex.Lock()
+	cmd.minClientVersion = min
+	cmd.maxClientVersion = max
+	cmd.mutex.Unlock()
+}
+
+// acceptClientVersion 检查客户端版本是否在允许范围内，未配置范围时全部允许
+func acceptClientVersion(version, min, max string) bool {
+	if min == "" && max == "" {
+		return true
+	}
+
+	if _, ok := parseVersion(version); !ok {
+		return false
+	}
+
+	if min != "" && compareVersion(version, min) < 0 {
+		return false
+	}
+
+	if max != "" && compareVersion(version, max) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// rejectHandshake 回复 code=501 的握手响应，写出后关闭连接
+func rejectHandshake(agent *Agent, version string) {
+	sendHandshakeCode(agent, CodeOldClient, nil)
+
+	if clog.PrintLevel(zapcore.DebugLevel) {
+		clog.Debugf("[sid = %s,uid = %d] Client version rejected. [version = %s, address = %s]",
+			agent.SID(),
+			agent.UID(),
+			version,
+			agent.RemoteAddr(),
+		)
+	}
+}
+
+// sendHandshakeCode 回复非 200 的握手响应，extra 合并到响应的顶层，写出后关闭连接
+func sendHandshakeCode(agent *Agent, code int, extra map[string]interface{}) {
+	// 期间已被踢下线或关闭时不再发送
+	if !atomic.CompareAndSwapInt32(&agent.state, AgentWaitAck, AgentClosing) {
+		return
+	}
+	agent.closeReason.CompareAndSwap(nil, CloseReasonHandshake)
+
+	response := make(map[string]interface{}, len(extra)+1)
+	for key, value := range extra {
+		response[key] = value
+	}
+	response["code"] = code
+
+	data, err := handshakeJSON.Marshal(response)
+	if err != nil {
+		clog.Warn(err)
+		agent.Close()
+		return
+	}
+
+	pkg, err := ppacket.Encode(ppacket.Handshake, data)
+	if err != nil {
+		clog.Warn(err)
+		agent.Close()
+		return
+	}
+
+	agent.SendRaw(pkg)
+}
+
+// parseVersion 将 1.2.10 形式的版本号解析为数字列表
+func parseVersion(version string) ([]int, bool) {
+	if version == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(version, ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		numbers[i] = n
+	}
+
+	return numbers, true
+}
+
+// compareVersion 逐段比较版本号，缺少的段按 0 处理（1.2 == 1.2.0），调用方需保证版本号格式正确
+func compareVersion(a, b string) int {
+	va, _ := parseVersion(a)
+	vb, _ := parseVersion(b)
+
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
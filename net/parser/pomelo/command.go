@@ -1,13 +1,25 @@
 package pomelo
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	cutils "github.com/cherry-game/cherry/extend/utils"
 	cfacade "github.com/cherry-game/cherry/facade"
 	clog "github.com/cherry-game/cherry/logger"
 	pmessage "github.com/cherry-game/cherry/net/parser/pomelo/message"
 	ppacket "github.com/cherry-game/cherry/net/parser/pomelo/packet"
 	pproto "github.com/cherry-game/cherry/net/parser/pomelo/proto"
+	"github.com/fsnotify/fsnotify"
 	jsoniter "github.com/json-iterator/go"
 	"go.uber.org/zap/zapcore"
 )
@@ -17,13 +29,32 @@ type (
 		writeBacklog           int
 		sysData                map[string]interface{}
 		heartbeatTime          time.Duration
+		handshakeTimeout       time.Duration           // 握手后等待 HandshakeAck 的超时时间，<= 0 时不限制
+		maxDataSize            int                     // data 包 payload 的最大字节数
+		dataRate               float64                 // 每个 agent 每秒允许的 data 包数量，<= 0 时不限流
+		dataBurst              int                     // 限流的突发容量
 		handshakeBytes         []byte                  // 完整握手响应（包含协议数据）
 		handshakeBytesNoProtos []byte                  // 不含协议数据的握手响应（版本匹配时使用）
+		handshakeBytesGzip     []byte                  // protos 经 gzip 压缩的握手响应（开启 CompressProtos 且客户端支持时使用）
+		handshakeHandler       HandshakeHandler        // 发送握手响应前调用，可拒绝握手或添加自定义数据
+		agentClosedHooks       []AgentClosedFunc       // agent 关闭时调用的回调
 		heartbeatBytes         []byte
 		onPacketFuncMap        map[ppacket.Type]PacketFunc
 		onDataRouteFunc        DataRouteFunc
+		dataMiddlewares        []DataMiddleware        // data 路由中间件，按注册顺序包裹 onDataRouteFunc
+		dataRouteChain         DataRouteFunc           // 中间件包裹后的 data 路由函数
+		metrics                Metrics                 // 统计钩子
+		routeCache             *pmessage.RouteCache    // data 包路由解析缓存
+		serializers            []cfacade.ISerializer   // 除 app 默认序列化器外，可供客户端在握手时选择的序列化器
+		minClientVersion       string                  // 允许握手的最低客户端版本，为空时不限制
+		maxClientVersion       string                  // 允许握手的最高客户端版本，为空时不限制
+		shuttingDown           int32                   // 1 = 已调用 Shutdown，不再处理新的 data 包和心跳
+		inflight               int64                   // 正在处理的 data 包数量
 		protoOptions           *pproto.Options         // Proto 配置选项
 		protoSchema            *pproto.ProtoSchema     // 解析后的 Proto Schema
+		protoFiles             []string                // 解析过的 proto 文件，用于热更新时监听
+		protoParseErr          error                   // 最近一次解析 proto 文件的错误
+		mutex                  sync.RWMutex            // 保护 sysData、protoSchema、握手和心跳数据
 	}
 
 	// ClientHandshake 客户端握手数据结构
@@ -34,21 +65,45 @@ type (
 
 	// ClientHandshakeSys 客户端握手系统信息
 	ClientHandshakeSys struct {
-		Type         string                 `json:"type"`
-		Version      string                 `json:"version"`
-		ProtoVersion int                    `json:"protoVersion"`
-		RSA          map[string]interface{} `json:"rsa"`
+		Type             string                 `json:"type"`
+		Version          string                 `json:"version"`
+		ProtoVersion     int                    `json:"protoVersion"`
+		RSA              map[string]interface{} `json:"rsa"`
+		UseDict          *bool                  `json:"useDict,omitempty"`          // 是否接受字典压缩的路由，未声明时默认接受
+		Serializer       string                 `json:"serializer,omitempty"`       // 希望使用的序列化器，需在握手响应的 sys.serializers 中
+		ProtosCompressed bool                   `json:"protosCompressed,omitempty"` // 是否支持 gzip 压缩的 protos（base64 编码）
 	}
 
 	PacketFunc    func(agent *Agent, packet *ppacket.Packet)
 	DataRouteFunc func(agent *Agent, route *pmessage.Route, msg *pmessage.Message)
+
+	// DataMiddleware data 路由中间件，用于在路由函数外层添加鉴权、日志、监控等逻辑
+	DataMiddleware func(next DataRouteFunc) DataRouteFunc
+
+	// HandshakeHandler 握手处理函数，req 为客户端的握手数据（无法解析时为空 map）
+	// 返回 200（或 0）时正常握手，extra 合并到握手响应的顶层；返回其他 code 时回复该 code 和 extra 后断开连接
+	HandshakeHandler func(agent *Agent, req map[string]interface{}) (code int, extra map[string]interface{})
+
+	// AgentClosedFunc agent 关闭时的回调，reason 为 CloseReasonClient、CloseReasonKick 等关闭原因
+	AgentClosedFunc func(agent *Agent, reason string)
+)
+
+const (
+	DataHeartbeat        = "heartbeat"
+	DataDict             = "dict"
+	DataSerializer       = "serializer"
+	DataSerializers      = "serializers"      // 可供客户端选择的序列化器名称，第一个为默认序列化器
+	DataProtos           = "protos"           // Protobuf Schema 数据
+	DataProtosCompressed = "protosCompressed" // 为 true 时 protos 为 gzip 压缩后 base64 编码的 JSON
 )
 
 const (
-	DataHeartbeat  = "heartbeat"
-	DataDict       = "dict"
-	DataSerializer = "serializer"
-	DataProtos     = "protos" // Protobuf Schema 数据
+	DefaultMaxDataSize    = 64 * 1024 // data 包 payload 默认的最大字节数
+	DefaultRouteCacheSize = 1024      // 路由解析缓存默认容量
+)
+
+const (
+	protoReloadDelay = 100 * time.Millisecond // 合并 proto 文件变更事件的等待时间
 )
 
 var (
@@ -56,29 +111,39 @@ var (
 		writeBacklog:    64,
 		sysData:         make(map[string]interface{}),
 		heartbeatTime:   60 * time.Second,
+		maxDataSize:     DefaultMaxDataSize,
 		handshakeBytes:  make([]byte, 0),
 		heartbeatBytes:  make([]byte, 0),
 		onPacketFuncMap: make(map[ppacket.Type]PacketFunc, 4),
 		onDataRouteFunc: DefaultDataRoute,
+		metrics:         noopMetrics{},
+		routeCache:      pmessage.NewRouteCache(DefaultRouteCacheSize),
 	}
+
+	// handshakeJSON 序列化握手数据使用的 json 配置，map 按 key 排序，相同的数据生成相同的字节
+	handshakeJSON = jsoniter.Config{EscapeHTML: true, SortMapKeys: true}.Froze()
 )
 
 func (p *Command) init(app cfacade.IApplication) {
+	p.mutex.Lock()
 	p.setData(DataHeartbeat, p.heartbeatTime.Seconds())
 	p.setData(DataDict, pmessage.GetDictionary())
 	p.setData(DataSerializer, app.Serializer().Name())
+	p.setData(DataSerializers, p.serializerNames(app.Serializer()))
 
 	// 解析并设置 Proto Schema
 	p.parseAndSetProtos()
 
 	p.setHandshakeBytes()
 	p.setHeartbeatBytes()
+	p.mutex.Unlock()
 
 	p.setOnPacketFunc()
 }
 
-// parseAndSetProtos 解析 proto 文件并设置到 sysData
+// parseAndSetProtos 解析 proto 文件并设置到 sysData，调用方需持有写锁
 func (p *Command) parseAndSetProtos() {
+	p.protoParseErr = nil
 	if p.protoOptions == nil || !p.protoOptions.HasProtoConfig() {
 		return
 	}
@@ -86,24 +151,86 @@ func (p *Command) parseAndSetProtos() {
 	parser := pproto.NewParser(*p.protoOptions)
 	schema, err := parser.Parse()
 	if err != nil {
-		clog.Errorf("[ProtoParser] 解析 proto 文件失败: %v", err)
-		return
+		p.protoParseErr = err
+
+		// 非严格模式下部分文件解析失败时，仍使用其余文件生成的 schema
+		if errors.Is(err, pproto.ErrNoProtoFiles) {
+			clog.Warnf("[ProtoParser] 配置了 proto 但没有找到 proto 文件，请检查配置是否正确: dirs=%v, files=%v",
+				p.protoOptions.ScanDirs(), p.protoOptions.ProtoFiles)
+			return
+		}
+
+		var parseErr *pproto.ParseError
+		if !errors.As(err, &parseErr) {
+			clog.Errorf("[ProtoParser] 解析 proto 文件失败: %v", err)
+			return
+		}
+		clog.Warnf("[ProtoParser] %v", err)
 	}
 
+	p.protoFiles = parser.GetFiles()
+
 	if schema != nil {
 		p.protoSchema = schema
 		p.setData(DataProtos, schema)
 		clog.Infof("[ProtoParser] Proto Schema 加载成功, version=%d, server routes=%d, client routes=%d",
 			schema.Version, len(schema.Server), len(schema.Client))
+
+		// schema 随握手下发，过大时会拖慢客户端建立连接
+		if limit := p.protoOptions.HandshakeSizeWarn; limit > 0 {
+			if size := schema.EstimateHandshakeSize(); size > limit {
+				clog.Warnf("[ProtoParser] 握手下发的 schema 过大: %d 字节, 超过阈值 %d 字节, 建议开启 GlobalMessages 共享嵌套消息",
+					size, limit)
+			}
+		}
 	}
 }
 
+// serializerNames 返回握手时下发的序列化器名称列表，默认序列化器在前，重名的只保留一个
+func (p *Command) serializerNames(defaultSerializer cfacade.ISerializer) []string {
+	names := []string{defaultSerializer.Name()}
+	for _, serializer := range p.serializers {
+		if !slices.Contains(names, serializer.Name()) {
+			names = append(names, serializer.Name())
+		}
+	}
+	return names
+}
+
+// serializerIndex 按名称查找 AddSerializer 注册的序列化器下标
+func (p *Command) serializerIndex(name string) (int, bool) {
+	for i, serializer := range p.serializers {
+		if serializer.Name() == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// heartbeatInterval 返回当前的心跳间隔
+func (p *Command) heartbeatInterval() time.Duration {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.heartbeatTime
+}
+
+// setData 设置 sysData（已存在时不覆盖），调用方需持有写锁
 func (p *Command) setData(name string, value interface{}) {
 	if _, found := p.sysData[name]; !found {
 		p.sysData[name] = value
 	}
 }
 
+// rebuildHandshake 根据当前 sysData 重新生成握手数据，可在运行期间安全调用
+func (p *Command) rebuildHandshake() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.setHandshakeBytes()
+}
+
+// setHandshakeBytes 生成握手数据，调用方需持有写锁
 func (p *Command) setHandshakeBytes() {
 	// 生成完整握手响应（包含协议数据）
 	handshakeData := map[string]interface{}{
@@ -111,7 +238,7 @@ func (p *Command) setHandshakeBytes() {
 		"sys":  p.sysData,
 	}
 
-	handshakeBytes, err := jsoniter.Marshal(handshakeData)
+	handshakeBytes, err := handshakeJSON.Marshal(handshakeData)
 	if err != nil {
 		clog.Error(err)
 		return
@@ -138,7 +265,7 @@ func (p *Command) setHandshakeBytes() {
 		"sys":  sysDataNoProtos,
 	}
 
-	handshakeBytesNoProtos, err := jsoniter.Marshal(handshakeDataNoProtos)
+	handshakeBytesNoProtos, err := handshakeJSON.Marshal(handshakeDataNoProtos)
 	if err != nil {
 		clog.Error(err)
 		return
@@ -151,8 +278,50 @@ func (p *Command) setHandshakeBytes() {
 	}
 
 	clog.Infof("[initCommand] handshake data (no protos) = %v", handshakeDataNoProtos)
-	clog.Infof("[initCommand] handshake bytes size: with protos=%d, without protos=%d",
-		len(p.handshakeBytes), len(p.handshakeBytesNoProtos))
+
+	p.setHandshakeBytesGzip(sysDataNoProtos)
+
+	clog.Infof("[initCommand] handshake bytes size: with protos=%d, without protos=%d, gzip protos=%d",
+		len(p.handshakeBytes), len(p.handshakeBytesNoProtos), len(p.handshakeBytesGzip))
+}
+
+// setHandshakeBytesGzip 开启 CompressProtos 时生成 protos 经 gzip 压缩的握手数据，调用方需持有写锁
+// sys 为不含 protos 的 sysData 副本
+func (p *Command) setHandshakeBytesGzip(sys map[string]interface{}) {
+	p.handshakeBytesGzip = nil
+
+	schema, ok := p.sysData[DataProtos].(*pproto.ProtoSchema)
+	if !ok || p.protoOptions == nil || !p.protoOptions.CompressProtos {
+		return
+	}
+
+	protos, err := schema.MarshalGzip()
+	if err != nil {
+		clog.Error(err)
+		return
+	}
+
+	// []byte 序列化为 base64 字符串，握手数据仍为合法的 JSON
+	sysDataGzip := make(map[string]interface{}, len(sys)+2)
+	for k, v := range sys {
+		sysDataGzip[k] = v
+	}
+	sysDataGzip[DataProtos] = protos
+	sysDataGzip[DataProtosCompressed] = true
+
+	handshakeBytesGzip, err := handshakeJSON.Marshal(map[string]interface{}{
+		"code": 200,
+		"sys":  sysDataGzip,
+	})
+	if err != nil {
+		clog.Error(err)
+		return
+	}
+
+	p.handshakeBytesGzip, err = ppacket.Encode(ppacket.Handshake, handshakeBytesGzip)
+	if err != nil {
+		clog.Error(err)
+	}
 }
 
 func (p *Command) setHeartbeatBytes() {
@@ -182,50 +351,136 @@ func (p *Command) setOnPacketFunc() {
 }
 
 func handshakeCommand(agent *Agent, pkg *ppacket.Packet) {
-	agent.SetState(AgentWaitAck)
+	// 只处理初始状态的握手包，重复握手、拒绝握手或踢下线后（AgentClosing）收到的握手包忽略
+	if !atomic.CompareAndSwapInt32(&agent.state, AgentInit, AgentWaitAck) {
+		if clog.PrintLevel(zapcore.DebugLevel) {
+			clog.Warnf("[sid = %s,uid = %d] Handshake ignored. [state = %d]",
+				agent.SID(),
+				agent.UID(),
+				agent.State(),
+			)
+		}
+		return
+	}
+
+	cmd.metrics.OnHandshake()
+
+	cmd.mutex.RLock()
+	handshakeBytes := cmd.handshakeBytes
+	handshakeBytesNoProtos := cmd.handshakeBytesNoProtos
+	handshakeBytesGzip := cmd.handshakeBytesGzip
+	protoSchema := cmd.protoSchema
+	minClientVersion, maxClientVersion := cmd.minClientVersion, cmd.maxClientVersion
+	handshakeHandler := cmd.handshakeHandler
+	handshakeTimeout := cmd.handshakeTimeout
+	cmd.mutex.RUnlock()
 
 	// 默认发送完整握手响应
-	responseBytes := cmd.handshakeBytes
+	responseBytes := handshakeBytes
 
-	// 尝试解析客户端握手数据，进行版本号校验
+	// 尝试解析客户端握手数据
+	var clientHandshake ClientHandshake
+	parsed := false
 	if pkg != nil && len(pkg.Data()) > 0 {
-		var clientHandshake ClientHandshake
-		if err := jsoniter.Unmarshal(pkg.Data(), &clientHandshake); err == nil {
-			clientProtoVersion := clientHandshake.Sys.ProtoVersion
-
-			// 获取服务端协议版本号
-			serverProtoVersion := 0
-			if cmd.protoSchema != nil {
-				serverProtoVersion = cmd.protoSchema.Version
+		parsed = jsoniter.Unmarshal(pkg.Data(), &clientHandshake) == nil
+	}
+
+	// 客户端版本不在允许范围内（包括未声明版本），拒绝握手
+	if !acceptClientVersion(clientHandshake.Sys.Version, minClientVersion, maxClientVersion) {
+		rejectHandshake(agent, clientHandshake.Sys.Version)
+		return
+	}
+
+	if parsed {
+		if clientHandshake.Sys.UseDict != nil {
+			agent.SetUseDict(*clientHandshake.Sys.UseDict)
+		}
+
+		// 客户端未声明或声明了不支持的序列化器时，使用 app 默认序列化器
+		if name := clientHandshake.Sys.Serializer; name != "" && !agent.SetSerializer(name) {
+			if clog.PrintLevel(zapcore.DebugLevel) {
+				clog.Debugf("[sid = %s,uid = %d] Unsupported serializer, use default. [serializer = %s, address = %s]",
+					agent.SID(),
+					agent.UID(),
+					name,
+					agent.RemoteAddr(),
+				)
+			}
+		}
+
+		clientProtoVersion := clientHandshake.Sys.ProtoVersion
+
+		// 获取服务端协议版本号
+		serverProtoVersion := 0
+		if protoSchema != nil {
+			serverProtoVersion = protoSchema.Version
+		}
+
+		// 版本号匹配且不为0时，不下发协议数据以节省带宽
+		if clientProtoVersion > 0 && clientProtoVersion == serverProtoVersion {
+			responseBytes = handshakeBytesNoProtos
+			if clog.PrintLevel(zapcore.DebugLevel) {
+				clog.Debugf("[sid = %s,uid = %d] Proto version matched (v%d), skip protos download. [address = %s]",
+					agent.SID(),
+					agent.UID(),
+					clientProtoVersion,
+					agent.RemoteAddr(),
+				)
+			}
+		} else {
+			// 客户端支持时下发 gzip 压缩的 protos
+			if clientHandshake.Sys.ProtosCompressed && len(handshakeBytesGzip) > 0 {
+				responseBytes = handshakeBytesGzip
 			}
 
-			// 版本号匹配且不为0时，不下发协议数据以节省带宽
-			if clientProtoVersion > 0 && clientProtoVersion == serverProtoVersion {
-				responseBytes = cmd.handshakeBytesNoProtos
-				if clog.PrintLevel(zapcore.DebugLevel) {
-					clog.Debugf("[sid = %s,uid = %d] Proto version matched (v%d), skip protos download. [address = %s]",
-						agent.SID(),
-						agent.UID(),
-						clientProtoVersion,
-						agent.RemoteAddr(),
-					)
-				}
+			if clog.PrintLevel(zapcore.DebugLevel) {
+				clog.Debugf("[sid = %s,uid = %d] Proto version mismatch (client=%d, server=%d), sending full protos. [address = %s]",
+					agent.SID(),
+					agent.UID(),
+					clientProtoVersion,
+					serverProtoVersion,
+					agent.RemoteAddr(),
+				)
+			}
+		}
+	}
+
+	if handshakeHandler != nil {
+		req := make(map[string]interface{})
+		if pkg != nil && len(pkg.Data()) > 0 {
+			_ = jsoniter.Unmarshal(pkg.Data(), &req)
+		}
+
+		code, extra := handshakeHandler(agent, req)
+		if code != 0 && code != CodeOK {
+			sendHandshakeCode(agent, code, extra)
+			if clog.PrintLevel(zapcore.DebugLevel) {
+				clog.Debugf("[sid = %s,uid = %d] Handshake rejected by handler. [code = %d, address = %s]",
+					agent.SID(),
+					agent.UID(),
+					code,
+					agent.RemoteAddr(),
+				)
+			}
+			return
+		}
+
+		if len(extra) > 0 {
+			data, err := handshakeWithExtra(responseBytes, extra)
+			if err != nil {
+				clog.Warnf("[sid = %s,uid = %d] Handshake extra data error. [error = %v]", agent.SID(), agent.UID(), err)
 			} else {
-				if clog.PrintLevel(zapcore.DebugLevel) {
-					clog.Debugf("[sid = %s,uid = %d] Proto version mismatch (client=%d, server=%d), sending full protos. [address = %s]",
-						agent.SID(),
-						agent.UID(),
-						clientProtoVersion,
-						serverProtoVersion,
-						agent.RemoteAddr(),
-					)
-				}
+				responseBytes = data
 			}
 		}
 	}
 
 	agent.SendRaw(responseBytes)
 
+	if handshakeTimeout > 0 {
+		agent.startHandshakeTimer(handshakeTimeout)
+	}
+
 	if clog.PrintLevel(zapcore.DebugLevel) {
 		clog.Debugf("[sid = %s,uid = %d] Request handshake. [address = %s]",
 			agent.SID(),
@@ -235,8 +490,56 @@ func handshakeCommand(agent *Agent, pkg *ppacket.Packet) {
 	}
 }
 
+// handshakeWithExtra 将 extra 合并到已编码的握手响应的顶层，code 和 sys 为保留 key，设置时会被忽略
+func handshakeWithExtra(responseBytes []byte, extra map[string]interface{}) ([]byte, error) {
+	pkgs, err := ppacket.Decode(responseBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("handshake packet count error: %d", len(pkgs))
+	}
+
+	var response map[string]jsoniter.RawMessage
+	if err := jsoniter.Unmarshal(pkgs[0].Data(), &response); err != nil {
+		return nil, err
+	}
+
+	for key, value := range extra {
+		if key == "code" || key == "sys" {
+			clog.Warnf("[initCommand] handshake extra key is reserved. [key = %s]", key)
+			continue
+		}
+
+		raw, err := handshakeJSON.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		response[key] = raw
+	}
+
+	data, err := handshakeJSON.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return ppacket.Encode(ppacket.Handshake, data)
+}
+
 func handshakeACKCommand(agent *Agent, _ *ppacket.Packet) {
-	agent.SetState(AgentWorking)
+	// 只有等待确认的 agent 进入 AgentWorking，拒绝握手或踢下线后（AgentClosing）收到的确认包忽略
+	if !atomic.CompareAndSwapInt32(&agent.state, AgentWaitAck, AgentWorking) {
+		if clog.PrintLevel(zapcore.DebugLevel) {
+			clog.Warnf("[sid = %s,uid = %d] HandshakeACK ignored. [state = %d]",
+				agent.SID(),
+				agent.UID(),
+				agent.State(),
+			)
+		}
+		return
+	}
+
+	agent.stopHandshakeTimer()
 
 	if clog.PrintLevel(zapcore.DebugLevel) {
 		clog.Debugf("[sid = %s,uid = %d] request handshakeACK. [address = %s]",
@@ -248,10 +551,31 @@ func handshakeACKCommand(agent *Agent, _ *ppacket.Packet) {
 }
 
 func heartbeatCommand(agent *Agent, _ *ppacket.Packet) {
-	agent.SendRaw(cmd.heartbeatBytes)
+	if cmd.isShuttingDown() {
+		return
+	}
+
+	cmd.metrics.OnHeartbeat()
+
+	cmd.mutex.RLock()
+	heartbeatBytes := cmd.heartbeatBytes
+	cmd.mutex.RUnlock()
+
+	agent.SendRaw(heartbeatBytes)
 }
 
 func dataCommand(agent *Agent, pkg *ppacket.Packet) {
+	if !cmd.beginDispatch() {
+		if clog.PrintLevel(zapcore.DebugLevel) {
+			clog.Warnf("[sid = %s,uid = %d] Data packet dropped, shutting down.",
+				agent.SID(),
+				agent.UID(),
+			)
+		}
+		return
+	}
+	defer cmd.endDispatch()
+
 	if agent.State() != AgentWorking {
 		if clog.PrintLevel(zapcore.DebugLevel) {
 			clog.Warnf("[sid = %s,uid = %d] Data State is not working. [state = %d]",
@@ -263,8 +587,35 @@ func dataCommand(agent *Agent, pkg *ppacket.Packet) {
 		return
 	}
 
+	// 未完成握手的连接直接丢弃 data 包，不消耗限流令牌
+	if cmd.dataRate > 0 && !agent.limiter.allow(time.Now(), cmd.dataRate, cmd.dataBurst) {
+		dropped := atomic.AddInt64(&agent.droppedPackets, 1)
+		if clog.PrintLevel(zapcore.DebugLevel) {
+			clog.Warnf("[sid = %s,uid = %d] Data packet rate limited, dropped. [dropped = %d]",
+				agent.SID(),
+				agent.UID(),
+				dropped,
+			)
+		}
+		return
+	}
+
+	// 超过最大长度的 data 包直接丢弃，避免解码时分配过大的内存
+	if len(pkg.Data()) > cmd.maxDataSize {
+		if clog.PrintLevel(zapcore.DebugLevel) {
+			clog.Warnf("[sid = %s,uid = %d] Data packet size exceeds limit, dropped. [size = %d, limit = %d]",
+				agent.SID(),
+				agent.UID(),
+				len(pkg.Data()),
+				cmd.maxDataSize,
+			)
+		}
+		return
+	}
+
 	msg, err := pmessage.Decode(pkg.Data())
 	if err != nil {
+		cmd.metrics.OnDecodeError()
 		if clog.PrintLevel(zapcore.DebugLevel) {
 			clog.Warnf("[sid = %s,uid = %d] Data message decode error. [data = %s, error = %s]",
 				agent.SID(),
@@ -276,8 +627,9 @@ func dataCommand(agent *Agent, pkg *ppacket.Packet) {
 		return
 	}
 
-	route, err := pmessage.DecodeRoute(msg.Route)
+	route, err := cmd.routeCache.Decode(msg.Route)
 	if err != nil {
+		cmd.metrics.OnDecodeError()
 		if clog.PrintLevel(zapcore.DebugLevel) {
 			clog.Warnf("[sid = %s,uid = %d] Data Message decode route error. [data = %s, error = %s]",
 				agent.SID(),
@@ -289,6 +641,13 @@ func dataCommand(agent *Agent, pkg *ppacket.Packet) {
 		return
 	}
 
+	cmd.metrics.OnData(msg.Route, len(pkg.Data()))
+
+	if cmd.dataRouteChain != nil {
+		cmd.dataRouteChain(agent, route, &msg)
+		return
+	}
+
 	cmd.onDataRouteFunc(agent, route, &msg)
 }
 
@@ -300,13 +659,409 @@ func SetProtoOptions(opts pproto.Options) {
 
 // GetProtoSchema 获取当前的 Proto Schema
 func GetProtoSchema() *pproto.ProtoSchema {
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
 	return cmd.protoSchema
 }
 
+// ProtosEnabled 是否启用了 Proto Schema
+// 配置了 proto 选项并解析成功（非严格模式下部分文件解析失败也视为成功），或通过 SetProtos 等手动设置了 schema 时返回 true
+func ProtosEnabled() bool {
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
+	return cmd.protoSchema != nil
+}
+
+// ProtoParseError 获取最近一次解析 proto 文件的错误，未配置 proto 或解析成功时返回 nil
+// 非严格模式下部分文件解析失败时返回 *pproto.ParseError，此时 ProtosEnabled 仍为 true
+func ProtoParseError() error {
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
+	return cmd.protoParseErr
+}
+
+// EncodeForRoute 按当前 Proto Schema 中路由的定义将 v 编码为 pomelo protobuf 格式
+// 路由的查找规则见 ProtoSchema.Encode，用于在测试中校验与客户端的编解码兼容性
+func EncodeForRoute(route string, v interface{}) ([]byte, error) {
+	schema := GetProtoSchema()
+	if schema == nil {
+		return nil, errors.New("未设置 Proto Schema")
+	}
+	return schema.Encode(route, v)
+}
+
+// DecodeForRoute 按当前 Proto Schema 中路由的定义解码 pomelo protobuf 数据
+func DecodeForRoute(route string, data []byte) (map[string]interface{}, error) {
+	schema := GetProtoSchema()
+	if schema == nil {
+		return nil, errors.New("未设置 Proto Schema")
+	}
+	return schema.Decode(route, data)
+}
+
+// ValidateMessage 按当前 Proto Schema 中客户端路由的定义校验解码后的消息
+// 校验 required 字段以及字段注释中声明的 @min/@max/@required 约束，规则见 ProtoSchema.ValidateMessage
+func ValidateMessage(route string, decoded map[string]interface{}) error {
+	schema := GetProtoSchema()
+	if schema == nil {
+		return errors.New("未设置 Proto Schema")
+	}
+	return schema.ValidateMessage(route, decoded)
+}
+
 // SetProtos 直接设置 Proto Schema（用于手动配置）
+// 在 pomelo Actor 初始化之后调用时，会重新生成握手数据，新的握手请求下发更新后的 schema
 func SetProtos(schema *pproto.ProtoSchema) {
-	if schema != nil {
-		cmd.protoSchema = schema
-		cmd.setData(DataProtos, schema)
+	if schema == nil {
+		return
+	}
+
+	cmd.mutex.Lock()
+	cmd.protoSchema = schema
+	cmd.sysData[DataProtos] = schema
+	initialized := len(cmd.handshakeBytes) > 0
+	cmd.mutex.Unlock()
+
+	if initialized {
+		cmd.rebuildHandshake()
+	}
+}
+
+// MergeProtos 将手写的部分 schema 合并到当前的 Proto Schema（如解析 proto 文件生成的 schema）
+// partial 中的路由新增或覆盖已有路由，__messages__ 取并集，冲突时以 partial 为准并输出警告，
+// 合并后重新计算版本号。应在 pomelo Actor 初始化之后调用，会重新生成握手数据
+func MergeProtos(partial *pproto.ProtoSchema) {
+	if partial == nil {
+		return
+	}
+
+	cmd.mutex.Lock()
+	merged := pproto.MergeSchema(cmd.protoSchema, partial)
+	cmd.protoSchema = merged
+	cmd.sysData[DataProtos] = merged
+	initialized := len(cmd.handshakeBytes) > 0
+	cmd.mutex.Unlock()
+
+	if initialized {
+		cmd.rebuildHandshake()
+	}
+}
+
+// SetHandshakeTimeout 设置握手超时时间，d <= 0 时不限制（默认）
+// 回复握手响应后开始计时，超时仍未收到 HandshakeAck 时关闭连接（关闭原因为 CloseReasonTimeout），对之后的握手生效
+func SetHandshakeTimeout(d time.Duration) {
+	cmd.mutex.Lock()
+	cmd.handshakeTimeout = d
+	cmd.mutex.Unlock()
+}
+
+// SetHeartbeatInterval 设置心跳间隔，同步更新握手响应中的 heartbeat（秒）
+// 间隔必须不小于 1 秒，否则忽略。在 pomelo Actor 初始化之后调用时，会重新生成握手数据
+func SetHeartbeatInterval(d time.Duration) {
+	if d < time.Second {
+		clog.Warnf("[initCommand] heartbeat interval must be at least 1s. [interval = %v]", d)
+		return
+	}
+
+	cmd.mutex.Lock()
+	cmd.heartbeatTime = d
+	cmd.sysData[DataHeartbeat] = d.Seconds()
+	initialized := len(cmd.handshakeBytes) > 0
+	cmd.mutex.Unlock()
+
+	if initialized {
+		cmd.rebuildHandshake()
 	}
 }
+
+// AddDictRoute 添加需要字典压缩的路由，返回分配的编号，编号用尽时返回 0
+// 编号为已有最大编号+1，按相同顺序添加时分配的编号相同，路由已存在时返回已有编号
+// 必须在 pomelo Actor 初始化之前调用
+func AddDictRoute(route string) uint16 {
+	cmd.mutex.Lock()
+	code, _ := pmessage.AddRoute(route)
+	cmd.sysData[DataDict] = pmessage.GetDictionary()
+	cmd.mutex.Unlock()
+
+	return code
+}
+
+// GetDict 返回握手时下发的路由字典（路由 -> 编号）的副本
+func GetDict() map[string]uint16 {
+	// 与 AddDictRoute 修改字典互斥
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
+	dict := make(map[string]uint16, len(pmessage.GetDictionary()))
+	for route, code := range pmessage.GetDictionary() {
+		dict[route] = code
+	}
+	return dict
+}
+
+// SetMaxDataPacketSize 设置 data 包 payload 的最大字节数，超过的包会被丢弃，默认 64KB
+// 必须在 pomelo Actor 初始化之前调用
+func SetMaxDataPacketSize(n int) {
+	if n <= 0 {
+		clog.Warnf("[initCommand] max data packet size must be positive. [size = %d]", n)
+		return
+	}
+
+	cmd.maxDataSize = n
+}
+
+// SetDataRateLimit 设置每个 agent 的 data 包限流（令牌桶），rate 为每秒允许的包数量，burst 为突发容量
+// rate <= 0 时关闭限流（默认），burst < 1 时使用 rate 作为突发容量。必须在 pomelo Actor 初始化之前调用
+func SetDataRateLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = int(math.Max(1, rate))
+	}
+
+	cmd.dataRate = rate
+	cmd.dataBurst = burst
+}
+
+// UseDataMiddleware 注册 data 路由中间件，先注册的中间件在最外层
+// 必须在 pomelo Actor 初始化之前调用
+func UseDataMiddleware(mw DataMiddleware) {
+	if mw == nil {
+		return
+	}
+
+	cmd.dataMiddlewares = append(cmd.dataMiddlewares, mw)
+	cmd.buildDataRouteChain()
+}
+
+// buildDataRouteChain 使用中间件包裹 onDataRouteFunc
+func (p *Command) buildDataRouteChain() {
+	if len(p.dataMiddlewares) == 0 {
+		p.dataRouteChain = nil
+		return
+	}
+
+	chain := p.onDataRouteFunc
+	for i := len(p.dataMiddlewares) - 1; i >= 0; i-- {
+		chain = p.dataMiddlewares[i](chain)
+	}
+	p.dataRouteChain = chain
+}
+
+// AddSerializer 添加可供客户端选择的序列化器，握手响应的 sys.serializers 中会下发所有序列化器名称
+// 客户端在握手请求的 sys.serializer 中声明希望使用的序列化器，未声明或不支持时使用 app 默认序列化器
+// 必须在 pomelo Actor 初始化之前调用
+func AddSerializer(serializers ...cfacade.ISerializer) {
+	for _, serializer := range serializers {
+		if serializer != nil {
+			cmd.serializers = append(cmd.serializers, serializer)
+		}
+	}
+}
+
+// SetHandshakeData 设置握手响应 sys 中的自定义数据（如构建版本、区服）
+// heartbeat、dict、serializer、serializers、protos、protosCompressed 为保留 key，设置时会被忽略
+// 在 pomelo Actor 初始化之后调用时，会重新生成握手数据
+func SetHandshakeData(key string, value interface{}) {
+	switch key {
+	case DataHeartbeat, DataDict, DataSerializer, DataSerializers, DataProtos, DataProtosCompressed:
+		clog.Warnf("[initCommand] handshake data key is reserved. [key = %s]", key)
+		return
+	}
+
+	cmd.mutex.Lock()
+	cmd.sysData[key] = value
+	initialized := len(cmd.handshakeBytes) > 0
+	cmd.mutex.Unlock()
+
+	if initialized {
+		cmd.rebuildHandshake()
+	}
+}
+
+// SetHandshakeHandler 设置握手处理函数，在发送握手响应前调用（客户端版本检查之后），传入 nil 时恢复默认行为
+// 可用于维护期间拒绝握手（如返回 500 和维护公告），或在握手响应中添加自定义数据。可在运行期间调用，对之后的握手生效
+func SetHandshakeHandler(fn HandshakeHandler) {
+	cmd.mutex.Lock()
+	cmd.handshakeHandler = fn
+	cmd.mutex.Unlock()
+}
+
+// OnAgentClosed 注册 agent 关闭时的回调，用于集中处理连接级的清理（如离开 ChannelGroup、保存 session 数据）
+// 回调在 agent 自身的 OnClose 回调之后、解除 uid 绑定之前调用，按注册顺序执行，每个 agent 只调用一次
+func OnAgentClosed(fn AgentClosedFunc) {
+	if fn == nil {
+		return
+	}
+
+	cmd.mutex.Lock()
+	cmd.agentClosedHooks = append(cmd.agentClosedHooks, fn)
+	cmd.mutex.Unlock()
+}
+
+// runAgentClosedHooks 调用 OnAgentClosed 注册的回调，单个回调 panic 不影响其他回调
+func (p *Command) runAgentClosedHooks(agent *Agent, reason string) {
+	p.mutex.RLock()
+	hooks := p.agentClosedHooks
+	p.mutex.RUnlock()
+
+	for _, fn := range hooks {
+		cutils.Try(func() {
+			fn(agent, reason)
+		}, func(errString string) {
+			clog.Warn(errString)
+		})
+	}
+}
+
+// SetProtosFromFile 从 JSON 文件加载预先生成的 Proto Schema
+// 必须在 pomelo Actor 初始化之前调用
+func SetProtosFromFile(path string) error {
+	schema, err := pproto.ReadSchemaFile(path)
+	if err != nil {
+		return err
+	}
+
+	SetProtos(schema)
+	return nil
+}
+
+// WatchProtos 监听 proto 文件变化并热更新 Proto Schema，阻塞直到 ctx 结束
+// 必须在 pomelo Actor 初始化之后调用，一般仅在开发环境使用
+func WatchProtos(ctx context.Context) error {
+	return cmd.WatchProtos(ctx)
+}
+
+// WatchProtos 监听 ProtoDirs/ProtoDir/ProtoFiles 的变化，变化后重新解析并替换 Proto Schema 和握手数据
+// 新的握手请求会下发更新后的 schema，已建立的连接不受影响
+func (p *Command) WatchProtos(ctx context.Context) error {
+	if p.protoOptions == nil || !p.protoOptions.HasProtoConfig() {
+		return fmt.Errorf("未配置 proto 文件")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, dir := range p.protoWatchDirs() {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("监听目录失败: %s, %w", dir, err)
+		}
+	}
+
+	// 编辑器保存文件时通常会产生多个事件，合并短时间内的事件后再重新解析
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watcher.Add(event.Name); err != nil {
+						clog.Warnf("[ProtoParser] 监听目录失败: %s, %v", event.Name, err)
+					}
+					reload = time.After(protoReloadDelay)
+					continue
+				}
+			}
+
+			if p.protoOptions.MatchExtension(event.Name) || p.isManifest(event.Name) {
+				reload = time.After(protoReloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			clog.Warnf("[ProtoParser] 监听 proto 文件出错: %v", err)
+		case <-reload:
+			reload = nil
+			p.reloadProtos()
+
+			// import 可能引入新的目录
+			for _, dir := range p.protoWatchDirs() {
+				if err := watcher.Add(dir); err != nil {
+					clog.Warnf("[ProtoParser] 监听目录失败: %s, %v", dir, err)
+				}
+			}
+		}
+	}
+}
+
+// protoWatchDirs 需要监听的目录：ProtoDirs/ProtoDir 及其子目录、已解析文件所在的目录
+func (p *Command) protoWatchDirs() []string {
+	dirs := make(map[string]bool)
+
+	for _, dir := range p.protoOptions.ScanDirs() {
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				dirs[path] = true
+			}
+			return nil
+		})
+	}
+
+	p.mutex.RLock()
+	for _, file := range p.protoFiles {
+		dirs[filepath.Dir(file)] = true
+	}
+	p.mutex.RUnlock()
+
+	// 清单文件变化时同样需要重新解析
+	if manifest := p.protoOptions.ManifestFile; manifest != "" {
+		dirs[filepath.Dir(manifest)] = true
+	}
+
+	result := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// isManifest 文件是否为配置的 proto 文件清单
+func (p *Command) isManifest(name string) bool {
+	manifest := p.protoOptions.ManifestFile
+	return manifest != "" && filepath.Clean(name) == filepath.Clean(manifest)
+}
+
+// reloadProtos 重新解析 proto 文件，成功后替换 Proto Schema 并重新生成握手数据
+// 解析失败（包括部分文件解析失败）时保留原有的 schema
+func (p *Command) reloadProtos() {
+	parser := pproto.NewParser(*p.protoOptions)
+	schema, err := parser.Parse()
+	if err != nil {
+		p.mutex.Lock()
+		p.protoParseErr = err
+		p.mutex.Unlock()
+
+		clog.Errorf("[ProtoParser] 重新解析 proto 文件失败，保留原有 schema: %v", err)
+		return
+	}
+
+	if schema == nil {
+		clog.Warn("[ProtoParser] 重新解析 proto 文件未生成 schema，保留原有 schema")
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.protoSchema = schema
+	p.protoFiles = parser.GetFiles()
+	p.protoParseErr = nil
+	p.sysData[DataProtos] = schema
+	p.setHandshakeBytes()
+
+	clog.Infof("[ProtoParser] Proto Schema 热更新成功, version=%d, server routes=%d, client routes=%d",
+		schema.Version, len(schema.Server), len(schema.Client))
+}
@@ -0,0 +1,1213 @@
+package pomelo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pmessage "github.com/cherry-game/cherry/net/parser/pomelo/message"
+	ppacket "github.com/cherry-game/cherry/net/parser/pomelo/packet"
+	pproto "github.com/cherry-game/cherry/net/parser/pomelo/proto"
+	cproto "github.com/cherry-game/cherry/net/proto"
+	cserializer "github.com/cherry-game/cherry/net/serializer"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func resetProtos() {
+	cmd.protoOptions = nil
+	cmd.protoFiles = nil
+	cmd.protoSchema = nil
+	cmd.protoParseErr = nil
+	cmd.handshakeBytes = nil
+	cmd.handshakeBytesNoProtos = nil
+	cmd.handshakeBytesGzip = nil
+	delete(cmd.sysData, DataProtos)
+}
+
+func writeSchemaFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestSetProtosFromFile(t *testing.T) {
+	defer resetProtos()
+
+	path := writeSchemaFile(t, `{
+  "version": 3,
+  "server": {"connector.entryHandler.entry": {"optional uInt32 code": 1}},
+  "client": {"connector.entryHandler.entry": {"optional string token": 1}}
+}`)
+
+	if err := SetProtosFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := GetProtoSchema()
+	if schema == nil || schema.Version != 3 {
+		t.Fatalf("schema = %+v", schema)
+	}
+
+	if _, found := schema.Server["connector.entryHandler.entry"]; !found {
+		t.Fatalf("server routes = %v", schema.Server)
+	}
+
+	if cmd.sysData[DataProtos] != schema {
+		t.Fatal("schema not set to handshake sys data")
+	}
+}
+
+func TestSetProtosFromFileMissing(t *testing.T) {
+	defer resetProtos()
+
+	if err := SetProtosFromFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("missing file should return error")
+	}
+
+	if GetProtoSchema() != nil {
+		t.Fatal("schema should not be set")
+	}
+}
+
+func TestSetProtosFromFileInvalid(t *testing.T) {
+	defer resetProtos()
+
+	for _, content := range []string{
+		`{"version": "1", "server": {}}`,
+		`{"version": 1, "server": {"connector.entryHandler.entry": 1}}`,
+		`{"version": 1,`,
+	} {
+		if err := SetProtosFromFile(writeSchemaFile(t, content)); err == nil {
+			t.Fatalf("invalid schema should return error. content = %s", content)
+		}
+	}
+
+	if GetProtoSchema() != nil {
+		t.Fatal("schema should not be set")
+	}
+}
+
+func TestProtosEnabled(t *testing.T) {
+	defer resetProtos()
+
+	// 未配置 proto
+	resetProtos()
+	cmd.parseAndSetProtos()
+	if ProtosEnabled() || ProtoParseError() != nil {
+		t.Fatalf("not configured: enabled = %v, err = %v", ProtosEnabled(), ProtoParseError())
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "hero.proto")
+
+	// 配置了 proto 且解析成功
+	if err := os.WriteFile(file, []byte("message HeroResponse {\n    int32 code = 1;\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := pproto.DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.ServerRoutes["game.hero.info"] = "HeroResponse"
+	SetProtoOptions(opts)
+	cmd.parseAndSetProtos()
+	if !ProtosEnabled() || ProtoParseError() != nil {
+		t.Fatalf("configured: enabled = %v, err = %v", ProtosEnabled(), ProtoParseError())
+	}
+
+	// 配置了 proto 但解析失败
+	resetProtos()
+	if err := os.WriteFile(file, []byte("message HeroResponse {\n    int32 code = x;\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	SetProtoOptions(opts)
+	cmd.parseAndSetProtos()
+	if ProtosEnabled() || ProtoParseError() == nil {
+		t.Fatalf("parse failed: enabled = %v, err = %v", ProtosEnabled(), ProtoParseError())
+	}
+}
+
+func TestWatchProtos(t *testing.T) {
+	defer resetProtos()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "hero.proto")
+	writeProto := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeProto("message HeroResponse {\n    int32 code = 1;\n}\n")
+
+	opts := pproto.DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.hero.info"] = "HeroResponse"
+	SetProtoOptions(opts)
+	cmd.parseAndSetProtos()
+	cmd.rebuildHandshake()
+
+	oldSchema := GetProtoSchema()
+	if oldSchema == nil {
+		t.Fatal("schema should be parsed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- WatchProtos(ctx)
+	}()
+
+	// watcher 启动需要时间，重复写入直到 schema 更新
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		writeProto("message HeroResponse {\n    int32 code = 1;\n    string name = 2;\n}\n")
+		time.Sleep(200 * time.Millisecond)
+
+		if schema := GetProtoSchema(); schema.Version != oldSchema.Version {
+			route := schema.Server["game.hero.info"].(map[string]interface{})
+			if _, found := route["optional string name"]; !found {
+				t.Fatalf("route = %v", route)
+			}
+			if cmd.sysData[DataProtos] != schema {
+				t.Fatal("reloaded schema not set to handshake sys data")
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("schema not reloaded")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetProtosRebuildHandshake(t *testing.T) {
+	defer resetProtos()
+
+	cmd.rebuildHandshake()
+	SetProtos(&pproto.ProtoSchema{
+		Version: 7,
+		Server:  map[string]interface{}{"connector.entryHandler.entry": map[string]interface{}{"optional uInt32 code": 1}},
+		Client:  map[string]interface{}{},
+	})
+
+	pkg, err := ppacket.Decode(cmd.handshakeBytes)
+	if err != nil || len(pkg) != 1 {
+		t.Fatalf("decode handshake failed. err = %v", err)
+	}
+
+	if !strings.Contains(string(pkg[0].Data()), `"version":7`) {
+		t.Fatalf("schema set after init not in handshake. data = %s", pkg[0].Data())
+	}
+}
+
+func TestSetProtosConcurrentHandshake(t *testing.T) {
+	defer resetProtos()
+
+	cmd.rebuildHandshake()
+
+	data, err := ppacket.Encode(ppacket.Handshake, []byte(`{"sys":{"protoVersion":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ppacket.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-agent.chWrite:
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer close(done)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			handshakeCommand(&agent, pkg[0])
+			heartbeatCommand(&agent, nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 200; i++ {
+			SetProtos(&pproto.ProtoSchema{Version: i, Server: map[string]interface{}{}, Client: map[string]interface{}{}})
+		}
+	}()
+	wg.Wait()
+
+	if schema := GetProtoSchema(); schema == nil || schema.Version != 200 {
+		t.Fatalf("schema = %+v", schema)
+	}
+}
+
+func decodeHandshakeSys(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	pkg, err := ppacket.Decode(cmd.handshakeBytes)
+	if err != nil || len(pkg) != 1 {
+		t.Fatalf("decode handshake failed. err = %v", err)
+	}
+
+	var handshake struct {
+		Sys map[string]interface{} `json:"sys"`
+	}
+	if err := jsoniter.Unmarshal(pkg[0].Data(), &handshake); err != nil {
+		t.Fatal(err)
+	}
+
+	return handshake.Sys
+}
+
+func TestSetHandshakeData(t *testing.T) {
+	defer resetProtos()
+	defer delete(cmd.sysData, "region")
+
+	cmd.rebuildHandshake()
+	SetHandshakeData("region", "cn-east")
+	SetHandshakeData(DataSerializer, "xml")
+
+	sys := decodeHandshakeSys(t)
+	if sys["region"] != "cn-east" {
+		t.Fatalf("sys = %v", sys)
+	}
+	if sys[DataSerializer] == "xml" {
+		t.Fatal("reserved key should not be overridden")
+	}
+}
+
+func TestSetHeartbeatInterval(t *testing.T) {
+	defer resetProtos()
+	defer func() {
+		SetHeartbeatInterval(60 * time.Second)
+		delete(cmd.sysData, DataHeartbeat)
+	}()
+
+	cmd.rebuildHandshake()
+	SetHeartbeatInterval(30 * time.Second)
+	SetHeartbeatInterval(500 * time.Millisecond)
+
+	if cmd.heartbeatTime != 30*time.Second {
+		t.Fatalf("heartbeat time = %v", cmd.heartbeatTime)
+	}
+
+	if sys := decodeHandshakeSys(t); sys[DataHeartbeat] != float64(30) {
+		t.Fatalf("sys = %v", sys)
+	}
+}
+
+func TestHandshakeUseDict(t *testing.T) {
+	defer resetProtos()
+
+	cmd.rebuildHandshake()
+
+	data, err := ppacket.Encode(ppacket.Handshake, []byte(`{"sys":{"useDict":false}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ppacket.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	if !agent.UseDict() {
+		t.Fatal("route dictionary should be enabled by default")
+	}
+
+	handshakeCommand(&agent, pkg[0])
+	<-agent.chWrite
+
+	if agent.UseDict() {
+		t.Fatal("client declared useDict=false, route dictionary should be disabled")
+	}
+}
+
+func newDataPacket(t *testing.T, size int) *ppacket.Packet {
+	t.Helper()
+
+	msg, err := pmessage.Encode(&pmessage.Message{
+		Type:  pmessage.Notify,
+		Route: "game.roomHandler.chat",
+		Data:  []byte(strings.Repeat("x", size)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &ppacket.Packet{}
+	pkg.SetData(msg)
+	return pkg
+}
+
+func TestDataCommandMaxDataSize(t *testing.T) {
+	onDataRoute := cmd.onDataRouteFunc
+	defer func() {
+		cmd.onDataRouteFunc = onDataRoute
+		SetMaxDataPacketSize(DefaultMaxDataSize)
+	}()
+
+	var routed int
+	cmd.onDataRouteFunc = func(_ *Agent, _ *pmessage.Route, _ *pmessage.Message) {
+		routed++
+	}
+
+	SetMaxDataPacketSize(1024)
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetState(AgentWorking)
+
+	dataCommand(&agent, newDataPacket(t, 2048))
+	if routed != 0 {
+		t.Fatal("oversized data packet should be dropped")
+	}
+
+	dataCommand(&agent, newDataPacket(t, 16))
+	if routed != 1 {
+		t.Fatalf("routed = %d, want 1", routed)
+	}
+}
+
+func TestDataCommandRateLimit(t *testing.T) {
+	onDataRoute := cmd.onDataRouteFunc
+	defer func() {
+		cmd.onDataRouteFunc = onDataRoute
+		SetDataRateLimit(0, 0)
+	}()
+
+	var routed int64
+	cmd.onDataRouteFunc = func(_ *Agent, _ *pmessage.Route, _ *pmessage.Message) {
+		routed++
+	}
+
+	SetDataRateLimit(1, 5)
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetState(AgentWaitAck)
+
+	// 握手确认前的 data 包直接丢弃，不消耗限流令牌
+	pkg := newDataPacket(t, 16)
+	for i := 0; i < 100; i++ {
+		dataCommand(&agent, pkg)
+	}
+	if routed != 0 || agent.DroppedPackets() != 0 {
+		t.Fatalf("routed = %d, rate limited = %d, want 0", routed, agent.DroppedPackets())
+	}
+
+	agent.SetState(AgentWorking)
+	for i := 0; i < 100; i++ {
+		dataCommand(&agent, pkg)
+	}
+
+	if routed < 5 || routed > 6 {
+		t.Fatalf("routed = %d, want burst 5", routed)
+	}
+	if agent.DroppedPackets() != 100-routed {
+		t.Fatalf("dropped = %d, want %d", agent.DroppedPackets(), 100-routed)
+	}
+
+	// 令牌按时间补充
+	var limiter rateLimiter
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if !limiter.allow(now, 10, 5) {
+			t.Fatalf("packet %d should be allowed by burst", i)
+		}
+	}
+	if limiter.allow(now, 10, 5) {
+		t.Fatal("packet exceeding burst should be dropped")
+	}
+	if !limiter.allow(now.Add(100*time.Millisecond), 10, 5) {
+		t.Fatal("token should be refilled after 100ms")
+	}
+}
+
+func TestUseDataMiddleware(t *testing.T) {
+	onDataRoute := cmd.onDataRouteFunc
+	defer func() {
+		cmd.onDataRouteFunc = onDataRoute
+		cmd.dataMiddlewares = nil
+		cmd.buildDataRouteChain()
+	}()
+
+	var calls []string
+	middleware := func(name string) DataMiddleware {
+		return func(next DataRouteFunc) DataRouteFunc {
+			return func(agent *Agent, route *pmessage.Route, msg *pmessage.Message) {
+				calls = append(calls, name+".before")
+				next(agent, route, msg)
+				calls = append(calls, name+".after")
+			}
+		}
+	}
+
+	UseDataMiddleware(middleware("first"))
+	UseDataMiddleware(middleware("second"))
+
+	actor := &Actor{}
+	actor.SetOnDataRoute(func(_ *Agent, _ *pmessage.Route, _ *pmessage.Message) {
+		calls = append(calls, "handler")
+	})
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetState(AgentWorking)
+	dataCommand(&agent, newDataPacket(t, 16))
+
+	want := []string{"first.before", "second.before", "handler", "second.after", "first.after"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+type fakeMetrics struct {
+	handshakes   int
+	heartbeats   int
+	data         map[string]int
+	decodeErrors int
+}
+
+func (m *fakeMetrics) OnHandshake()                   { m.handshakes++ }
+func (m *fakeMetrics) OnHeartbeat()                   { m.heartbeats++ }
+func (m *fakeMetrics) OnData(route string, bytes int) { m.data[route] += bytes }
+func (m *fakeMetrics) OnDecodeError()                 { m.decodeErrors++ }
+
+func TestSetMetrics(t *testing.T) {
+	onDataRoute := cmd.onDataRouteFunc
+	defer func() {
+		cmd.onDataRouteFunc = onDataRoute
+		SetMetrics(nil)
+	}()
+	cmd.onDataRouteFunc = func(_ *Agent, _ *pmessage.Route, _ *pmessage.Message) {}
+
+	metrics := &fakeMetrics{data: make(map[string]int)}
+	SetMetrics(metrics)
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	handshakeCommand(&agent, nil)
+	heartbeatCommand(&agent, nil)
+	agent.SetState(AgentWorking)
+
+	pkg := newDataPacket(t, 16)
+	dataCommand(&agent, pkg)
+
+	invalid := &ppacket.Packet{}
+	invalid.SetData([]byte{0xff})
+	dataCommand(&agent, invalid)
+
+	if metrics.handshakes != 1 || metrics.heartbeats != 1 || metrics.decodeErrors != 1 {
+		t.Fatalf("metrics = %+v", metrics)
+	}
+	if metrics.data["game.roomHandler.chat"] != len(pkg.Data()) {
+		t.Fatalf("data bytes = %v, want %d", metrics.data, len(pkg.Data()))
+	}
+}
+
+func TestMergeProtos(t *testing.T) {
+	defer resetProtos()
+
+	cmd.rebuildHandshake()
+	SetProtos(&pproto.ProtoSchema{
+		Version: 1,
+		Server: map[string]interface{}{
+			"game.hero.list": map[string]interface{}{
+				"repeated message Hero heroes": 1,
+				pproto.MessagesKey: map[string]interface{}{
+					"Hero": map[string]interface{}{"optional int32 id": 1},
+				},
+			},
+			"game.hero.info": map[string]interface{}{"optional int32 code": 1},
+		},
+		Client: map[string]interface{}{},
+	})
+
+	MergeProtos(&pproto.ProtoSchema{
+		Server: map[string]interface{}{
+			"game.hero.list": map[string]interface{}{
+				"repeated message Hero heroes": 1,
+				"optional message Page page":   2,
+				pproto.MessagesKey: map[string]interface{}{
+					"Page": map[string]interface{}{"optional int32 index": 1},
+				},
+			},
+		},
+		Client: map[string]interface{}{
+			"game.chat.send": map[string]interface{}{"optional string text": 1},
+		},
+	})
+
+	schema := GetProtoSchema()
+	if len(schema.Server) != 2 || len(schema.Client) != 1 {
+		t.Fatalf("merged routes: server = %v, client = %v", schema.Server, schema.Client)
+	}
+
+	list := schema.Server["game.hero.list"].(map[string]interface{})
+	if list["optional message Page page"] != 2 {
+		t.Fatalf("merged route should override parsed route: %v", list)
+	}
+
+	nested := list[pproto.MessagesKey].(map[string]interface{})
+	if _, found := nested["Hero"]; !found {
+		t.Fatalf("nested messages should be unioned: %v", nested)
+	}
+	if _, found := nested["Page"]; !found {
+		t.Fatalf("nested messages should be unioned: %v", nested)
+	}
+
+	if schema.Version == 1 || schema.Version != pproto.SchemaVersion(schema) {
+		t.Fatalf("version should be recomputed, version = %d", schema.Version)
+	}
+
+	handshake := decodeHandshakeSys(t)
+	protos := handshake[DataProtos].(map[string]interface{})
+	if _, found := protos["client"].(map[string]interface{})["game.chat.send"]; !found {
+		t.Fatalf("merged schema not in handshake: %v", protos)
+	}
+}
+
+func TestHandshakeSerializer(t *testing.T) {
+	defer resetProtos()
+	defer func() { cmd.serializers = nil }()
+
+	AddSerializer(cserializer.NewJSON(), cserializer.NewProtobuf())
+	cmd.rebuildHandshake()
+
+	if names := cmd.serializerNames(cserializer.NewProtobuf()); !reflect.DeepEqual(names, []string{"protobuf", "json"}) {
+		t.Fatalf("serializer names = %v", names)
+	}
+
+	handshake := func(body string) *Agent {
+		data, err := ppacket.Encode(ppacket.Handshake, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := ppacket.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		agent := NewAgent(nil, nil, &cproto.Session{})
+		handshakeCommand(&agent, pkg[0])
+		<-agent.chWrite
+		return &agent
+	}
+
+	if agent := handshake(`{"sys":{"serializer":"json"}}`); agent.Serializer().Name() != "json" {
+		t.Fatalf("serializer = %s, want json", agent.Serializer().Name())
+	}
+
+	// 未声明或不支持的序列化器，使用 app 默认序列化器
+	for _, body := range []string{`{"sys":{}}`, `{"sys":{"serializer":"xml"}}`} {
+		if agent := handshake(body); agent.serializerIndex != 0 {
+			t.Fatalf("%s: serializer index = %d, want default", body, agent.serializerIndex)
+		}
+	}
+}
+
+// handshakeThenAck 客户端在一次写入中发送握手、握手确认和 data 包，before 在 agent 运行前调用
+// 返回握手响应的 code，并等待连接关闭；data 包被处理时测试失败
+func handshakeThenAck(t *testing.T, body string, before func(agent *Agent)) int {
+	t.Helper()
+
+	onDataRoute := cmd.onDataRouteFunc
+	defer func() {
+		cmd.onDataRouteFunc = onDataRoute
+	}()
+	cmd.setOnPacketFunc()
+
+	var routed int32
+	cmd.onDataRouteFunc = func(*Agent, *pmessage.Route, *pmessage.Message) {
+		atomic.AddInt32(&routed, 1)
+	}
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	agent := NewAgent(nil, conn, &cproto.Session{})
+	if before != nil {
+		before(&agent)
+	}
+	agent.Run()
+
+	var data []byte
+	msg, err := pmessage.Encode(&pmessage.Message{Type: pmessage.Notify, Route: "game.heroHandler.info", Data: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range []struct {
+		typ  ppacket.Type
+		data []byte
+	}{
+		{ppacket.Handshake, []byte(body)},
+		{ppacket.HandshakeAck, nil},
+		{ppacket.Data, msg},
+	} {
+		encoded, err := ppacket.Encode(pkg.typ, pkg.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, encoded...)
+	}
+	go func() { _, _ = peer.Write(data) }()
+
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pkgs, _, err := ppacket.Read(peer)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatalf("read handshake response failed. pkgs = %v, err = %v", pkgs, err)
+	}
+
+	var result struct {
+		Code int `json:"code"`
+	}
+	if err := jsoniter.Unmarshal(pkgs[0].Data(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	// 响应写出后关闭连接
+	if pkgs, isBreak, err := ppacket.Read(peer); !isBreak || err == nil {
+		t.Fatalf("connection should be closed, pkgs = %v, err = %v", pkgs, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for agent.State() != AgentClosed {
+		if time.Now().After(deadline) {
+			t.Fatalf("agent should be closed, state = %d", agent.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&routed); n != 0 {
+		t.Fatalf("data packet should be dropped, routed = %d", n)
+	}
+	return result.Code
+}
+
+func TestHandshakeClientVersion(t *testing.T) {
+	defer resetProtos()
+	defer SetClientVersionRange("", "")
+
+	cmd.rebuildHandshake()
+	SetClientVersionRange("1.2.0", "2")
+
+	handshake := func(body string) (*Agent, int) {
+		data, err := ppacket.Encode(ppacket.Handshake, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := ppacket.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		agent := NewAgent(nil, nil, &cproto.Session{})
+		handshakeCommand(&agent, pkg[0])
+
+		rsp, err := ppacket.Decode(<-agent.chWrite)
+		if err != nil || len(rsp) != 1 {
+			t.Fatalf("decode handshake response failed. err = %v", err)
+		}
+
+		var result struct {
+			Code int `json:"code"`
+		}
+		if err := jsoniter.Unmarshal(rsp[0].Data(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return &agent, result.Code
+	}
+
+	for _, version := range []string{"1.2", "1.10.3", "2.0.0"} {
+		agent, code := handshake(`{"sys":{"version":"` + version + `"}}`)
+		if code != 200 || agent.State() != AgentWaitAck {
+			t.Fatalf("version %s should be accepted, code = %d, state = %d", version, code, agent.State())
+		}
+	}
+
+	for _, body := range []string{
+		`{"sys":{"version":"1.1.9"}}`,
+		`{"sys":{"version":"2.0.1"}}`,
+		`{"sys":{"version":"beta"}}`,
+		`{"sys":{}}`,
+		``,
+	} {
+		agent, code := handshake(body)
+		if code != CodeOldClient || agent.State() != AgentClosing {
+			t.Fatalf("%q should be rejected, code = %d, state = %d", body, code, agent.State())
+		}
+	}
+
+	// 与握手包一起发送的握手确认不会让被拒绝的连接进入 AgentWorking
+	if code := handshakeThenAck(t, `{"sys":{"version":"1.0"}}`, nil); code != CodeOldClient {
+		t.Fatalf("code = %d, want %d", code, CodeOldClient)
+	}
+
+	// 范围配置错误时忽略
+	SetClientVersionRange("3.0", "2.0")
+	if cmd.minClientVersion != "1.2.0" || cmd.maxClientVersion != "2" {
+		t.Fatalf("invalid range should be ignored, range = [%s, %s]", cmd.minClientVersion, cmd.maxClientVersion)
+	}
+}
+
+func TestHandshakeHandler(t *testing.T) {
+	defer resetProtos()
+	defer SetHandshakeHandler(nil)
+
+	cmd.rebuildHandshake()
+
+	handshake := func(body string) (*Agent, map[string]interface{}) {
+		data, err := ppacket.Encode(ppacket.Handshake, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := ppacket.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		agent := NewAgent(nil, nil, &cproto.Session{})
+		handshakeCommand(&agent, pkg[0])
+
+		rsp, err := ppacket.Decode(<-agent.chWrite)
+		if err != nil || len(rsp) != 1 {
+			t.Fatalf("decode handshake response failed. err = %v", err)
+		}
+
+		var result map[string]interface{}
+		if err := jsoniter.Unmarshal(rsp[0].Data(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return &agent, result
+	}
+
+	// 默认返回 200
+	agent, result := handshake(`{"sys":{"type":"js"}}`)
+	if result["code"] != float64(CodeOK) || result["sys"] == nil || agent.State() != AgentWaitAck {
+		t.Fatalf("default handshake = %v, state = %d", result, agent.State())
+	}
+
+	// 维护期间拒绝握手
+	SetHandshakeHandler(func(agent *Agent, req map[string]interface{}) (int, map[string]interface{}) {
+		return CodeFail, map[string]interface{}{"message": "maintenance"}
+	})
+	agent, result = handshake(`{"sys":{"type":"js"}}`)
+	if result["code"] != float64(CodeFail) || result["message"] != "maintenance" || result["sys"] != nil {
+		t.Fatalf("rejected handshake = %v", result)
+	}
+	if agent.State() != AgentClosing {
+		t.Fatalf("rejected agent state = %d, want closing", agent.State())
+	}
+
+	// 紧跟握手发送的确认包不会让被拒绝的连接进入 AgentWorking
+	if code := handshakeThenAck(t, `{"sys":{"type":"js"}}`, nil); code != CodeFail {
+		t.Fatalf("code = %d, want %d", code, CodeFail)
+	}
+
+	// 添加自定义数据，code 和 sys 为保留 key
+	var gotReq map[string]interface{}
+	SetHandshakeHandler(func(agent *Agent, req map[string]interface{}) (int, map[string]interface{}) {
+		gotReq = req
+		return CodeOK, map[string]interface{}{
+			"user": map[string]interface{}{"region": "cn"},
+			"code": 0,
+		}
+	})
+	agent, result = handshake(`{"sys":{"type":"js"},"user":{"token":"abc"}}`)
+	if result["code"] != float64(CodeOK) || result["sys"] == nil || agent.State() != AgentWaitAck {
+		t.Fatalf("extra handshake = %v, state = %d", result, agent.State())
+	}
+	if user, _ := result["user"].(map[string]interface{}); user["region"] != "cn" {
+		t.Fatalf("extra user = %v", result["user"])
+	}
+	if user, _ := gotReq["user"].(map[string]interface{}); user["token"] != "abc" {
+		t.Fatalf("handler req = %v", gotReq)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	defer resetProtos()
+	defer SetHandshakeTimeout(0)
+
+	cmd.rebuildHandshake()
+	SetHandshakeTimeout(50 * time.Millisecond)
+
+	handshake := func() *Agent {
+		data, err := ppacket.Encode(ppacket.Handshake, []byte(`{"sys":{"type":"js"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := ppacket.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		agent := NewAgent(nil, nil, &cproto.Session{})
+		handshakeCommand(&agent, pkg[0])
+		<-agent.chWrite
+		return &agent
+	}
+
+	// 未回复 HandshakeAck，超时后关闭
+	idle := handshake()
+	deadline := time.Now().Add(2 * time.Second)
+	for idle.State() != AgentClosed {
+		if time.Now().After(deadline) {
+			t.Fatalf("agent should be closed after handshake timeout, state = %d", idle.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if idle.CloseReason() != CloseReasonTimeout {
+		t.Fatalf("close reason = %s, want %s", idle.CloseReason(), CloseReasonTimeout)
+	}
+
+	// 回复 HandshakeAck 后停止计时
+	acked := handshake()
+	handshakeACKCommand(acked, nil)
+	time.Sleep(150 * time.Millisecond)
+	if acked.State() != AgentWorking || acked.CloseReason() != "" {
+		t.Fatalf("acked agent state = %d, reason = %s", acked.State(), acked.CloseReason())
+	}
+
+	// 等待 HandshakeAck 期间关闭，停止计时器
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	closing := handshake()
+	closing.conn = conn
+	closing.Close()
+	closing.closeProcess()
+	if timer, _ := closing.handshakeTimer.Load().(*time.Timer); timer != nil {
+		t.Fatal("handshake timer should be stopped after close")
+	}
+	if closing.CloseReason() != CloseReasonServer {
+		t.Fatalf("close reason = %s, want %s", closing.CloseReason(), CloseReasonServer)
+	}
+}
+
+func TestAddDictRoute(t *testing.T) {
+	defer resetProtos()
+	defer delete(cmd.sysData, DataDict)
+
+	var base uint16
+	for _, code := range GetDict() {
+		if code > base {
+			base = code
+		}
+	}
+
+	hero := AddDictRoute("game.heroHandler.list")
+	chat := AddDictRoute(" game.chatHandler.send ")
+	if hero != base+1 || chat != base+2 {
+		t.Fatalf("codes = %d, %d, want %d, %d", hero, chat, base+1, base+2)
+	}
+	if again := AddDictRoute("game.heroHandler.list"); again != hero {
+		t.Fatalf("registered route should keep its code, got %d", again)
+	}
+
+	dict := GetDict()
+	if dict["game.heroHandler.list"] != hero || dict["game.chatHandler.send"] != chat {
+		t.Fatalf("dict = %v", dict)
+	}
+
+	cmd.rebuildHandshake()
+	sysDict, _ := decodeHandshakeSys(t)[DataDict].(map[string]interface{})
+	if sysDict["game.heroHandler.list"] != float64(hero) || sysDict["game.chatHandler.send"] != float64(chat) {
+		t.Fatalf("handshake dict = %v", sysDict)
+	}
+
+	// 并发读取和添加路由（配合 -race 检查）
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			AddDictRoute(fmt.Sprintf("game.dictHandler.concurrent%d", i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_ = GetDict()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestShutdown(t *testing.T) {
+	onDataRoute := cmd.onDataRouteFunc
+	defer func() {
+		cmd.onDataRouteFunc = onDataRoute
+		atomic.StoreInt32(&cmd.shuttingDown, 0)
+	}()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled int32
+	cmd.onDataRouteFunc = func(_ *Agent, _ *pmessage.Route, _ *pmessage.Message) {
+		if atomic.AddInt32(&handled, 1) == 1 {
+			close(started)
+			<-release
+		}
+	}
+
+	agent := NewAgent(nil, nil, &cproto.Session{})
+	agent.SetState(AgentWorking)
+
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		dataCommand(&agent, newDataPacket(t, 16))
+	}()
+	<-started
+
+	// 慢处理未完成时超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown err = %v, want deadline exceeded", err)
+	}
+
+	// 关闭后新的 data 包和心跳被拒绝
+	dataCommand(&agent, newDataPacket(t, 16))
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Fatalf("new packet should be refused, handled = %d", n)
+	}
+	heartbeatCommand(&agent, nil)
+	if len(agent.chWrite) != 0 {
+		t.Fatal("heartbeat should stop after shutdown")
+	}
+
+	// 慢处理完成后 Shutdown 返回
+	done := make(chan error, 1)
+	go func() { done <- Shutdown(context.Background()) }()
+	close(release)
+	<-slowDone
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Shutdown err = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown should return after in-flight packets complete")
+	}
+}
+
+func TestHandshakeBytesDeterministic(t *testing.T) {
+	defer resetProtos()
+
+	opts := pproto.DefaultOptions()
+	opts.CompressProtos = true
+	SetProtoOptions(opts)
+
+	schema := &pproto.ProtoSchema{
+		Version:  1,
+		Server:   make(map[string]interface{}),
+		Client:   make(map[string]interface{}),
+		Messages: make(map[string]interface{}),
+	}
+	for i := 0; i < 20; i++ {
+		fields := make(map[string]interface{})
+		for j := 0; j < 10; j++ {
+			fields[fmt.Sprintf("optional int32 field%d", j)] = j + 1
+		}
+		route := fmt.Sprintf("game.handler%d.info", i)
+		schema.Server[route] = fields
+		schema.Client[route] = fields
+		schema.Messages[fmt.Sprintf("Message%d", i)] = fields
+	}
+	SetProtos(schema)
+
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	cmd.setHandshakeBytes()
+	first := [][]byte{cmd.handshakeBytes, cmd.handshakeBytesNoProtos, cmd.handshakeBytesGzip}
+
+	for i := 0; i < 5; i++ {
+		cmd.setHandshakeBytes()
+		again := [][]byte{cmd.handshakeBytes, cmd.handshakeBytesNoProtos, cmd.handshakeBytesGzip}
+		for j := range first {
+			if len(first[j]) == 0 || !bytes.Equal(first[j], again[j]) {
+				t.Fatalf("handshake bytes %d should be identical", j)
+			}
+		}
+	}
+
+	// 与 schema 导出的 JSON 一致，key 有序
+	pkg, err := ppacket.Decode(first[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := schema.MarshalClientJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, exported); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(pkg[0].Data(), compact.Bytes()) {
+		t.Fatal("handshake protos should be serialized with sorted keys")
+	}
+}
+
+func TestHandshakeCompressProtos(t *testing.T) {
+	defer resetProtos()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hero.proto"), []byte(`
+syntax = "proto3";
+
+message Hero {
+    int32 configId = 1;
+    string name = 2;
+}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := pproto.DefaultOptions()
+	opts.ProtoDir = dir
+	opts.CompressProtos = true
+	opts.ServerRoutes["game.hero.info"] = "Hero"
+	SetProtoOptions(opts)
+
+	cmd.mutex.Lock()
+	cmd.parseAndSetProtos()
+	cmd.setHandshakeBytes()
+	schema := cmd.protoSchema
+	cmd.mutex.Unlock()
+
+	handshake := func(body string) map[string]interface{} {
+		data, err := ppacket.Encode(ppacket.Handshake, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := ppacket.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		agent := NewAgent(nil, nil, &cproto.Session{})
+		handshakeCommand(&agent, pkg[0])
+
+		rsp, err := ppacket.Decode(<-agent.chWrite)
+		if err != nil || len(rsp) != 1 {
+			t.Fatalf("decode handshake response failed. err = %v", err)
+		}
+
+		var result struct {
+			Sys map[string]interface{} `json:"sys"`
+		}
+		if err := jsoniter.Unmarshal(rsp[0].Data(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result.Sys
+	}
+
+	// 旧客户端仍下发原始 JSON
+	if sys := handshake(`{"sys":{}}`); sys[DataProtosCompressed] != nil {
+		t.Fatalf("old client should receive plain protos, sys = %v", sys)
+	} else if _, ok := sys[DataProtos].(map[string]interface{}); !ok {
+		t.Fatalf("protos = %v", sys[DataProtos])
+	}
+
+	sys := handshake(`{"sys":{"protosCompressed":true}}`)
+	if sys[DataProtosCompressed] != true {
+		t.Fatalf("sys = %v", sys)
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(sys[DataProtos].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := pproto.UnmarshalGzipSchema(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Version != schema.Version || !reflect.DeepEqual(decoded.Server, jsonRoundTrip(t, schema.Server)) {
+		t.Fatalf("decoded schema = %+v, want %+v", decoded, schema)
+	}
+}
+
+// jsonRoundTrip 经过 JSON 序列化再解析，数字统一为 float64，便于与解析结果比较
+func jsonRoundTrip(t *testing.T, v map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	data, err := jsoniter.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result map[string]interface{}
+	if err := jsoniter.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestEncodeDecodeForRoute(t *testing.T) {
+	defer resetProtos()
+
+	if _, err := EncodeForRoute("connector.entryHandler.entry", nil); err == nil {
+		t.Fatal("expected error without schema")
+	}
+
+	SetProtos(&pproto.ProtoSchema{
+		Server: map[string]interface{}{
+			"connector.entryHandler.entry": map[string]interface{}{
+				"optional uInt32 code":  1,
+				"optional string msg":   2,
+				"repeated int32 scores": 3,
+			},
+		},
+	})
+
+	data, err := EncodeForRoute("connector.entryHandler.entry", map[string]interface{}{
+		"code":   200,
+		"msg":    "ok",
+		"scores": []int{-1, 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeForRoute("connector.entryHandler.entry", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"code":   uint32(200),
+		"msg":    "ok",
+		"scores": []interface{}{int32(-1), int32(5)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decode = %#v, want %#v", got, want)
+	}
+}
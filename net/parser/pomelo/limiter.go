@@ -0,0 +1,32 @@
+package pomelo
+
+import (
+	"time"
+)
+
+// rateLimiter 令牌桶限流器
+// 只在 agent 的读协程（dataCommand）中使用，无需加锁，也不会在每个包上分配内存
+type rateLimiter struct {
+	tokens float64   // 当前可用令牌数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// allow 按 rate（每秒令牌数）补充令牌，桶容量为 burst，有可用令牌时消耗一个并返回 true
+func (l *rateLimiter) allow(now time.Time, rate float64, burst int) bool {
+	if l.last.IsZero() {
+		l.tokens = float64(burst)
+	} else if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * rate
+		if l.tokens > float64(burst) {
+			l.tokens = float64(burst)
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
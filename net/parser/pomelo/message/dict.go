@@ -1,6 +1,7 @@
 package pomeloMessage
 
 import (
+	"math"
 	"strings"
 
 	clog "github.com/cherry-game/cherry/logger"
@@ -51,3 +52,30 @@ func GetCode(route string) (uint16, bool) {
 	code, found := routes[route]
 	return code, found
 }
+
+// AddRoute 添加一个需要压缩的路由，返回分配的编号
+// 新路由的编号为当前最大编号+1，按相同顺序添加时分配的编号相同；路由已存在时返回已有编号
+// 编号用尽时返回 false
+func AddRoute(route string) (uint16, bool) {
+	route = strings.TrimSpace(route)
+	if code, found := routes[route]; found {
+		return code, true
+	}
+
+	var next uint32 = 1
+	for code := range codes {
+		if uint32(code) >= next {
+			next = uint32(code) + 1
+		}
+	}
+
+	if next > math.MaxUint16 {
+		clog.Errorf("route dictionary is full(route: %s)", route)
+		return 0, false
+	}
+
+	code := uint16(next)
+	routes[route] = code
+	codes[code] = route
+	return code, true
+}
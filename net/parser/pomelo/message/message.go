@@ -57,6 +57,11 @@ func New() Message {
 	return Message{}
 }
 
+// RouteCompressed 路由是否通过字典压缩
+func (t *Message) RouteCompressed() bool {
+	return t.routeCompressed
+}
+
 func (t *Message) String() string {
 	return fmt.Sprintf(
 		"Type: %s, ID: %d, Route: %s, RouteCompressed: %t, Data: %v, BodyLength: %d, Error:%v",
@@ -84,6 +89,12 @@ func (t *Message) String() string {
 // See ref: https://github.com/lonnng/nano/blob/master/docs/communication_protocol.md
 // See ref: https://github.com/NetEase/pomelo/wiki/%E5%8D%8F%E8%AE%AE%E6%A0%BC%E5%BC%8F
 func Encode(m *Message) ([]byte, error) {
+	return EncodeWithDict(m, true)
+}
+
+// EncodeWithDict 编码消息，useDict 为 true 且路由在字典中时压缩路由，否则使用字符串路由
+// 用于按连接协商是否启用路由压缩
+func EncodeWithDict(m *Message, useDict bool) ([]byte, error) {
 	if InvalidType(m.Type) {
 		return nil, cerr.MessageWrongType
 	}
@@ -91,7 +102,12 @@ func Encode(m *Message) ([]byte, error) {
 	buf := make([]byte, 0)
 	flag := byte(m.Type) << 1
 
-	code, compressed := GetCode(m.Route)
+	var code uint16
+	var compressed bool
+	if useDict {
+		code, compressed = GetCode(m.Route)
+	}
+	m.routeCompressed = compressed
 
 	if compressed {
 		flag |= RouteCompressMask
@@ -184,6 +200,11 @@ func Decode(data []byte) (Message, error) {
 
 	if Routable(m.Type) {
 		if flag&RouteCompressMask == 1 {
+			if offset+2 > len(data) {
+				return nilMessage, cerr.MessageInvalid
+			}
+
+			// 压缩路由通过握手下发的字典还原为字符串路由
 			m.routeCompressed = true
 			code := binary.BigEndian.Uint16(data[offset:(offset + 2)])
 			route, found := GetRoute(code)
@@ -195,6 +216,10 @@ func Decode(data []byte) (Message, error) {
 
 		} else {
 			m.routeCompressed = false
+			if offset >= len(data) || offset+1+int(data[offset]) > len(data) {
+				return nilMessage, cerr.MessageInvalid
+			}
+
 			rl := data[offset]
 			offset++
 			m.Route = string(data[offset:(offset + int(rl))])
@@ -19,3 +19,43 @@ func TestResponseMessageEncode1(t *testing.T) {
 	decode, err := Decode(encode)
 	t.Log(decode, err)
 }
+
+func TestRouteDictionaryCompression(t *testing.T) {
+	SetDictionary(map[string]uint16{"game.dictHandler.hit": 101})
+
+	tests := []struct {
+		route      string
+		useDict    bool
+		compressed bool
+	}{
+		{"game.dictHandler.hit", true, true},
+		{"game.dictHandler.miss", true, false},
+		{"game.dictHandler.hit", false, false},
+	}
+
+	for _, tt := range tests {
+		m := &Message{Type: Push, Route: tt.route, Data: []byte(`{}`)}
+		encode, err := EncodeWithDict(m, tt.useDict)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := encode[0]&RouteCompressMask == RouteCompressMask; got != tt.compressed {
+			t.Fatalf("route = %s, useDict = %v, compressed = %v", tt.route, tt.useDict, got)
+		}
+
+		decode, err := Decode(encode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decode.Route != tt.route || decode.RouteCompressed() != tt.compressed {
+			t.Fatalf("decode route = %s, compressed = %v", decode.Route, decode.RouteCompressed())
+		}
+	}
+
+	// 字典中不存在的压缩路由
+	if _, err := Decode([]byte{byte(Push)<<1 | RouteCompressMask, 0x03, 0xE7}); err == nil {
+		t.Fatal("unknown compressed route should return error")
+	}
+}
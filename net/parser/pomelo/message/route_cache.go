@@ -0,0 +1,76 @@
+package pomeloMessage
+
+import (
+	"container/list"
+	"sync"
+)
+
+// RouteCache 有容量上限的 LRU 路由缓存，避免每个 data 包都重复解析相同的路由字符串
+// Route 创建后不可修改，缓存的 *Route 可以在多个 goroutine 间共享
+type RouteCache struct {
+	capacity int
+	mutex    sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List // 最近使用的在前
+}
+
+type routeCacheEntry struct {
+	key   string
+	route *Route
+}
+
+// NewRouteCache 创建路由缓存，capacity <= 0 时不缓存
+func NewRouteCache(capacity int) *RouteCache {
+	return &RouteCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+// Decode 解析路由，命中缓存时直接返回
+// 解析失败的路由不缓存，超过容量时淘汰最久未使用的路由，防止恶意构造的路由无限占用内存
+func (c *RouteCache) Decode(route string) (*Route, error) {
+	if c.capacity <= 0 {
+		return DecodeRoute(route)
+	}
+
+	c.mutex.Lock()
+	if elem, found := c.items[route]; found {
+		c.order.MoveToFront(elem)
+		r := elem.Value.(*routeCacheEntry).route
+		c.mutex.Unlock()
+		return r, nil
+	}
+	c.mutex.Unlock()
+
+	r, err := DecodeRoute(route)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// 解析期间可能已被其他 goroutine 加入
+	if elem, found := c.items[route]; found {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*routeCacheEntry).route, nil
+	}
+
+	c.items[route] = c.order.PushFront(&routeCacheEntry{key: route, route: r})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*routeCacheEntry).key)
+	}
+
+	return r, nil
+}
+
+// Len 当前缓存的路由数量
+func (c *RouteCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
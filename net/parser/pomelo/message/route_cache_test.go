@@ -0,0 +1,91 @@
+package pomeloMessage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRouteCache(t *testing.T) {
+	cache := NewRouteCache(2)
+
+	first, err := cache.Decode("game.heroHandler.list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.NodeType() != "game" || first.HandleName() != "heroHandler" || first.Method() != "list" {
+		t.Fatalf("route = %v", first)
+	}
+
+	second, _ := cache.Decode("game.heroHandler.list")
+	if second != first {
+		t.Fatal("hot route should be served from cache")
+	}
+
+	for _, route := range []string{"", "game.heroHandler", "game..list"} {
+		if _, err := cache.Decode(route); err == nil {
+			t.Fatalf("route %q should be invalid", route)
+		}
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("invalid routes should not be cached, len = %d", cache.Len())
+	}
+
+	// 超过容量时淘汰最久未使用的路由
+	_, _ = cache.Decode("game.heroHandler.info")
+	_, _ = cache.Decode("game.heroHandler.list")
+	_, _ = cache.Decode("game.heroHandler.upgrade")
+	if cache.Len() != 2 {
+		t.Fatalf("len = %d, want 2", cache.Len())
+	}
+	if again, _ := cache.Decode("game.heroHandler.list"); again != first {
+		t.Fatal("recently used route should stay in cache")
+	}
+	if _, found := cache.items["game.heroHandler.info"]; found {
+		t.Fatal("least recently used route should be evicted")
+	}
+}
+
+func TestRouteCacheConcurrent(t *testing.T) {
+	cache := NewRouteCache(8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				route := fmt.Sprintf("game.handler%d.method", (i+j)%32)
+				r, err := cache.Decode(route)
+				if err != nil || r.String() != route {
+					t.Errorf("decode %s = %v, %v", route, r, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if cache.Len() > 8 {
+		t.Fatalf("len = %d, should not exceed capacity", cache.Len())
+	}
+}
+
+func BenchmarkDecodeRoute(b *testing.B) {
+	routes := []string{"game.heroHandler.list", "game.heroHandler.info", "chat.chatHandler.send", "connector.entryHandler.entry"}
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = DecodeRoute(routes[i%len(routes)])
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		cache := NewRouteCache(1024)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = cache.Decode(routes[i%len(routes)])
+		}
+	})
+}
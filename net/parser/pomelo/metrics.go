@@ -0,0 +1,27 @@
+package pomelo
+
+// Metrics 连接生命周期的统计钩子，用于接入 prometheus 等监控
+// 方法在 agent 的读协程中同步调用，实现需要并发安全且不能阻塞
+type Metrics interface {
+	OnHandshake()                   // 收到握手包
+	OnHeartbeat()                   // 收到心跳包
+	OnData(route string, bytes int) // 收到 data 包，bytes 为 payload 字节数
+	OnDecodeError()                 // data 包解码失败
+}
+
+// noopMetrics 默认的空实现
+type noopMetrics struct{}
+
+func (noopMetrics) OnHandshake()       {}
+func (noopMetrics) OnHeartbeat()       {}
+func (noopMetrics) OnData(string, int) {}
+func (noopMetrics) OnDecodeError()     {}
+
+// SetMetrics 设置统计钩子，m 为 nil 时恢复为空实现
+// 必须在 pomelo Actor 初始化之前调用
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	cmd.metrics = m
+}
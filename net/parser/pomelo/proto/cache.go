@@ -0,0 +1,188 @@
+package pomeloProto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	clog "github.com/cherry-game/cherry/logger"
+)
+
+// schemaCache 磁盘缓存的 schema
+type schemaCache struct {
+	Files  []cachedFile `json:"files"`  // 生成 schema 时解析过的文件（含 import 的文件）
+	Schema *ProtoSchema `json:"schema"` // 解析生成的 schema
+
+	// schema 中不下发给客户端（不参与 JSON 序列化）的字段单独记录
+	ServerMsgNames map[string]string                      `json:"serverMsgNames,omitempty"`
+	ClientMsgNames map[string]string                      `json:"clientMsgNames,omitempty"`
+	StreamRoutes   map[string]RouteStream                 `json:"streamRoutes,omitempty"`
+	Constraints    map[string]map[string]FieldConstraints `json:"constraints,omitempty"`
+}
+
+// cachedFile 缓存中记录的文件内容 hash，用于发现 import 的文件是否变更
+type cachedFile struct {
+	Path string `json:"path"` // 文件路径
+	Disk bool   `json:"disk"` // 是否为磁盘文件，否则为 ProtoFS 中的文件
+	Hash string `json:"hash"` // 文件内容的 sha256
+}
+
+// read 读取文件内容
+func (s protoSource) read() ([]byte, error) {
+	if s.fsys != nil {
+		return fs.ReadFile(s.fsys, s.path)
+	}
+	return os.ReadFile(s.path)
+}
+
+// cacheKey 根据解析选项和所有输入文件的内容计算缓存 key
+// import 的文件在解析前无法确定，由 loadCache 根据缓存中记录的文件 hash 校验
+func (p *Parser) cacheKey(sources []protoSource) (string, error) {
+	// 只影响解析过程、不影响 schema 内容的选项不参与计算
+	opts := p.options
+	opts.ProtoFS = nil
+	opts.CacheDir = ""
+	opts.ParseConcurrency = 0
+	opts.HandshakeSizeWarn = 0
+	opts.CompressProtos = false
+	opts.AllowSharedMessages = nil
+	opts.IgnoreSharedMessages = false
+
+	optsBytes, err := schemaJSON.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write(optsBytes)
+
+	for _, source := range sources {
+		data, err := source.read()
+		if err != nil {
+			return "", err
+		}
+
+		h.Write([]byte{0})
+		h.Write([]byte(source.String()))
+		h.Write([]byte{0})
+		h.Write(data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachePath 缓存文件路径
+func (p *Parser) cachePath(key string) string {
+	return filepath.Join(p.options.CacheDir, "schema-"+key+".json")
+}
+
+// loadCache 加载缓存的 schema，缓存不存在、损坏或 import 的文件已变更时返回 false
+func (p *Parser) loadCache(key string) (*ProtoSchema, bool) {
+	data, err := os.ReadFile(p.cachePath(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var cache schemaCache
+	if err := schemaJSON.Unmarshal(data, &cache); err != nil || cache.Schema == nil {
+		clog.Warnf("[ProtoParser] schema 缓存文件损坏，重新解析: %s", p.cachePath(key))
+		return nil, false
+	}
+
+	var files []string
+	for _, file := range cache.Files {
+		source := protoSource{path: file.Path}
+		if !file.Disk {
+			if p.options.ProtoFS == nil {
+				return nil, false
+			}
+			source.fsys = p.options.ProtoFS
+		}
+
+		hash, err := fileHash(source)
+		if err != nil || hash != file.Hash {
+			return nil, false
+		}
+
+		if file.Disk {
+			files = append(files, file.Path)
+		}
+	}
+
+	cache.Schema.ServerMsgNames = cache.ServerMsgNames
+	cache.Schema.ClientMsgNames = cache.ClientMsgNames
+	cache.Schema.StreamRoutes = cache.StreamRoutes
+	cache.Schema.Constraints = cache.Constraints
+
+	p.files = files
+	return cache.Schema, true
+}
+
+// saveCache 将 schema 和解析过的文件写入缓存，写入失败只输出警告
+func (p *Parser) saveCache(key string, schema *ProtoSchema, sources []protoSource) {
+	cache := schemaCache{
+		Files:          make([]cachedFile, 0, len(sources)),
+		Schema:         schema,
+		ServerMsgNames: schema.ServerMsgNames,
+		ClientMsgNames: schema.ClientMsgNames,
+		StreamRoutes:   schema.StreamRoutes,
+		Constraints:    schema.Constraints,
+	}
+
+	for _, source := range sources {
+		hash, err := fileHash(source)
+		if err != nil {
+			clog.Warnf("[ProtoParser] 写入 schema 缓存失败: %v", err)
+			return
+		}
+
+		cache.Files = append(cache.Files, cachedFile{
+			Path: source.path,
+			Disk: source.fsys == nil,
+			Hash: hash,
+		})
+	}
+
+	data, err := schemaJSON.Marshal(cache)
+	if err != nil {
+		clog.Warnf("[ProtoParser] 写入 schema 缓存失败: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(p.options.CacheDir, 0o755); err != nil {
+		clog.Warnf("[ProtoParser] 写入 schema 缓存失败: %v", err)
+		return
+	}
+
+	// 先写临时文件再重命名，避免多个进程同时启动时读到不完整的缓存
+	tmp, err := os.CreateTemp(p.options.CacheDir, "schema-*.tmp")
+	if err != nil {
+		clog.Warnf("[ProtoParser] 写入 schema 缓存失败: %v", err)
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p.cachePath(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		clog.Warnf("[ProtoParser] 写入 schema 缓存失败: %v", err)
+	}
+}
+
+// fileHash 计算文件内容的 sha256
+func fileHash(source protoSource) (string, error) {
+	data, err := source.read()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
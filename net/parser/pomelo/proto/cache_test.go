@@ -0,0 +1,141 @@
+package pomeloProto
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func cacheFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "schema-*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestParseCache(t *testing.T) {
+	protoDir := t.TempDir()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	writeProtoFile(t, protoDir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = protoDir
+	opts.CacheDir = cacheDir
+	opts.ServerRoutes["game.hero.list"] = "HeroListResponse"
+
+	// 未命中：解析并写入缓存
+	parser := NewParser(opts)
+	first, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parser.GetMessages()) == 0 {
+		t.Fatal("cache miss should parse proto files")
+	}
+	if files := cacheFiles(t, cacheDir); len(files) != 1 {
+		t.Fatalf("cache files = %v, want 1", files)
+	}
+
+	// 命中：直接加载缓存，不解析文件
+	parser = NewParser(opts)
+	second, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parser.GetMessages()) != 0 {
+		t.Fatal("cache hit should skip parsing")
+	}
+	if second.Version != first.Version {
+		t.Fatalf("cached version = %d, want %d", second.Version, first.Version)
+	}
+	if _, found := second.Server["game.hero.list"]; !found {
+		t.Fatalf("cached schema = %v", second.Server)
+	}
+	if !reflect.DeepEqual(second.ServerMsgNames, first.ServerMsgNames) {
+		t.Fatalf("cached message names = %v, want %v", second.ServerMsgNames, first.ServerMsgNames)
+	}
+	if files := parser.GetFiles(); len(files) != 1 || filepath.Base(files[0]) != "hero.proto" {
+		t.Fatalf("cached files = %v", files)
+	}
+}
+
+func TestParseCacheInvalidation(t *testing.T) {
+	protoDir := t.TempDir()
+	cacheDir := t.TempDir()
+	writeProtoFile(t, protoDir, "entry.proto", `
+import "common/item.proto";
+
+message EntryResponse {
+    Item item = 1;
+}
+`)
+	importDir := t.TempDir()
+	writeProtoFile(t, importDir, "common/item.proto", `
+message Item {
+    int32 id = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoFiles = []string{filepath.Join(protoDir, "entry.proto")}
+	opts.ImportPaths = []string{importDir}
+	opts.CacheDir = cacheDir
+	opts.ServerRoutes["connector.entryHandler.entry"] = "EntryResponse"
+
+	first, err := NewParser(opts).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改 import 的文件，缓存 key 不变但缓存失效
+	writeProtoFile(t, importDir, "common/item.proto", `
+message Item {
+    int32 id = 1;
+    int32 count = 2;
+}
+`)
+
+	parser := NewParser(opts)
+	second, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parser.GetMessages()) == 0 {
+		t.Fatal("changed import should invalidate cache")
+	}
+	item := second.Server["connector.entryHandler.entry"].(map[string]interface{})[MessagesKey].(map[string]interface{})["Item"].(map[string]interface{})
+	if item["optional int32 count"] != 2 {
+		t.Fatalf("item schema = %v", item)
+	}
+	if second.Version == first.Version {
+		t.Fatal("version should change with content")
+	}
+
+	// 修改入口文件，生成新的缓存文件
+	writeProtoFile(t, protoDir, "entry.proto", `
+import "common/item.proto";
+
+message EntryResponse {
+    int32 code = 1;
+    Item item = 2;
+}
+`)
+
+	parser = NewParser(opts)
+	third, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parser.GetMessages()) == 0 {
+		t.Fatal("changed file should miss cache")
+	}
+	if third.Server["connector.entryHandler.entry"].(map[string]interface{})["optional int32 code"] != 1 {
+		t.Fatalf("entry schema = %v", third.Server)
+	}
+	if files := cacheFiles(t, cacheDir); len(files) != 2 {
+		t.Fatalf("cache files = %v, want 2", files)
+	}
+}
@@ -0,0 +1,428 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// codecField 从 schema key（"修饰符 类型 字段名" 或 "修饰符 message 类型 字段名"）解析出的字段
+type codecField struct {
+	modifier FieldModifier
+	typ      string // Pomelo 类型，嵌套消息为 message
+	msgName  string // 嵌套消息名称
+	name     string
+	tag      int
+}
+
+// codecScope 编解码一个路由时查找嵌套消息的范围：路由内的 __messages__ 优先，其次为全局 __messages__
+type codecScope struct {
+	nested map[string]interface{}
+	global map[string]interface{}
+}
+
+func (c codecScope) message(name string) (map[string]interface{}, bool) {
+	if msg, ok := c.nested[name].(map[string]interface{}); ok {
+		return msg, true
+	}
+	msg, ok := c.global[name].(map[string]interface{})
+	return msg, ok
+}
+
+// route 查找路由的 schema，服务端路由优先，未找到时查找客户端路由
+func (s *ProtoSchema) route(route string) (map[string]interface{}, codecScope, error) {
+	routeSchema, ok := s.Server[route].(map[string]interface{})
+	if !ok {
+		routeSchema, ok = s.Client[route].(map[string]interface{})
+	}
+	if !ok {
+		return nil, codecScope{}, fmt.Errorf("路由未定义 schema: %s", route)
+	}
+
+	nested, _ := routeSchema[MessagesKey].(map[string]interface{})
+	return routeSchema, codecScope{nested: nested, global: s.Messages}, nil
+}
+
+// Encode 按路由的 schema 将 v 编码为 pomelo protobuf 格式，路由先在 Server 中查找，未找到时查找 Client
+// v 可以是 struct（按 json tag 匹配字段名）或 map，与 pomelo-protobuf 一致，int32/sInt32 等有符号整数按 zigzag 编码，
+// 重复的数值字段按 "标签 + 元素个数 + 元素" 编码。主要用于测试中校验与客户端的编解码兼容性
+func (s *ProtoSchema) Encode(route string, v interface{}) ([]byte, error) {
+	routeSchema, scope, err := s.route(route)
+	if err != nil {
+		return nil, err
+	}
+
+	// 统一转换为 map，数值保持 json.Number 避免精度丢失
+	data, err := schemaJSON.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var value map[string]interface{}
+	decoder := schemaJSON.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("编码的值必须为 struct 或 map: %s, %w", route, err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeMessage(&buf, routeSchema, scope, value); err != nil {
+		return nil, fmt.Errorf("编码失败: %s, %w", route, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode 按路由的 schema 解码 pomelo protobuf 数据，路由的查找规则与 Encode 相同
+// 重复字段解码为 []interface{}，嵌套消息解码为 map[string]interface{}，bytes 解码为 []byte
+func (s *ProtoSchema) Decode(route string, data []byte) (map[string]interface{}, error) {
+	routeSchema, scope, err := s.route(route)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := decodeMessage(data, routeSchema, scope)
+	if err != nil {
+		return nil, fmt.Errorf("解码失败: %s, %w", route, err)
+	}
+
+	return result, nil
+}
+
+// codecFields 按标签号顺序返回消息的字段，忽略 __messages__ 等特殊 key
+func codecFields(msg map[string]interface{}) ([]codecField, error) {
+	fields := make([]codecField, 0, len(msg))
+	for key, rawTag := range msg {
+		if strings.HasPrefix(key, "__") {
+			continue
+		}
+
+		parts := strings.Fields(key)
+		field := codecField{modifier: FieldModifier(parts[0])}
+		switch {
+		case len(parts) == 4 && parts[1] == string(TypeMessage):
+			field.typ, field.msgName, field.name = parts[1], parts[2], parts[3]
+		case len(parts) == 3:
+			field.typ, field.name = parts[1], parts[2]
+		default:
+			return nil, fmt.Errorf("字段 key 格式错误: %s", key)
+		}
+
+		tag, err := toInt64(rawTag)
+		if err != nil {
+			return nil, fmt.Errorf("字段 %s 的标签号错误: %v", key, rawTag)
+		}
+		field.tag = int(tag)
+
+		fields = append(fields, field)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].tag < fields[j].tag
+	})
+
+	return fields, nil
+}
+
+// wireType 字段类型对应的 protobuf wire type
+func wireType(typ string) uint64 {
+	switch FieldType(typ) {
+	case TypeUInt32, TypeInt32, TypeSInt32, TypeUInt64, TypeInt64, TypeSInt64, TypeBool:
+		return 0
+	case TypeDouble:
+		return 1
+	case TypeFloat:
+		return 5
+	default:
+		return 2
+	}
+}
+
+// isSimpleType 重复字段是否按 "元素个数 + 元素" 连续编码（数值类型）
+func isSimpleType(typ string) bool {
+	return typ != string(TypeString) && typ != string(TypeBytes) && typ != string(TypeMessage)
+}
+
+func encodeMessage(buf *bytes.Buffer, msg map[string]interface{}, scope codecScope, value map[string]interface{}) error {
+	fields, err := codecFields(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		fieldValue, found := value[field.name]
+		if !found || fieldValue == nil {
+			if field.modifier == ModifierRequired {
+				return fmt.Errorf("缺少 required 字段: %s", field.name)
+			}
+			continue
+		}
+
+		head := binary.AppendUvarint(nil, uint64(field.tag)<<3|wireType(field.typ))
+
+		if field.modifier != ModifierRepeated {
+			buf.Write(head)
+			if err := encodeValue(buf, field, scope, fieldValue); err != nil {
+				return err
+			}
+			continue
+		}
+
+		items, ok := fieldValue.([]interface{})
+		if !ok {
+			return fmt.Errorf("repeated 字段 %s 的值不是数组", field.name)
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		if isSimpleType(field.typ) {
+			buf.Write(head)
+			buf.Write(binary.AppendUvarint(nil, uint64(len(items))))
+		}
+		for _, item := range items {
+			if !isSimpleType(field.typ) {
+				buf.Write(head)
+			}
+			if err := encodeValue(buf, field, scope, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func encodeValue(buf *bytes.Buffer, field codecField, scope codecScope, value interface{}) error {
+	var scratch [8]byte
+
+	switch FieldType(field.typ) {
+	case TypeUInt32, TypeUInt64:
+		n, err := toUint64(value)
+		if err != nil {
+			return fmt.Errorf("字段 %s: %w", field.name, err)
+		}
+		buf.Write(binary.AppendUvarint(nil, n))
+	case TypeInt32, TypeSInt32, TypeInt64, TypeSInt64:
+		n, err := toInt64(value)
+		if err != nil {
+			return fmt.Errorf("字段 %s: %w", field.name, err)
+		}
+		buf.Write(binary.AppendVarint(nil, n))
+	case TypeBool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("字段 %s 的值不是 bool: %v", field.name, value)
+		}
+		if b {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case TypeFloat:
+		f, err := toFloat64(value)
+		if err != nil {
+			return fmt.Errorf("字段 %s: %w", field.name, err)
+		}
+		binary.LittleEndian.PutUint32(scratch[:4], math.Float32bits(float32(f)))
+		buf.Write(scratch[:4])
+	case TypeDouble:
+		f, err := toFloat64(value)
+		if err != nil {
+			return fmt.Errorf("字段 %s: %w", field.name, err)
+		}
+		binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(f))
+		buf.Write(scratch[:])
+	case TypeString, TypeBytes:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("字段 %s 的值不是字符串: %v", field.name, value)
+		}
+		data := []byte(str)
+		if field.typ == string(TypeBytes) {
+			// []byte 经过 JSON 转换后为 base64 字符串
+			decoded, err := base64.StdEncoding.DecodeString(str)
+			if err != nil {
+				return fmt.Errorf("字段 %s 的值不是 base64: %w", field.name, err)
+			}
+			data = decoded
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+		buf.Write(data)
+	case TypeMessage:
+		msg, ok := scope.message(field.msgName)
+		if !ok {
+			return fmt.Errorf("字段 %s 引用的消息未定义: %s", field.name, field.msgName)
+		}
+		nestedValue, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("字段 %s 的值不是对象: %v", field.name, value)
+		}
+
+		var nested bytes.Buffer
+		if err := encodeMessage(&nested, msg, scope, nestedValue); err != nil {
+			return err
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(nested.Len())))
+		buf.Write(nested.Bytes())
+	default:
+		return fmt.Errorf("字段 %s 的类型不支持: %s", field.name, field.typ)
+	}
+
+	return nil
+}
+
+func decodeMessage(data []byte, msg map[string]interface{}, scope codecScope) (map[string]interface{}, error) {
+	fields, err := codecFields(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	byTag := make(map[int]codecField, len(fields))
+	for _, field := range fields {
+		byTag[field.tag] = field
+	}
+
+	result := make(map[string]interface{}, len(fields))
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		head, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		field, found := byTag[int(head>>3)]
+		if !found {
+			return nil, fmt.Errorf("未定义的标签号: %d", head>>3)
+		}
+
+		if field.modifier != ModifierRepeated {
+			value, err := decodeValue(reader, field, scope)
+			if err != nil {
+				return nil, err
+			}
+			result[field.name] = value
+			continue
+		}
+
+		items, _ := result[field.name].([]interface{})
+		count := uint64(1)
+		if isSimpleType(field.typ) {
+			if count, err = binary.ReadUvarint(reader); err != nil {
+				return nil, err
+			}
+		}
+		for ; count > 0; count-- {
+			value, err := decodeValue(reader, field, scope)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		result[field.name] = items
+	}
+
+	return result, nil
+}
+
+func decodeValue(reader *bytes.Reader, field codecField, scope codecScope) (interface{}, error) {
+	var scratch [8]byte
+
+	switch FieldType(field.typ) {
+	case TypeUInt32:
+		n, err := binary.ReadUvarint(reader)
+		return uint32(n), err
+	case TypeUInt64:
+		return binary.ReadUvarint(reader)
+	case TypeInt32, TypeSInt32:
+		n, err := binary.ReadVarint(reader)
+		return int32(n), err
+	case TypeInt64, TypeSInt64:
+		return binary.ReadVarint(reader)
+	case TypeBool:
+		n, err := binary.ReadUvarint(reader)
+		return n != 0, err
+	case TypeFloat:
+		if _, err := io.ReadFull(reader, scratch[:4]); err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(scratch[:4])), nil
+	case TypeDouble:
+		if _, err := io.ReadFull(reader, scratch[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(scratch[:])), nil
+	}
+
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(reader.Len()) {
+		return nil, fmt.Errorf("字段 %s 的长度超出数据范围: %d", field.name, length)
+	}
+	data := make([]byte, length)
+	_, _ = reader.Read(data)
+
+	switch FieldType(field.typ) {
+	case TypeString:
+		return string(data), nil
+	case TypeBytes:
+		return data, nil
+	case TypeMessage:
+		msg, ok := scope.message(field.msgName)
+		if !ok {
+			return nil, fmt.Errorf("字段 %s 引用的消息未定义: %s", field.name, field.msgName)
+		}
+		return decodeMessage(data, msg, scope)
+	default:
+		return nil, fmt.Errorf("字段 %s 的类型不支持: %s", field.name, field.typ)
+	}
+}
+
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return strconv.ParseInt(v.String(), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case int:
+		return int64(v), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), nil
+		}
+	}
+	return 0, fmt.Errorf("不是整数: %v", value)
+}
+
+func toUint64(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return strconv.ParseUint(v.String(), 10, 64)
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	}
+	n, err := toInt64(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("不是非负整数: %v", value)
+	}
+	return uint64(n), nil
+}
+
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case float64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("不是浮点数: %v", value)
+}
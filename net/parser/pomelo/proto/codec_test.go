@@ -0,0 +1,137 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func buildCodecSchema(t *testing.T, globalMessages bool) *ProtoSchema {
+	t.Helper()
+
+	opts := DefaultOptions()
+	opts.GlobalMessages = globalMessages
+	opts.ServerRoutes["bag.bagHandler.info"] = "BagResponse"
+	parser := NewParser(opts)
+	err := parser.ParseString("bag.proto", `
+syntax = "proto3";
+
+message Item {
+    uint32 id = 1;
+    string name = 2;
+}
+
+message BagResponse {
+    int32 code = 1;
+    int64 gold = 2;
+    bool vip = 3;
+    double rate = 4;
+    float scale = 5;
+    bytes extra = 6;
+    repeated uint32 ids = 7;
+    repeated sint32 deltas = 8;
+    repeated string tags = 9;
+    repeated Item items = 10;
+    Item main = 11;
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestProtoSchemaEncodeDecode(t *testing.T) {
+	type item struct {
+		ID   uint32 `json:"id"`
+		Name string `json:"name"`
+	}
+	type bagResponse struct {
+		Code   int32    `json:"code"`
+		Gold   int64    `json:"gold"`
+		Vip    bool     `json:"vip"`
+		Rate   float64  `json:"rate"`
+		Scale  float32  `json:"scale"`
+		Extra  []byte   `json:"extra"`
+		IDs    []uint32 `json:"ids"`
+		Deltas []int32  `json:"deltas"`
+		Tags   []string `json:"tags"`
+		Items  []item   `json:"items"`
+		Main   *item    `json:"main,omitempty"`
+	}
+
+	value := bagResponse{
+		Code:   -1,
+		Gold:   1 << 40,
+		Vip:    true,
+		Rate:   0.25,
+		Scale:  1.5,
+		Extra:  []byte{0, 1, 2},
+		IDs:    []uint32{1, 300},
+		Deltas: []int32{-2, 2},
+		Tags:   []string{"a", "b"},
+		Items:  []item{{ID: 1, Name: "sword"}, {ID: 2, Name: "shield"}},
+		Main:   &item{ID: 3, Name: "bow"},
+	}
+
+	want := map[string]interface{}{
+		"code":   int32(-1),
+		"gold":   int64(1 << 40),
+		"vip":    true,
+		"rate":   0.25,
+		"scale":  float32(1.5),
+		"extra":  []byte{0, 1, 2},
+		"ids":    []interface{}{uint32(1), uint32(300)},
+		"deltas": []interface{}{int32(-2), int32(2)},
+		"tags":   []interface{}{"a", "b"},
+		"items": []interface{}{
+			map[string]interface{}{"id": uint32(1), "name": "sword"},
+			map[string]interface{}{"id": uint32(2), "name": "shield"},
+		},
+		"main": map[string]interface{}{"id": uint32(3), "name": "bow"},
+	}
+
+	for _, global := range []bool{false, true} {
+		schema := buildCodecSchema(t, global)
+
+		data, err := schema.Encode("bag.bagHandler.info", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// int32 按 zigzag 编码，重复的数值字段为 "标签 + 元素个数 + 元素"
+		if !bytes.HasPrefix(data, []byte{0x08, 0x01}) || !bytes.Contains(data, []byte{0x38, 0x02, 0x01, 0xac, 0x02}) {
+			t.Fatalf("global=%v, data = %x", global, data)
+		}
+
+		got, err := schema.Decode("bag.bagHandler.info", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("global=%v, decode = %#v, want %#v", global, got, want)
+		}
+	}
+}
+
+func TestProtoSchemaEncodeErrors(t *testing.T) {
+	schema := buildCodecSchema(t, false)
+
+	if _, err := schema.Encode("bag.bagHandler.none", map[string]interface{}{}); err == nil {
+		t.Fatal("expected error for unknown route")
+	}
+	if _, err := schema.Encode("bag.bagHandler.info", map[string]interface{}{"ids": 1}); err == nil {
+		t.Fatal("expected error for non-array repeated field")
+	}
+	if _, err := schema.Encode("bag.bagHandler.info", map[string]interface{}{"code": "x"}); err == nil {
+		t.Fatal("expected error for invalid integer")
+	}
+	if _, err := schema.Decode("bag.bagHandler.info", []byte{0x12, 0x05, 'a'}); err == nil {
+		t.Fatal("expected error for truncated data")
+	}
+}
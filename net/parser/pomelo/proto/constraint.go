@@ -0,0 +1,207 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	clog "github.com/cherry-game/cherry/logger"
+)
+
+// constraintRegex 字段注释中的校验约束，如 @min=0、@max=120、@required
+var constraintRegex = regexp.MustCompile(`@(min|max|required)\b(?:\s*=\s*([^\s@]*))?`)
+
+// parseConstraints 从字段的注释中解析校验约束，没有声明约束时返回 nil
+// 约束值无效时严格模式下返回错误，否则输出警告并忽略该约束
+func (p *Parser) parseConstraints(filePath string, msg *ProtoMessage, fieldName, comments string) (*FieldConstraints, error) {
+	matches := constraintRegex.FindAllStringSubmatch(comments, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	constraints := &FieldConstraints{}
+	for _, match := range matches {
+		if match[1] == "required" {
+			constraints.Required = true
+			continue
+		}
+
+		value, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			err = fmt.Errorf("字段校验约束无效: file=%s, message=%s, field=%s, constraint=%s",
+				filePath, msg.FullName(), fieldName, match[0])
+			if p.options.StrictMode {
+				return nil, err
+			}
+			clog.Warnf("[ProtoParser] %v", err)
+			continue
+		}
+
+		if match[1] == "min" {
+			constraints.Min = &value
+		} else {
+			constraints.Max = &value
+		}
+	}
+
+	if *constraints == (FieldConstraints{}) {
+		return nil, nil
+	}
+	return constraints, nil
+}
+
+// routeConstraints 收集路由消息及其引用的嵌套消息中声明的校验约束
+// 路由消息的字段 key 为字段名，嵌套消息的字段 key 为 "消息名.字段名"，消息名与 schema 中的一致
+func (p *Parser) routeConstraints(msg *ProtoMessage) map[string]FieldConstraints {
+	result := make(map[string]FieldConstraints)
+	visited := make(map[string]bool)
+
+	var walk func(prefix string, msg *ProtoMessage)
+	walk = func(prefix string, msg *ProtoMessage) {
+		for _, field := range msg.Fields {
+			if field.Constraints != nil {
+				result[prefix+p.fieldName(field)] = *field.Constraints
+			}
+
+			if field.Type != TypeMessage || visited[field.TypeName] {
+				continue
+			}
+			visited[field.TypeName] = true
+
+			if nested, ok := p.messages[field.TypeName]; ok {
+				walk(p.schemaName(field.TypeName)+".", nested)
+			}
+		}
+	}
+
+	walk("", msg)
+	return result
+}
+
+// ValidateMessage 按客户端路由的 schema 校验解码后的消息
+// required 字段和声明了 @required 的字段必须存在，数值字段需满足 @min/@max 约束（重复字段校验每个元素），
+// 嵌套消息递归校验。decoded 可以是 Decode 的结果，也可以是 JSON 反序列化得到的 map
+func (s *ProtoSchema) ValidateMessage(route string, decoded map[string]interface{}) error {
+	routeSchema, ok := s.Client[route].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("客户端路由未定义 schema: %s", route)
+	}
+
+	// 统一转换为 JSON 的值，数值保持 json.Number
+	data, err := schemaJSON.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+
+	var value map[string]interface{}
+	decoder := schemaJSON.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	nested, _ := routeSchema[MessagesKey].(map[string]interface{})
+	validator := &messageValidator{
+		scope:       codecScope{nested: nested, global: s.Messages},
+		constraints: s.Constraints[route],
+	}
+
+	if err := validator.validate("", "", routeSchema, value); err != nil {
+		return fmt.Errorf("消息校验失败: %s, %w", route, err)
+	}
+	return nil
+}
+
+// messageValidator 校验一个路由的消息
+type messageValidator struct {
+	scope       codecScope
+	constraints map[string]FieldConstraints
+}
+
+// validate 校验消息的字段，msgName 为嵌套消息名称（路由消息为空），path 为字段在消息中的路径，用于错误信息
+func (v *messageValidator) validate(msgName, path string, msg, value map[string]interface{}) error {
+	fields, err := codecFields(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		fieldPath, key := field.name, field.name
+		if path != "" {
+			fieldPath = path + "." + field.name
+		}
+		if msgName != "" {
+			key = msgName + "." + field.name
+		}
+		constraints := v.constraints[key]
+
+		fieldValue := value[field.name]
+		if fieldValue == nil {
+			if field.modifier == ModifierRequired || constraints.Required {
+				return fmt.Errorf("缺少必填字段: %s", fieldPath)
+			}
+			continue
+		}
+
+		values := []interface{}{fieldValue}
+		if field.modifier == ModifierRepeated {
+			array, ok := fieldValue.([]interface{})
+			if !ok {
+				return fmt.Errorf("重复字段 %s 不是数组", fieldPath)
+			}
+			values = array
+		}
+
+		for i, item := range values {
+			itemPath := fieldPath
+			if field.modifier == ModifierRepeated {
+				itemPath = fmt.Sprintf("%s[%d]", fieldPath, i)
+			}
+
+			if field.typ == string(TypeMessage) {
+				nestedMsg, ok := v.scope.message(field.msgName)
+				if !ok {
+					return fmt.Errorf("字段 %s 引用的消息未定义: %s", itemPath, field.msgName)
+				}
+				nestedValue, ok := item.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("字段 %s 不是对象", itemPath)
+				}
+				if err := v.validate(field.msgName, itemPath, nestedMsg, nestedValue); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := checkRange(itemPath, item, constraints); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRange 校验数值是否满足 @min/@max 约束，Int64AsString 时 64 位整数为字符串
+func checkRange(path string, value interface{}, constraints FieldConstraints) error {
+	if constraints.Min == nil && constraints.Max == nil {
+		return nil
+	}
+
+	n, err := toFloat64(value)
+	if s, ok := value.(string); ok {
+		n, err = strconv.ParseFloat(s, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("字段 %s 不是数值: %v", path, value)
+	}
+
+	if constraints.Min != nil && n < *constraints.Min {
+		return fmt.Errorf("字段 %s 的值 %v 小于最小值 %v", path, value, *constraints.Min)
+	}
+	if constraints.Max != nil && n > *constraints.Max {
+		return fmt.Errorf("字段 %s 的值 %v 大于最大值 %v", path, value, *constraints.Max)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package pomeloProto
+
+import (
+	"strings"
+	"testing"
+)
+
+func buildConstraintSchema(t *testing.T) *ProtoSchema {
+	t.Helper()
+
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	opts.ClientRoutes["game.heroHandler.create"] = "CreateHeroRequest"
+	parser := NewParser(opts)
+	err := parser.ParseString("hero.proto", `
+syntax = "proto3";
+
+message Item {
+    uint32 id = 1; // @min=1
+    int32 count = 2; // @min=1 @max=99
+}
+
+message CreateHeroRequest {
+    // @required
+    string name = 1;
+    int32 age = 2; // @min=0 @max=120
+    repeated Item items = 3;
+    double rate = 4; // @max=0.5
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := parser.GetMessage("CreateHeroRequest")
+	if c := msg.Fields[1].Constraints; c == nil || *c.Min != 0 || *c.Max != 120 || c.Required {
+		t.Fatalf("age constraints = %+v", c)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestValidateMessage(t *testing.T) {
+	schema := buildConstraintSchema(t)
+
+	constraints := schema.Constraints["game.heroHandler.create"]
+	if len(constraints) != 5 || !constraints["name"].Required || constraints["Item.count"].Max == nil {
+		t.Fatalf("constraints = %+v", constraints)
+	}
+
+	valid := map[string]interface{}{
+		"name":  "hero",
+		"age":   120,
+		"items": []interface{}{map[string]interface{}{"id": 1, "count": 99}},
+		"rate":  0.5,
+	}
+	if err := schema.ValidateMessage("game.heroHandler.create", valid); err != nil {
+		t.Fatal(err)
+	}
+
+	// 解码结果中的数值类型同样可以校验
+	data, err := schema.Encode("game.heroHandler.create", valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := schema.Decode("game.heroHandler.create", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.ValidateMessage("game.heroHandler.create", decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value map[string]interface{}
+		want  string
+	}{
+		{"out of range", map[string]interface{}{"name": "hero", "age": 121}, "age 的值 121 大于最大值 120"},
+		{"below min", map[string]interface{}{"name": "hero", "age": -1}, "age 的值 -1 小于最小值 0"},
+		{"missing required", map[string]interface{}{"age": 18}, "缺少必填字段: name"},
+		{"nested", map[string]interface{}{"name": "hero", "items": []interface{}{
+			map[string]interface{}{"id": 1, "count": 1},
+			map[string]interface{}{"id": 0, "count": 1},
+		}}, "items[1].id 的值 0 小于最小值 1"},
+		{"not number", map[string]interface{}{"name": "hero", "rate": "x"}, "rate 不是数值"},
+	}
+
+	for _, tt := range tests {
+		err := schema.ValidateMessage("game.heroHandler.create", tt.value)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	if err := schema.ValidateMessage("game.heroHandler.none", valid); err == nil {
+		t.Fatal("expected error for unknown route")
+	}
+
+	// 校验约束只在服务端使用，不下发给客户端
+	data, err = schema.MarshalClientJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "constraints") || strings.Contains(string(data), "required\"") {
+		t.Fatalf("client json should not contain constraints: %s", data)
+	}
+}
+
+func TestParseInvalidConstraint(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	parser := NewParser(opts)
+	err := parser.ParseString("hero.proto", "message Hero {\n    optional int32 age = 1; // @min=abc\n}\n")
+	if err == nil || !strings.Contains(err.Error(), "校验约束无效") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package pomeloProto
+
+import (
+	"strings"
+)
+
+// ChangeType schema 变更类型
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "added"   // 新增
+	ChangeRemoved ChangeType = "removed" // 删除
+	ChangeChanged ChangeType = "changed" // 路由内容变更
+	ChangeRetag   ChangeType = "retag"   // 字段标签号变更
+	ChangeRetype  ChangeType = "retype"  // 字段类型或修饰符变更
+)
+
+// SchemaDiff 两个版本 schema 之间的差异
+type SchemaDiff struct {
+	Routes []*RouteDiff // 有变更的路由（按 server、client、global 分组，组内按路由名称排序）
+}
+
+// RouteDiff 单个路由的差异
+type RouteDiff struct {
+	Side     string       // server、client 或 global（全局 __messages__）
+	Route    string       // 路由名称
+	Change   ChangeType   // 路由新增、删除或变更
+	Fields   []*FieldDiff // 字段差异（Change 为 changed 时有值）
+	Breaking bool         // 是否为破坏性变更
+}
+
+// FieldDiff 单个字段的差异
+type FieldDiff struct {
+	Message  string     // 字段所属的嵌套消息名称，为空表示路由消息本身
+	Name     string     // 字段名称
+	Change   ChangeType // 字段新增、删除、标签号变更或类型变更
+	OldKey   string     // 旧的字段 key
+	NewKey   string     // 新的字段 key
+	OldTag   int        // 旧的标签号
+	NewTag   int        // 新的标签号
+	Breaking bool       // 是否为破坏性变更
+}
+
+// HasBreaking 是否存在破坏性变更
+func (d *SchemaDiff) HasBreaking() bool {
+	for _, route := range d.Routes {
+		if route.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffSchema 比较两个版本的 schema，用于在 CI 中发现破坏性的协议变更
+// 新增路由、新增非 required 字段为非破坏性变更；删除路由、删除字段、修改标签号或类型为破坏性变更
+func DiffSchema(old, new *ProtoSchema) *SchemaDiff {
+	if old == nil {
+		old = &ProtoSchema{}
+	}
+	if new == nil {
+		new = &ProtoSchema{}
+	}
+
+	diff := &SchemaDiff{}
+	diff.Routes = append(diff.Routes, diffRoutes("server", old.Server, new.Server)...)
+	diff.Routes = append(diff.Routes, diffRoutes("client", old.Client, new.Client)...)
+
+	if fields := diffMessages("", old.Messages, new.Messages); len(fields) > 0 {
+		diff.Routes = append(diff.Routes, newChangedRouteDiff("global", MessagesKey, fields))
+	}
+
+	return diff
+}
+
+func diffRoutes(side string, oldRoutes, newRoutes map[string]interface{}) []*RouteDiff {
+	var result []*RouteDiff
+
+	for _, route := range sortedKeys(oldRoutes) {
+		newSchema, found := newRoutes[route]
+		if !found {
+			result = append(result, &RouteDiff{
+				Side:     side,
+				Route:    route,
+				Change:   ChangeRemoved,
+				Breaking: true,
+			})
+			continue
+		}
+
+		oldMap, _ := oldRoutes[route].(map[string]interface{})
+		newMap, _ := newSchema.(map[string]interface{})
+		if fields := diffMessage("", oldMap, newMap); len(fields) > 0 {
+			result = append(result, newChangedRouteDiff(side, route, fields))
+		}
+	}
+
+	for _, route := range sortedKeys(newRoutes) {
+		if _, found := oldRoutes[route]; !found {
+			result = append(result, &RouteDiff{
+				Side:   side,
+				Route:  route,
+				Change: ChangeAdded,
+			})
+		}
+	}
+
+	return result
+}
+
+func newChangedRouteDiff(side, route string, fields []*FieldDiff) *RouteDiff {
+	routeDiff := &RouteDiff{
+		Side:   side,
+		Route:  route,
+		Change: ChangeChanged,
+		Fields: fields,
+	}
+
+	for _, field := range fields {
+		if field.Breaking {
+			routeDiff.Breaking = true
+			break
+		}
+	}
+
+	return routeDiff
+}
+
+// diffMessage 比较单个消息的字段，以及其 __messages__ 中的嵌套消息
+func diffMessage(msgName string, oldMsg, newMsg map[string]interface{}) []*FieldDiff {
+	oldFields := schemaFields(oldMsg)
+	newFields := schemaFields(newMsg)
+
+	var result []*FieldDiff
+
+	for _, name := range sortedKeys(oldFields) {
+		oldField := oldFields[name]
+		newField, found := newFields[name]
+		if !found {
+			result = append(result, &FieldDiff{
+				Message:  msgName,
+				Name:     name,
+				Change:   ChangeRemoved,
+				OldKey:   oldField.key,
+				OldTag:   oldField.tag,
+				Breaking: true,
+			})
+			continue
+		}
+
+		change := ChangeType("")
+		if oldField.tag != newField.tag {
+			change = ChangeRetag
+		} else if oldField.key != newField.key {
+			change = ChangeRetype
+		}
+
+		if change != "" {
+			result = append(result, &FieldDiff{
+				Message:  msgName,
+				Name:     name,
+				Change:   change,
+				OldKey:   oldField.key,
+				NewKey:   newField.key,
+				OldTag:   oldField.tag,
+				NewTag:   newField.tag,
+				Breaking: true,
+			})
+		}
+	}
+
+	for _, name := range sortedKeys(newFields) {
+		if _, found := oldFields[name]; found {
+			continue
+		}
+
+		newField := newFields[name]
+		result = append(result, &FieldDiff{
+			Message:  msgName,
+			Name:     name,
+			Change:   ChangeAdded,
+			NewKey:   newField.key,
+			NewTag:   newField.tag,
+			Breaking: newField.modifier == string(ModifierRequired),
+		})
+	}
+
+	oldNested, _ := oldMsg[MessagesKey].(map[string]interface{})
+	newNested, _ := newMsg[MessagesKey].(map[string]interface{})
+	result = append(result, diffMessages(msgName, oldNested, newNested)...)
+
+	return result
+}
+
+// diffMessages 比较 __messages__ 中的嵌套消息
+func diffMessages(parent string, oldMessages, newMessages map[string]interface{}) []*FieldDiff {
+	var result []*FieldDiff
+
+	names := make(map[string]bool, len(oldMessages)+len(newMessages))
+	for name := range oldMessages {
+		names[name] = true
+	}
+	for name := range newMessages {
+		names[name] = true
+	}
+
+	for _, name := range sortedKeys(names) {
+		msgName := name
+		if parent != "" {
+			msgName = parent + "." + name
+		}
+
+		oldMsg, _ := oldMessages[name].(map[string]interface{})
+		newMsg, _ := newMessages[name].(map[string]interface{})
+		result = append(result, diffMessage(msgName, oldMsg, newMsg)...)
+	}
+
+	return result
+}
+
+// schemaField 从字段 key 中解析出的字段信息
+type schemaField struct {
+	key      string
+	modifier string
+	tag      int
+}
+
+// schemaFields 解析消息 schema 中的字段，key 为字段名称
+func schemaFields(msg map[string]interface{}) map[string]schemaField {
+	fields := make(map[string]schemaField, len(msg))
+	for key, value := range msg {
+		if strings.HasPrefix(key, "__") {
+			continue
+		}
+
+		parts := strings.Fields(key)
+		if len(parts) < 3 {
+			continue
+		}
+
+		fields[parts[len(parts)-1]] = schemaField{
+			key:      key,
+			modifier: parts[0],
+			tag:      schemaTag(value),
+		}
+	}
+	return fields
+}
+
+// schemaTag 读取字段标签号，兼容解析生成的 int 和 JSON 加载的 float64
+func schemaTag(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package pomeloProto
+
+import (
+	"testing"
+)
+
+func diffTestSchema(fields map[string]interface{}) *ProtoSchema {
+	return &ProtoSchema{
+		Version: 1,
+		Server: map[string]interface{}{
+			"game.heroHandler.get": fields,
+		},
+	}
+}
+
+func TestDiffSchemaRetag(t *testing.T) {
+	old := diffTestSchema(map[string]interface{}{
+		"optional int32 id":    1,
+		"optional string name": 2,
+	})
+	new := diffTestSchema(map[string]interface{}{
+		"optional int32 id":    1,
+		"optional string name": 3,
+	})
+
+	diff := DiffSchema(old, new)
+	if !diff.HasBreaking() || len(diff.Routes) != 1 {
+		t.Fatalf("diff = %+v", diff.Routes)
+	}
+
+	fields := diff.Routes[0].Fields
+	if len(fields) != 1 || fields[0].Change != ChangeRetag || fields[0].OldTag != 2 || fields[0].NewTag != 3 {
+		t.Fatalf("fields = %+v", fields)
+	}
+}
+
+func TestDiffSchemaAddOptionalField(t *testing.T) {
+	old := diffTestSchema(map[string]interface{}{
+		"optional int32 id": 1,
+	})
+	new := diffTestSchema(map[string]interface{}{
+		"optional int32 id":    1,
+		"optional string name": 2,
+	})
+
+	diff := DiffSchema(old, new)
+	if diff.HasBreaking() || len(diff.Routes) != 1 {
+		t.Fatalf("diff = %+v", diff.Routes)
+	}
+
+	fields := diff.Routes[0].Fields
+	if len(fields) != 1 || fields[0].Change != ChangeAdded || fields[0].Name != "name" {
+		t.Fatalf("fields = %+v", fields)
+	}
+}
+
+func TestDiffSchemaRemoveField(t *testing.T) {
+	old := diffTestSchema(map[string]interface{}{
+		"optional int32 id":          1,
+		"repeated message Hero list": 2,
+		MessagesKey: map[string]interface{}{
+			"Hero": map[string]interface{}{
+				"optional int32 hp":  1,
+				"optional int32 atk": 2,
+			},
+		},
+	})
+	new := diffTestSchema(map[string]interface{}{
+		"optional int32 id":          1,
+		"repeated message Hero list": 2,
+		MessagesKey: map[string]interface{}{
+			"Hero": map[string]interface{}{
+				"optional int32 hp": float64(1), // 从 JSON 加载的 schema
+			},
+		},
+	})
+
+	diff := DiffSchema(old, new)
+	if !diff.HasBreaking() || len(diff.Routes) != 1 {
+		t.Fatalf("diff = %+v", diff.Routes)
+	}
+
+	fields := diff.Routes[0].Fields
+	if len(fields) != 1 || fields[0].Change != ChangeRemoved || fields[0].Message != "Hero" || fields[0].Name != "atk" {
+		t.Fatalf("fields = %+v", fields)
+	}
+}
+
+func TestDiffSchemaRoutes(t *testing.T) {
+	old := diffTestSchema(map[string]interface{}{"optional int32 id": 1})
+	new := &ProtoSchema{
+		Server: map[string]interface{}{
+			"game.heroHandler.list": map[string]interface{}{"optional int32 id": 1},
+		},
+	}
+
+	diff := DiffSchema(old, new)
+	if !diff.HasBreaking() || len(diff.Routes) != 2 {
+		t.Fatalf("diff = %+v", diff.Routes)
+	}
+
+	if diff.Routes[0].Change != ChangeRemoved || !diff.Routes[0].Breaking {
+		t.Fatalf("removed route = %+v", diff.Routes[0])
+	}
+
+	if diff.Routes[1].Change != ChangeAdded || diff.Routes[1].Breaking {
+		t.Fatalf("added route = %+v", diff.Routes[1])
+	}
+}
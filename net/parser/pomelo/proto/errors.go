@@ -0,0 +1,48 @@
+package pomeloProto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoProtoFiles 配置了 proto 目录/文件，但没有找到任何 proto 文件
+// 与未配置 proto（Parse 返回 nil, nil）区分，通常是目录配置错误
+var ErrNoProtoFiles = errors.New("没有找到 proto 文件")
+
+// FileError 单个 proto 文件的解析错误
+type FileError struct {
+	File string // 文件路径，ProtoFS 中的文件以 fs: 开头
+	Err  error  // 解析错误
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError 非严格模式下 Parse 汇总的文件解析错误
+// 出错的文件会被跳过，Parse 仍返回其余文件生成的 schema，由调用方决定是否使用
+type ParseError struct {
+	Files []*FileError // 解析失败的文件，按解析顺序排列
+}
+
+func (e *ParseError) Error() string {
+	messages := make([]string, 0, len(e.Files))
+	for _, file := range e.Files {
+		messages = append(messages, file.Error())
+	}
+	return fmt.Sprintf("%d 个 proto 文件解析失败: %s", len(e.Files), strings.Join(messages, "; "))
+}
+
+// Unwrap 支持 errors.Is/errors.As 匹配任一文件的错误
+func (e *ParseError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Files))
+	for _, file := range e.Files {
+		errs = append(errs, file)
+	}
+	return errs
+}
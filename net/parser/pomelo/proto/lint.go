@@ -0,0 +1,137 @@
+package pomeloProto
+
+import (
+	"strings"
+)
+
+const (
+	// LintTagFactor 最大标签号超过字段数的倍数时 LintTags 给出警告
+	LintTagFactor = 4
+	// LintTagMinTag 标签号不超过该值时字段头只占 1 字节，LintTags 不给出警告
+	LintTagMinTag = 15
+	// LintMaxFields LintFields 默认的字段数上限
+	LintMaxFields = 100
+)
+
+// TagWarning 最大标签号远大于字段数的消息
+type TagWarning struct {
+	Side    string // server、client 或 global（全局 __messages__）
+	Route   string // 路由名称，global 时为空
+	Message string // 嵌套消息名称，为空表示路由消息本身
+	Fields  int    // 字段数
+	MaxTag  int    // 最大标签号
+}
+
+// FieldCountWarning 字段数超过上限的消息
+type FieldCountWarning struct {
+	Side    string // server、client 或 global（全局 __messages__）
+	Route   string // 路由名称，global 时为空
+	Message string // 嵌套消息名称，为空表示路由消息本身
+	Fields  int    // 字段数
+}
+
+// LintTags 检查路由消息及其嵌套消息的标签号，最大标签号超过 LintTagMinTag 且超过字段数的 LintTagFactor 倍时给出警告
+// 标签号越小，字段头的 varint 越短，过大的标签号通常是误写。仅作为建议，不影响 schema 的使用
+// 结果按 server、client、global 分组，组内按路由名称和消息名称排序
+func (s *ProtoSchema) LintTags() []TagWarning {
+	var warnings []TagWarning
+
+	s.lintMessages(func(side, route, message string, msg map[string]interface{}) {
+		fields, maxTag := 0, 0
+		for key, value := range msg {
+			if strings.HasPrefix(key, "__") {
+				continue
+			}
+			fields++
+			maxTag = max(maxTag, schemaTag(value))
+		}
+
+		if maxTag > LintTagMinTag && maxTag > fields*LintTagFactor {
+			warnings = append(warnings, TagWarning{
+				Side:    side,
+				Route:   route,
+				Message: message,
+				Fields:  fields,
+				MaxTag:  maxTag,
+			})
+		}
+	})
+
+	return warnings
+}
+
+// LintFields 检查路由消息及其嵌套消息的字段数，超过 maxFields 时给出警告，maxFields <= 0 时使用 LintMaxFields
+// 字段过多的消息会增加客户端解码的负担，通常也意味着消息需要拆分。仅作为建议，不影响 schema 的使用
+// 结果的顺序与 LintTags 相同
+func (s *ProtoSchema) LintFields(maxFields int) []FieldCountWarning {
+	if maxFields <= 0 {
+		maxFields = LintMaxFields
+	}
+
+	var warnings []FieldCountWarning
+
+	s.lintMessages(func(side, route, message string, msg map[string]interface{}) {
+		fields := 0
+		for key := range msg {
+			if !strings.HasPrefix(key, "__") {
+				fields++
+			}
+		}
+
+		if fields > maxFields {
+			warnings = append(warnings, FieldCountWarning{
+				Side:    side,
+				Route:   route,
+				Message: message,
+				Fields:  fields,
+			})
+		}
+	})
+
+	return warnings
+}
+
+// lintMessages 按 server、client、global 的顺序遍历路由消息及其嵌套消息，组内按路由名称和消息名称排序
+// 多层 __messages__ 递归遍历，深层消息的名称为 "父消息名.消息名"
+func (s *ProtoSchema) lintMessages(lint func(side, route, message string, msg map[string]interface{})) {
+	var walk func(side, route, parent string, nested map[string]interface{})
+	walk = func(side, route, parent string, nested map[string]interface{}) {
+		for _, name := range sortedKeys(nested) {
+			msg, ok := nested[name].(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			msgName := name
+			if parent != "" {
+				msgName = parent + "." + name
+			}
+			lint(side, route, msgName, msg)
+
+			children, _ := msg[MessagesKey].(map[string]interface{})
+			walk(side, route, msgName, children)
+		}
+	}
+
+	for _, side := range []struct {
+		name   string
+		routes map[string]interface{}
+	}{
+		{"server", s.Server},
+		{"client", s.Client},
+	} {
+		for _, route := range sortedKeys(side.routes) {
+			routeSchema, ok := side.routes[route].(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			lint(side.name, route, "", routeSchema)
+
+			nested, _ := routeSchema[MessagesKey].(map[string]interface{})
+			walk(side.name, route, "", nested)
+		}
+	}
+
+	walk("global", "", "", s.Messages)
+}
@@ -0,0 +1,151 @@
+package pomeloProto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintTags(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.heroHandler.info"] = "HeroResponse"
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroList"
+	opts.ClientRoutes["game.heroHandler.info"] = "HeroRequest"
+	parser := NewParser(opts)
+	err := parser.ParseString("hero.proto", `
+syntax = "proto3";
+
+message Item {
+    uint32 id = 1;
+    string name = 20;
+}
+
+message HeroResponse {
+    int32 code = 1;
+    string name = 5000;
+    Item item = 3;
+}
+
+message HeroList {
+    repeated int32 ids = 1;
+    repeated string names = 12;
+}
+
+message HeroRequest {
+    int32 id = 1;
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TagWarning{
+		{Side: "server", Route: "game.heroHandler.info", Fields: 3, MaxTag: 5000},
+		{Side: "server", Route: "game.heroHandler.info", Message: "Item", Fields: 2, MaxTag: 20},
+	}
+	if got := schema.LintTags(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("warnings = %+v, want %+v", got, want)
+	}
+
+	// JSON 加载的 schema 标签号为 float64，全局 __messages__ 也需要检查
+	loaded := &ProtoSchema{
+		Messages: map[string]interface{}{
+			"Item": map[string]interface{}{"optional uInt32 id": float64(100)},
+		},
+	}
+	want = []TagWarning{{Side: "global", Message: "Item", Fields: 1, MaxTag: 100}}
+	if got := loaded.LintTags(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("warnings = %+v, want %+v", got, want)
+	}
+}
+
+func TestLintFields(t *testing.T) {
+	const maxFields = 3
+
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.heroHandler.info"] = "HeroResponse"
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroList"
+	parser := NewParser(opts)
+	err := parser.ParseString("hero.proto", `
+syntax = "proto3";
+
+message Item {
+    uint32 id = 1;
+    string name = 2;
+    int32 count = 3;
+    int32 quality = 4;
+}
+
+message HeroResponse {
+    int32 code = 1;
+    string name = 2;
+    Item item = 3;
+}
+
+message HeroList {
+    repeated int32 ids = 1;
+    repeated string names = 2;
+    int32 total = 3;
+    int32 page = 4;
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HeroResponse 恰好 maxFields 个字段不警告，Item 和 HeroList 超过 1 个
+	want := []FieldCountWarning{
+		{Side: "server", Route: "game.heroHandler.info", Message: "Item", Fields: maxFields + 1},
+		{Side: "server", Route: "game.heroHandler.list", Fields: maxFields + 1},
+	}
+	if got := schema.LintFields(maxFields); !reflect.DeepEqual(got, want) {
+		t.Fatalf("warnings = %+v, want %+v", got, want)
+	}
+
+	if got := schema.LintFields(0); len(got) != 0 {
+		t.Fatalf("warnings = %+v, want none", got)
+	}
+}
+
+func TestLintNestedMessages(t *testing.T) {
+	// 多层 __messages__ 中的消息同样需要检查
+	schema := &ProtoSchema{
+		Server: map[string]interface{}{
+			"game.bagHandler.list": map[string]interface{}{
+				"repeated message Item items": float64(1),
+				MessagesKey: map[string]interface{}{
+					"Item": map[string]interface{}{
+						"optional uInt32 id":       float64(1),
+						"optional message Gem gem": float64(2),
+						MessagesKey: map[string]interface{}{
+							"Gem": map[string]interface{}{
+								"optional uInt32 id":    float64(1),
+								"optional int32 level":  float64(2),
+								"optional string color": float64(100),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	wantTags := []TagWarning{{Side: "server", Route: "game.bagHandler.list", Message: "Item.Gem", Fields: 3, MaxTag: 100}}
+	if got := schema.LintTags(); !reflect.DeepEqual(got, wantTags) {
+		t.Fatalf("tag warnings = %+v, want %+v", got, wantTags)
+	}
+
+	wantFields := []FieldCountWarning{{Side: "server", Route: "game.bagHandler.list", Message: "Item.Gem", Fields: 3}}
+	if got := schema.LintFields(2); !reflect.DeepEqual(got, wantFields) {
+		t.Fatalf("field warnings = %+v, want %+v", got, wantFields)
+	}
+}
@@ -0,0 +1,82 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GenerateMarkdown 生成 Markdown 格式的协议文档
+// 包含路由表（路由、方向、消息及消息说明）和每个消息的字段表（标签号、字段、类型、说明），
+// 说明取自 message/字段声明前紧邻的 // 注释
+func (p *Parser) GenerateMarkdown(w io.Writer) error {
+	p.resolveFieldTypes()
+	p.collectRoutes()
+
+	var buf bytes.Buffer
+	buf.WriteString("# 协议文档\n")
+
+	buf.WriteString("\n## 路由\n\n")
+	buf.WriteString("| 路由 | 方向 | 消息 | 说明 |\n")
+	buf.WriteString("| --- | --- | --- | --- |\n")
+
+	sides := []struct {
+		name   string
+		routes map[string]string
+	}{
+		{"server", p.serverRoutes},
+		{"client", p.clientRoutes},
+	}
+
+	for _, side := range sides {
+		for _, route := range sortedKeys(side.routes) {
+			msgName := side.routes[route]
+			doc := ""
+			if msg, found := p.lookupMessage(msgName); found {
+				msgName = msg.FullName()
+				doc = msg.Doc
+			}
+			fmt.Fprintf(&buf, "| %s | %s | %s | %s |\n",
+				markdownCell(route), side.name, markdownCell(msgName), markdownCell(doc))
+		}
+	}
+
+	buf.WriteString("\n## 消息\n")
+
+	for _, name := range sortedKeys(p.messages) {
+		msg := p.messages[name]
+		if msg.MapEntry {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "\n### %s\n\n", name)
+		if msg.Doc != "" {
+			buf.WriteString(msg.Doc + "\n\n")
+		}
+
+		fields := make([]*ProtoField, len(msg.Fields))
+		copy(fields, msg.Fields)
+		sort.Slice(fields, func(i, j int) bool {
+			return fields[i].Tag < fields[j].Tag
+		})
+
+		buf.WriteString("| 标签号 | 字段 | 类型 | 说明 |\n")
+		buf.WriteString("| --- | --- | --- | --- |\n")
+		for _, field := range fields {
+			typ := strings.TrimSuffix(p.buildFieldKey(field), " "+p.fieldName(field))
+			fmt.Fprintf(&buf, "| %d | %s | %s | %s |\n",
+				field.Tag, markdownCell(p.fieldName(field)), markdownCell(typ), markdownCell(field.Doc))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// markdownCell 转义表格单元格中的 | 和换行
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
@@ -0,0 +1,59 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParserGenerateMarkdown(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.hero.info"] = "HeroInfoResponse"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("hero.proto", `
+// 英雄详情
+/// 进入英雄界面时请求
+message HeroInfoResponse {
+    int32 code = 1; // 尾部注释不作为文档
+
+    // 英雄配置 id | 对应 hero 表
+    int32 configId = 2;
+
+    // 与 configId 之间隔了空行，不作为 name 的文档
+
+    string name = 3;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := parser.GetMessage("HeroInfoResponse")
+	if msg.Doc != "英雄详情\n进入英雄界面时请求" {
+		t.Fatalf("message doc = %q", msg.Doc)
+	}
+	for _, field := range msg.Fields {
+		want := map[string]string{"configId": "英雄配置 id | 对应 hero 表"}[field.Name]
+		if field.Doc != want {
+			t.Fatalf("field %s doc = %q, want %q", field.Name, field.Doc, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := parser.GenerateMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	markdown := buf.String()
+
+	for _, want := range []string{
+		"| game.hero.info | server | HeroInfoResponse | 英雄详情<br>进入英雄界面时请求 |\n",
+		"### HeroInfoResponse\n\n英雄详情\n进入英雄界面时请求\n\n",
+		"| 1 | code | optional int32 |  |\n",
+		"| 2 | configId | optional int32 | 英雄配置 id \\| 对应 hero 表 |\n",
+		"| 3 | name | optional string |  |\n",
+	} {
+		if !strings.Contains(markdown, want) {
+			t.Fatalf("markdown should contain %q\n%s", want, markdown)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pomeloProto
+
+import (
+	"strings"
+	"unicode"
+)
+
+// FieldNameCase 生成 schema 时字段名的命名风格
+type FieldNameCase string
+
+const (
+	FieldNameCaseNone  FieldNameCase = "none"  // 保持 proto 中的字段名（默认）
+	FieldNameCaseCamel FieldNameCase = "camel" // player_id -> playerId
+	FieldNameCaseSnake FieldNameCase = "snake" // playerId -> player_id
+)
+
+// valid 是否为支持的命名风格，空值按 none 处理
+func (c FieldNameCase) valid() bool {
+	switch c {
+	case "", FieldNameCaseNone, FieldNameCaseCamel, FieldNameCaseSnake:
+		return true
+	}
+	return false
+}
+
+// fieldName 生成 schema 时使用的字段名
+// 声明了 [json_name = "..."] 时直接使用 json_name，否则按 Options.FieldNameCase 转换，ProtoField.Name 保持原名
+func (p *Parser) fieldName(field *ProtoField) string {
+	if name := field.Options["json_name"]; name != "" {
+		return name
+	}
+
+	switch p.options.FieldNameCase {
+	case FieldNameCaseCamel:
+		return toCamelCase(field.Name)
+	case FieldNameCaseSnake:
+		return toSnakeCase(field.Name)
+	default:
+		return field.Name
+	}
+}
+
+// toCamelCase 与 protoc 生成 json_name 的规则一致：去掉下划线，下划线后的字母大写
+// player_id -> playerId, item_list_v2 -> itemListV2
+func toCamelCase(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// toSnakeCase 在单词边界插入下划线并转为小写，连续的大写字母视为一个单词
+// playerId -> player_id, playerID -> player_id, HTTPServer -> http_server
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && runes[i-1] != '_' {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -1,53 +1,298 @@
 package pomeloProto
 
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
 // Options Proto 解析配置选项
 type Options struct {
-	// ProtoFiles proto 文件路径列表
+	// ProtoFiles proto 文件路径列表，支持 glob 模式，** 匹配任意层目录（如 "proto/**/*.proto"）
+	// 包含通配符的模式未匹配到任何文件时返回错误
 	ProtoFiles []string
 
 	// ProtoDir proto 文件目录，会自动扫描目录下所有 .proto 文件
+	// 兼容旧配置，与 ProtoDirs 同时配置时追加在 ProtoDirs 之后扫描
 	ProtoDir string
 
+	// ProtoDirs 多个 proto 文件目录（如 monorepo 中的 api/ 和 shared/），依次扫描，同一文件只解析一次
+	ProtoDirs []string
+
+	// ProtoFS proto 文件所在的文件系统（如 embed.FS），与磁盘文件可同时配置
+	ProtoFS fs.FS
+
+	// ProtoFSDir ProtoFS 中需要扫描的目录，为空时扫描整个 ProtoFS
+	ProtoFSDir string
+
+	// ManifestFile proto 文件清单，设置后按清单中的顺序解析列出的文件，不再使用 ProtoFiles 和目录扫描（ProtoFS 不受影响）
+	// 清单为 JSON 字符串数组，或每行一个路径（忽略空行和 # 开头的注释），相对路径相对于清单文件所在目录。
+	// 文件顺序由清单固定，不依赖文件系统的遍历顺序，ExcludePatterns 不作用于清单中的文件
+	ManifestFile string
+
+	// Extensions 扫描目录（ProtoDirs/ProtoDir/ProtoFS）时包含的文件扩展名，不区分大小写，默认 [".proto"]
+	// 如 []string{".proto", ".proto3"}，ProtoFiles 中显式指定的文件不受影响
+	Extensions []string
+
+	// ExcludePatterns 排除的文件 glob 模式，匹配 ProtoDirs/ProtoDir（或 ProtoFSDir）下的相对路径，支持 **
+	// 同时作用于目录扫描和 ProtoFiles 中显式指定的文件，如 "vendor/**"、"*_test.proto"
+	ExcludePatterns []string
+
+	// ImportPaths import 语句的查找目录
+	// import 的文件先相对于当前文件所在目录查找，找不到时依次在这些目录中查找
+	ImportPaths []string
+
+	// MaxLineBytes proto 文件单行的最大字节数，默认 1MB
+	// 超过该长度的文件会解析失败并返回 bufio.ErrTooLong
+	MaxLineBytes int
+
+	// CacheDir schema 缓存目录，为空时不使用缓存
+	// 设置后 Parse 根据所有 proto 文件内容和解析选项计算 hash，命中 schema-<hash>.json 时直接加载，跳过解析；
+	// 未命中时解析并写入缓存。其他 hash 的旧缓存文件不会自动清理。命中缓存时 GetMessages 等解析结果为空
+	CacheDir string
+
+	// ParseConcurrency 并发解析 proto 文件的协程数，<= 0 时使用 runtime.NumCPU()
+	// 无论并发数多少，解析结果按文件顺序合并，版本号保持稳定
+	ParseConcurrency int
+
+	// TypeOverrides 自定义 proto 类型到 Pomelo 类型的映射，优先于内置映射
+	// 主要用于 JavaScript 客户端：number 无法精确表示 64 位整数，配置 {"int64": TypeString} 后
+	// int64 字段在 schema 中按 string 下发，避免大数值（如 ID）丢失精度
+	TypeOverrides map[string]FieldType
+
+	// Int64AsString schema 中 int64/uInt64/sInt64 字段的类型输出为 string，ProtoField 仍保留整数类型
+	// 用于 JavaScript 客户端按字符串读写 64 位整数，服务端编解码这些字段时需要同样按 string 处理
+	Int64AsString bool
+
+	// MapWellKnownTypes 将 protobuf well-known type 映射为 Pomelo 类型，不再作为未定义的消息处理
+	// google.protobuf.Timestamp/Duration 映射为 int64（毫秒），StringValue 等包装类型映射为对应的基础类型，
+	// Empty 映射为空消息。开启后 import "google/protobuf/..." 不再查找文件
+	MapWellKnownTypes bool
+
 	// Version 协议版本号
 	// 设置为 0 时，会基于 schema 内容自动计算 hash 作为版本号（推荐）
 	// 设置为 > 0 时，使用手动指定的版本号
 	Version int
 
+	// GlobalMessages 将所有路由引用的嵌套消息汇总到 schema 顶层的 __messages__ 中
+	// 路由内只保留 "message <Name>" 引用，共享的消息只下发一次，减小握手数据
 	GlobalMessages bool
 
+	// FieldNameCase 生成 schema 时字段名的命名风格（none/camel/snake），用于匹配客户端的命名习惯
+	// 只影响下发给客户端的字段名，解析结果中的 ProtoField.Name 保持原名；声明了 json_name 的字段使用 json_name
+	FieldNameCase FieldNameCase
+
+	// FieldKeyFormatter 自定义 schema 中字段 key 的格式，为 nil 或返回空字符串时使用默认的 "修饰符 类型 字段名"
+	// Encode/Decode、ValidateMessage 等依赖默认格式，自定义格式只用于下发给自行解析 schema 的客户端
+	// 函数无法参与缓存 key 的计算，设置后不使用 CacheDir 缓存
+	FieldKeyFormatter func(field *ProtoField) string `json:"-"`
+
+	// HandshakeSizeWarn 握手下发的 schema 超过该字节数时输出警告，<= 0 时不检查
+	HandshakeSizeWarn int
+
+	// CompressProtos 额外生成 gzip 压缩 protos 的握手数据
+	// 客户端在握手请求中声明 sys.protosCompressed = true 时下发压缩版本，未声明的旧客户端仍下发原始 JSON
+	CompressProtos bool
+
+	// StrictMode 严格模式
+	// 开启后，proto 定义错误（如字段标签号重复）会使解析返回错误
+	// 关闭时（默认）只输出警告日志，尽量生成可用的 schema
+	StrictMode bool
+
 	// ServerRoutes 服务端路由映射
 	// key: 路由名称 (如 "connector.entryHandler.entry")
-	// value: 消息名称 (如 "EntryResponse")
+	// value: 消息名称 (如 "EntryResponse"，声明了 package 时也可使用 "game.EntryResponse")
 	ServerRoutes map[string]string
 
 	// ClientRoutes 客户端路由映射
 	// key: 路由名称 (如 "connector.entryHandler.entry")
-	// value: 消息名称 (如 "EntryRequest")
+	// value: 消息名称 (如 "EntryRequest"，声明了 package 时也可使用 "game.EntryRequest")
 	ClientRoutes map[string]string
+
+	// StrictRoutes 路由配置的消息未找到时 Parse 返回错误（汇总所有缺失的路由），默认仅输出警告
+	StrictRoutes bool
+
+	// FailOnUnknownTypes 任意消息（不限于路由引用的消息）的字段类型既不是基础类型也不是已定义的消息/枚举时，
+	// Parse 返回错误并列出这些字段，用于发现 int23 之类的拼写错误。默认按未定义的消息类型处理
+	FailOnUnknownTypes bool
+
+	// AllowSharedMessages 有意被多个同方向路由复用的消息（如通用的 CommonResponse），不再输出共用警告
+	// 可使用消息名或包含包名的完整名称
+	AllowSharedMessages []string
+
+	// IgnoreSharedMessages 不检查多个路由共用同一个消息
+	IgnoreSharedMessages bool
+
+	// RouteMappings 成对配置路由的请求/响应消息
+	// RequestMsg 生成客户端路由，ResponseMsg 生成服务端路由，ServerRoutes/ClientRoutes 中的同名路由优先
+	RouteMappings []RouteMapping
+
+	// AutoRouteFromService 根据 proto 中的 service/rpc 定义自动生成路由
+	// service EntryHandler { rpc Entry (EntryRequest) returns (EntryResponse); }
+	// 生成路由 "<RoutePrefix>.entryHandler.entry"，客户端路由为 EntryRequest，服务端路由为 EntryResponse
+	// ServerRoutes/ClientRoutes 中手动配置的路由优先
+	AutoRouteFromService bool
+
+	// RoutePrefix 自动生成路由时使用的前缀，一般为节点类型（如 "connector"）
+	RoutePrefix string
 }
 
+// DefaultExtension proto 文件默认的扩展名
+const DefaultExtension = ".proto"
+
+// DefaultMaxLineBytes proto 文件单行默认的最大字节数
+const DefaultMaxLineBytes = 1024 * 1024
+
+// DefaultHandshakeSizeWarn 握手 schema 大小默认的警告阈值
+const DefaultHandshakeSizeWarn = 256 * 1024
+
 // DefaultOptions 默认配置
 func DefaultOptions() Options {
 	return Options{
-		ProtoFiles:     make([]string, 0),
-		ProtoDir:       "",
-		Version:        0, // 默认为 0，自动基于 schema 内容计算 hash 版本号
-		GlobalMessages: false,
-		ServerRoutes:   make(map[string]string),
-		ClientRoutes:   make(map[string]string),
+		ProtoFiles:        make([]string, 0),
+		ProtoDir:          "",
+		ProtoDirs:         nil,
+		ManifestFile:      "",
+		Extensions:        []string{DefaultExtension},
+		ExcludePatterns:   make([]string, 0),
+		ImportPaths:       make([]string, 0),
+		MaxLineBytes:      DefaultMaxLineBytes,
+		CacheDir:          "",
+		ParseConcurrency:  0,
+		TypeOverrides:     make(map[string]FieldType),
+		Int64AsString:     false,
+		MapWellKnownTypes: false,
+		Version:           0, // 默认为 0，自动基于 schema 内容计算 hash 版本号
+		GlobalMessages:    false,
+		FieldNameCase:     FieldNameCaseNone,
+		HandshakeSizeWarn: DefaultHandshakeSizeWarn,
+		CompressProtos:    false,
+		StrictMode:        false,
+		ServerRoutes:      make(map[string]string),
+		ClientRoutes:      make(map[string]string),
+
+		StrictRoutes:         false,
+		FailOnUnknownTypes:   false,
+		AllowSharedMessages:  make([]string, 0),
+		IgnoreSharedMessages: false,
+		RouteMappings:        make([]RouteMapping, 0),
+		AutoRouteFromService: false,
+		RoutePrefix:          "",
 	}
 }
 
-// Validate 验证配置
+// Validate 验证配置，返回汇总所有问题的错误
+// 检查路由名和消息名不为空、ProtoDirs/ProtoDir 存在且为目录、Version 不为负数，
+// 以及 RouteMappings 与 ServerRoutes/ClientRoutes（或 RouteMappings 之间）对同一路由配置了不同的消息
 func (o *Options) Validate() error {
-	if o.ProtoDir == "" && len(o.ProtoFiles) == 0 {
-		return nil // 没有配置 proto，不启用功能
+	var problems []string
+
+	for _, dir := range o.ScanDirs() {
+		if info, err := os.Stat(dir); err != nil {
+			problems = append(problems, fmt.Sprintf("ProtoDir 不存在: %s", dir))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("ProtoDir 不是目录: %s", dir))
+		}
+	}
+
+	if !o.FieldNameCase.valid() {
+		problems = append(problems, fmt.Sprintf("FieldNameCase 不支持: %s", o.FieldNameCase))
+	}
+
+	if o.Version < 0 {
+		problems = append(problems, fmt.Sprintf("Version 不能为负数: %d", o.Version))
+	}
+
+	sides := []struct {
+		name     string
+		routes   map[string]string
+		messages map[string]string // RouteMappings 中该方向的路由 -> 消息
+	}{
+		{"ServerRoutes", o.ServerRoutes, make(map[string]string)},
+		{"ClientRoutes", o.ClientRoutes, make(map[string]string)},
 	}
-	return nil
+
+	for _, side := range sides {
+		for _, route := range sortedKeys(side.routes) {
+			if strings.TrimSpace(route) == "" {
+				problems = append(problems, fmt.Sprintf("%s 中存在空的路由名", side.name))
+			} else if strings.TrimSpace(side.routes[route]) == "" {
+				problems = append(problems, fmt.Sprintf("%s 路由 %s 的消息名为空", side.name, route))
+			}
+		}
+	}
+
+	for i, mapping := range o.RouteMappings {
+		if strings.TrimSpace(mapping.Route) == "" {
+			problems = append(problems, fmt.Sprintf("RouteMappings[%d] 的路由名为空", i))
+			continue
+		}
+		if mapping.RequestMsg == "" && mapping.ResponseMsg == "" {
+			problems = append(problems, fmt.Sprintf("RouteMappings[%d] 路由 %s 未配置消息", i, mapping.Route))
+			continue
+		}
+
+		for _, item := range []struct {
+			side    int
+			message string
+		}{
+			{0, mapping.ResponseMsg},
+			{1, mapping.RequestMsg},
+		} {
+			if item.message == "" {
+				continue
+			}
+
+			side := sides[item.side]
+			if exist, found := side.messages[mapping.Route]; found && exist != item.message {
+				problems = append(problems, fmt.Sprintf("RouteMappings 路由 %s 重复配置了不同的消息: %s, %s",
+					mapping.Route, exist, item.message))
+			}
+			side.messages[mapping.Route] = item.message
+
+			if exist, found := side.routes[mapping.Route]; found && exist != item.message {
+				problems = append(problems, fmt.Sprintf("RouteMappings 路由 %s 的消息 %s 与 %s 中的 %s 冲突",
+					mapping.Route, item.message, side.name, exist))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("proto 配置错误: %s", strings.Join(problems, "; "))
 }
 
 // HasProtoConfig 检查是否配置了 proto
 func (o *Options) HasProtoConfig() bool {
-	return o.ProtoDir != "" || len(o.ProtoFiles) > 0
+	return len(o.ScanDirs()) > 0 || len(o.ProtoFiles) > 0 || o.ProtoFS != nil || o.ManifestFile != ""
 }
 
+// MatchExtension 文件名的扩展名是否在 Extensions 中（不区分大小写），未配置 Extensions 时只匹配 .proto
+func (o *Options) MatchExtension(name string) bool {
+	extensions := o.Extensions
+	if len(extensions) == 0 {
+		extensions = []string{DefaultExtension}
+	}
+
+	for _, ext := range extensions {
+		if len(name) >= len(ext) && strings.EqualFold(name[len(name)-len(ext):], ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanDirs 返回需要扫描的目录：ProtoDirs 在前，ProtoDir 追加在后，忽略空值和重复的目录
+func (o *Options) ScanDirs() []string {
+	var dirs []string
+	for _, dir := range append(append([]string{}, o.ProtoDirs...), o.ProtoDir) {
+		if dir != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,73 @@
+package pomeloProto
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	dir := t.TempDir()
+	file := writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	valid := func() Options {
+		opts := DefaultOptions()
+		opts.ProtoDir = dir
+		opts.ServerRoutes["game.hero.list"] = "HeroListResponse"
+		opts.ClientRoutes["game.hero.list"] = "HeroListRequest"
+		opts.RouteMappings = []RouteMapping{
+			{Route: "game.hero.list", RequestMsg: "HeroListRequest", ResponseMsg: "HeroListResponse"},
+			{Route: "game.hero.chat", RequestMsg: "ChatRequest"},
+		}
+		return opts
+	}
+
+	opts := valid()
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("valid options err = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(opts *Options)
+		want   string
+	}{
+		{"empty route", func(opts *Options) { opts.ServerRoutes[""] = "HeroListResponse" }, "ServerRoutes 中存在空的路由名"},
+		{"empty message", func(opts *Options) { opts.ClientRoutes["game.hero.info"] = "" }, "ClientRoutes 路由 game.hero.info 的消息名为空"},
+		{"missing dir", func(opts *Options) { opts.ProtoDir = filepath.Join(dir, "missing") }, "ProtoDir 不存在"},
+		{"dir is file", func(opts *Options) { opts.ProtoDir = file }, "ProtoDir 不是目录"},
+		{"negative version", func(opts *Options) { opts.Version = -1 }, "Version 不能为负数: -1"},
+		{"unknown field name case", func(opts *Options) { opts.FieldNameCase = "kebab" }, "FieldNameCase 不支持: kebab"},
+		{"mapping empty route", func(opts *Options) {
+			opts.RouteMappings = append(opts.RouteMappings, RouteMapping{RequestMsg: "A"})
+		}, "RouteMappings[2] 的路由名为空"},
+		{"mapping without message", func(opts *Options) {
+			opts.RouteMappings = append(opts.RouteMappings, RouteMapping{Route: "game.hero.empty"})
+		}, "RouteMappings[2] 路由 game.hero.empty 未配置消息"},
+		{"mapping conflicts with flat map", func(opts *Options) {
+			opts.ServerRoutes["game.hero.list"] = "HeroInfoResponse"
+		}, "RouteMappings 路由 game.hero.list 的消息 HeroListResponse 与 ServerRoutes 中的 HeroInfoResponse 冲突"},
+		{"mapping conflicts with mapping", func(opts *Options) {
+			opts.RouteMappings = append(opts.RouteMappings, RouteMapping{Route: "game.hero.chat", RequestMsg: "TalkRequest"})
+		}, "RouteMappings 路由 game.hero.chat 重复配置了不同的消息: ChatRequest, TalkRequest"},
+	}
+
+	for _, tt := range tests {
+		opts := valid()
+		tt.modify(&opts)
+
+		err := opts.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// 汇总所有问题，Parse 时返回
+	opts = valid()
+	opts.Version = -1
+	opts.ServerRoutes["game.hero.info"] = ""
+	_, err := NewParser(opts).Parse()
+	if err == nil || !strings.Contains(err.Error(), "Version 不能为负数") || !strings.Contains(err.Error(), "game.hero.info 的消息名为空") {
+		t.Fatalf("Parse err = %v", err)
+	}
+}
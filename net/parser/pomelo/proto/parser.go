@@ -2,276 +2,2071 @@ package pomeloProto
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	clog "github.com/cherry-game/cherry/logger"
 	jsoniter "github.com/json-iterator/go"
 )
 
+// protoBlock 解析时的大括号层级
+type protoBlock struct {
+	message *ProtoMessage // message block
+	oneof   string        // oneof block 名称
+	service *ProtoService // service block
+}
+
+// protoSource proto 文件来源
+type protoSource struct {
+	fsys fs.FS  // 为 nil 时表示磁盘文件
+	path string // 文件路径
+}
+
+// key 用于判断文件是否已解析
+func (s protoSource) key() string {
+	if s.fsys != nil {
+		return "fs:" + s.path
+	}
+
+	if absPath, err := filepath.Abs(s.path); err == nil {
+		return absPath
+	}
+	return s.path
+}
+
+func (s protoSource) String() string {
+	if s.fsys != nil {
+		return "fs:" + s.path
+	}
+	return s.path
+}
+
 // Parser Proto 文件解析器
 type Parser struct {
-	options  Options
-	messages map[string]*ProtoMessage // 所有解析的消息定义
+	options      Options
+	messages     map[string]*ProtoMessage // 所有解析的消息定义
+	enums        map[string]*ProtoEnum    // 所有解析的枚举定义
+	services     []*ProtoService          // 所有解析的 service 定义
+	serverRoutes map[string]string        // 生效的服务端路由（手动配置 + 自动生成）
+	clientRoutes map[string]string        // 生效的客户端路由（手动配置 + 自动生成）
+	streamRoutes map[string]RouteStream   // 自动生成的路由中声明了 stream 的路由
+	origins      map[string]string        // 消息/枚举全名 -> 定义所在文件
+	files        []string                 // 已解析的磁盘文件（含 import 的文件）
+}
+
+// parseResult 单个文件的解析结果
+type parseResult struct {
+	parser  *Parser
+	imports []string
+	err     error
+}
+
+// NewParser 创建解析器
+func NewParser(opts Options) *Parser {
+	return &Parser{
+		options:  opts,
+		messages: make(map[string]*ProtoMessage),
+		enums:    make(map[string]*ProtoEnum),
+		origins:  make(map[string]string),
+	}
+}
+
+// Parse 解析 proto 文件并生成 Pomelo Schema
+// 非严格模式下解析失败的文件会被跳过，返回其余文件生成的 schema 和汇总所有失败文件的 *ParseError
+// 严格模式下遇到第一个解析失败的文件即返回错误
+// 未配置 proto 时返回 nil, nil，配置了但没有找到任何 proto 文件时返回 ErrNoProtoFiles
+func (p *Parser) Parse() (*ProtoSchema, error) {
+	if !p.options.HasProtoConfig() {
+		return nil, nil
+	}
+
+	if err := p.options.Validate(); err != nil {
+		return nil, err
+	}
+
+	// 获取所有 proto 文件
+	files, err := p.getProtoFiles()
+	if err != nil {
+		return nil, fmt.Errorf("获取 proto 文件失败: %w", err)
+	}
+
+	sources := make([]protoSource, 0, len(files))
+	for _, file := range files {
+		sources = append(sources, protoSource{path: file})
+	}
+
+	// 获取 ProtoFS 中的 proto 文件
+	fsFiles, err := p.getFSProtoFiles()
+	if err != nil {
+		return nil, fmt.Errorf("获取 ProtoFS 中的 proto 文件失败: %w", err)
+	}
+
+	for _, file := range fsFiles {
+		sources = append(sources, protoSource{fsys: p.options.ProtoFS, path: file})
+	}
+
+	if len(sources) == 0 {
+		return nil, ErrNoProtoFiles
+	}
+
+	// 命中缓存时直接返回缓存的 schema
+	var cacheKey string
+	if p.options.CacheDir != "" && p.options.FieldKeyFormatter == nil {
+		cacheKey, err = p.cacheKey(sources)
+		if err != nil {
+			clog.Warnf("[ProtoParser] 计算 schema 缓存 key 失败: %v", err)
+		} else if schema, found := p.loadCache(cacheKey); found {
+			clog.Infof("[ProtoParser] 使用 schema 缓存: %s", p.cachePath(cacheKey))
+			return schema, nil
+		}
+	}
+
+	// 按批次并发解析 proto 文件，每批解析完成后按文件顺序合并，import 的文件进入下一批
+	// 已解析的文件（含循环 import）会跳过
+	parsed := make(map[string]bool, len(sources))
+	var fileErrors []*FileError
+	var parsedSources []protoSource // 解析成功的文件（含 import 的文件），用于写入缓存
+	for len(sources) > 0 {
+		batch := make([]protoSource, 0, len(sources))
+		for _, source := range sources {
+			if parsed[source.key()] {
+				continue
+			}
+			parsed[source.key()] = true
+			batch = append(batch, source)
+		}
+		sources = nil
+
+		results := p.parseSources(batch)
+		for i, result := range results {
+			source := batch[i]
+
+			if result.err != nil {
+				if p.options.StrictMode {
+					return nil, fmt.Errorf("解析文件失败: %s, %w", source, result.err)
+				}
+				if errors.Is(result.err, bufio.ErrTooLong) {
+					clog.Errorf("[ProtoParser] 解析文件失败: %s, 错误: %v", source, result.err)
+				} else {
+					clog.Warnf("[ProtoParser] 解析文件失败: %s, 错误: %v", source, result.err)
+				}
+				fileErrors = append(fileErrors, &FileError{File: source.String(), Err: result.err})
+				continue
+			}
+
+			if err := p.merge(source.String(), result.parser); err != nil {
+				return nil, err
+			}
+
+			parsedSources = append(parsedSources, source)
+			if source.fsys == nil {
+				p.files = append(p.files, source.path)
+			}
+
+			for _, imp := range result.imports {
+				// 开启 MapWellKnownTypes 时 google/protobuf 下的文件由内置映射处理，不需要查找
+				if p.options.MapWellKnownTypes && strings.HasPrefix(imp, wellKnownImportPrefix) {
+					continue
+				}
+
+				importSource, err := p.resolveImport(source, imp)
+				if err != nil {
+					if p.options.StrictMode {
+						return nil, err
+					}
+					clog.Warnf("[ProtoParser] %v", err)
+					continue
+				}
+				sources = append(sources, importSource)
+			}
+		}
+	}
+
+	schema, err := p.BuildSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fileErrors) > 0 {
+		return schema, &ParseError{Files: fileErrors}
+	}
+
+	// 只缓存完整解析成功的 schema
+	if cacheKey != "" {
+		p.saveCache(cacheKey, schema, parsedSources)
+	}
+	return schema, nil
+}
+
+// ParseString 解析字符串形式的 proto 内容，name 用于日志和错误信息
+// 解析结果累加到当前 Parser 中，之后调用 BuildSchema 生成 schema。import 语句不会被处理
+func (p *Parser) ParseString(name, content string) error {
+	return p.ParseReader(name, strings.NewReader(content))
+}
+
+// ParseReader 从 io.Reader 解析 proto 内容，可用于 embed.FS、网络等来源
+// 解析结果累加到当前 Parser 中，之后调用 BuildSchema 生成 schema。import 语句不会被处理
+func (p *Parser) ParseReader(name string, r io.Reader) error {
+	child := p.fork()
+	if _, err := child.parseReader(name, r); err != nil {
+		return err
+	}
+
+	return p.merge(name, child)
+}
+
+// AddMessage 在代码中注册消息定义，不需要 proto 文件即可通过路由配置生成 schema，主要用于测试和动态生成的协议
+// 消息名（含包名）与已有的消息/枚举重复时返回错误；字段引用的类型在 BuildSchema 时解析，与文件中的定义相同。
+// 注册时按 StrictMode 校验字段定义，未设置 SourceFile 时记为 "AddMessage"
+func (p *Parser) AddMessage(msg *ProtoMessage) error {
+	if msg == nil || msg.Name == "" {
+		return errors.New("消息名不能为空")
+	}
+
+	if msg.SourceFile == "" {
+		msg.SourceFile = "AddMessage"
+	}
+	if msg.Syntax == "" {
+		msg.Syntax = SyntaxProto2
+	}
+
+	key := msg.FullName()
+	if origin, exists := p.origins[key]; exists {
+		return fmt.Errorf("类型重复定义: %s, 已定义于 %s", key, origin)
+	}
+
+	if err := p.validateMessage(msg.SourceFile, msg); err != nil {
+		return err
+	}
+
+	p.messages[key] = msg
+	p.origins[key] = msg.SourceFile
+	return nil
+}
+
+// Reset 清空上一次解析的结果，保留 options，用于监听文件变化时复用解析器重新解析
+// 消息、枚举的 map 会被复用，之前通过 GetMessages/GetEnums 获取的副本不受影响
+func (p *Parser) Reset() {
+	clear(p.messages)
+	clear(p.enums)
+	clear(p.origins)
+	p.services = nil
+	p.serverRoutes = nil
+	p.clientRoutes = nil
+	p.streamRoutes = nil
+	p.files = nil
+}
+
+// ReparseFile 重新解析单个磁盘文件，替换该文件之前解析的消息、枚举和 service，用于文件变化时增量更新
+// 之后调用 BuildSchema 生成 schema，不存在跨文件重复定义时与完整解析的结果一致。
+// 文件之前未解析过时作为新文件添加；文件中新增的 import 如果还未解析，会一并解析。解析失败时保留原有的结果
+func (p *Parser) ReparseFile(path string) error {
+	source := protoSource{path: path}
+	file, known := p.parsedFile(source)
+	if known {
+		source.path = file
+	}
+
+	child := p.fork()
+	imports, err := child.parseSource(source)
+	if err != nil {
+		return fmt.Errorf("解析文件失败: %s, %w", source, err)
+	}
+
+	// 替换后的 service 保持在原来的位置，生成路由的顺序与完整解析一致
+	index := p.removeFile(source.String())
+	count := len(p.services)
+	if err := p.merge(source.String(), child); err != nil {
+		return err
+	}
+	if index >= 0 && index < count {
+		p.services = slices.Insert(p.services[:count], index, slices.Clone(p.services[count:])...)
+	}
+
+	if !known {
+		p.files = append(p.files, source.path)
+	}
+
+	for _, imp := range imports {
+		if p.options.MapWellKnownTypes && strings.HasPrefix(imp, wellKnownImportPrefix) {
+			continue
+		}
+
+		importSource, err := p.resolveImport(source, imp)
+		if err != nil {
+			if p.options.StrictMode {
+				return err
+			}
+			clog.Warnf("[ProtoParser] %v", err)
+			continue
+		}
+
+		if _, parsed := p.parsedFile(importSource); parsed || importSource.fsys != nil {
+			continue
+		}
+		if err := p.ReparseFile(importSource.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeFile 删除 name 文件中定义的消息、枚举和 service，以及解析引用时生成的消息（如 google.protobuf.Empty）
+// 返回该文件第一个 service 的位置，没有 service 时返回 -1
+func (p *Parser) removeFile(name string) int {
+	for key, origin := range p.origins {
+		if origin == name {
+			delete(p.origins, key)
+			delete(p.messages, key)
+			delete(p.enums, key)
+		}
+	}
+
+	for key := range p.messages {
+		if _, found := p.origins[key]; !found {
+			delete(p.messages, key)
+		}
+	}
+
+	index := slices.IndexFunc(p.services, func(service *ProtoService) bool {
+		return service.SourceFile == name
+	})
+	p.services = slices.DeleteFunc(slices.Clone(p.services), func(service *ProtoService) bool {
+		return service.SourceFile == name
+	})
+	return index
+}
+
+// parsedFile 返回已解析的磁盘文件中与 source 为同一文件的路径
+func (p *Parser) parsedFile(source protoSource) (string, bool) {
+	for _, file := range p.files {
+		if (protoSource{path: file}).key() == source.key() {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// fork 创建一个与当前 Parser 配置相同、结果相互独立的解析器，用于单个文件的解析
+func (p *Parser) fork() *Parser {
+	return NewParser(p.options)
+}
+
+// parseSources 使用协程池并发解析文件，返回结果的顺序与 sources 一致
+func (p *Parser) parseSources(sources []protoSource) []parseResult {
+	results := make([]parseResult, len(sources))
+
+	workers := p.options.ParseConcurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(sources) {
+		workers = len(sources)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				child := p.fork()
+				imports, err := child.parseSource(sources[i])
+				results[i] = parseResult{parser: child, imports: imports, err: err}
+			}
+		}()
+	}
+
+	for i := range sources {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// merge 将单个文件的解析结果合并到当前 Parser
+// 重复定义的消息/枚举保留先解析的版本，StrictMode 下返回错误
+func (p *Parser) merge(name string, child *Parser) error {
+	for _, key := range sortedKeys(child.messages) {
+		if err := p.checkDuplicate(name, key); err != nil {
+			return err
+		}
+		if _, exists := p.messages[key]; !exists {
+			p.messages[key] = child.messages[key]
+			p.origins[key] = name
+		}
+	}
+
+	for _, key := range sortedKeys(child.enums) {
+		if err := p.checkDuplicate(name, key); err != nil {
+			return err
+		}
+		if _, exists := p.enums[key]; !exists {
+			p.enums[key] = child.enums[key]
+			p.origins[key] = name
+		}
+	}
+
+	p.services = append(p.services, child.services...)
+	return nil
+}
+
+// checkDuplicate 检查 key 是否已在其他文件中定义
+func (p *Parser) checkDuplicate(name, key string) error {
+	origin, exists := p.origins[key]
+	if !exists {
+		return nil
+	}
+
+	err := fmt.Errorf("类型重复定义: %s, 已定义于 %s, 忽略 %s 中的定义", key, origin, name)
+	if p.options.StrictMode {
+		return err
+	}
+
+	clog.Warnf("[ProtoParser] %v", err)
+	return nil
+}
+
+// register 登记文件中定义的消息/枚举，返回是否需要添加
+// 同一文件内重复定义时保留先定义的版本，StrictMode 下返回错误
+func (p *Parser) register(name, key string) (bool, error) {
+	if err := p.checkDuplicate(name, key); err != nil {
+		return false, err
+	}
+
+	if _, exists := p.origins[key]; exists {
+		return false, nil
+	}
+
+	p.origins[key] = name
+	return true, nil
+}
+
+// BuildSchema 根据已解析的消息生成 Pomelo Schema
+func (p *Parser) BuildSchema() (*ProtoSchema, error) {
+	// 所有文件解析完成后，再解析字段引用的类型（支持前向引用）
+	p.resolveFieldTypes()
+
+	// 字段类型拼写错误时解析为未定义的消息类型，FailOnUnknownTypes 下直接返回错误
+	if err := p.checkUnknownTypes(); err != nil {
+		return nil, err
+	}
+
+	// 合并手动配置的路由与 service 自动生成的路由
+	p.collectRoutes()
+
+	// StrictRoutes 下检查路由配置的消息是否存在
+	if err := p.checkRoutes(); err != nil {
+		return nil, err
+	}
+
+	// 检查路由消息引用的类型是否都已定义
+	if err := p.checkUnresolvedTypes(); err != nil {
+		return nil, err
+	}
+
+	// pomelo 始终按 packed 编码重复的数值字段，未 packed 的字段与标准 protobuf 客户端不兼容
+	p.checkPacked()
+
+	// 路由消息仍在使用废弃字段，提醒尽快迁移
+	if deprecated := p.DeprecatedFields(); len(deprecated) > 0 {
+		clog.Warnf("[ProtoParser] 路由消息使用了废弃字段: [%s]", strings.Join(deprecated, ", "))
+	}
+
+	// 多个路由共用同一个消息通常是复制路由配置时漏改了消息名
+	if !p.options.IgnoreSharedMessages {
+		for _, shared := range p.SharedMessages() {
+			clog.Warnf("[ProtoParser] 多个 %s 路由引用了同一个消息 %s: [%s]，有意复用时可配置 AllowSharedMessages",
+				shared.Side, shared.Message, strings.Join(shared.Routes, ", "))
+		}
+	}
+
+	// 没有被任何路由引用的消息不会出现在 schema 中，可能是遗漏了路由配置
+	if unrouted := p.UnroutedMessages(); len(unrouted) > 0 && len(p.serverRoutes)+len(p.clientRoutes) > 0 {
+		clog.Warnf("[ProtoParser] 消息未被任何路由引用，不会下发到客户端: [%s]", strings.Join(unrouted, ", "))
+	}
+
+	// 生成 Pomelo Schema
+	schema := p.buildSchema()
+	return schema, nil
+}
+
+// UnroutedMessages 返回没有被任何路由直接或间接（嵌套字段）引用的消息全名，按字典序排列
+// 这些消息不会出现在 schema 中（GlobalMessages 模式下也不会进入全局 __messages__），不包含 map entry 消息
+// 需要在 BuildSchema/Parse 之后调用
+func (p *Parser) UnroutedMessages() []string {
+	reachable := p.reachableMessages()
+
+	var unrouted []string
+	for _, name := range sortedKeys(p.messages) {
+		if !reachable[name] && !p.messages[name].MapEntry {
+			unrouted = append(unrouted, name)
+		}
+	}
+	return unrouted
+}
+
+// SharedMessages 返回被多个同方向路由引用的消息，不包含 AllowSharedMessages 中声明的消息
+// 按方向（server 在前）和消息全名排序，未找到的路由消息不参与检查，需要在 BuildSchema/Parse 之后调用
+func (p *Parser) SharedMessages() []SharedMessage {
+	allowed := make(map[string]bool, len(p.options.AllowSharedMessages))
+	for _, name := range p.options.AllowSharedMessages {
+		if msg, found := p.lookupMessage(name); found {
+			allowed[msg.FullName()] = true
+		}
+	}
+
+	var shared []SharedMessage
+	for _, side := range []struct {
+		name   string
+		routes map[string]string
+	}{
+		{"server", p.serverRoutes},
+		{"client", p.clientRoutes},
+	} {
+		byMessage := make(map[string][]string)
+		for _, route := range sortedKeys(side.routes) {
+			if msg, found := p.lookupMessage(side.routes[route]); found && !allowed[msg.FullName()] {
+				byMessage[msg.FullName()] = append(byMessage[msg.FullName()], route)
+			}
+		}
+
+		for _, name := range sortedKeys(byMessage) {
+			if routes := byMessage[name]; len(routes) > 1 {
+				shared = append(shared, SharedMessage{Side: side.name, Message: name, Routes: routes})
+			}
+		}
+	}
+	return shared
+}
+
+// DeprecatedFields 返回路由消息（包含其嵌套引用的消息）中声明了 [deprecated = true] 的字段，
+// 格式为 "消息全名.字段名"，按字典序排列，需要在 BuildSchema/Parse 之后调用
+func (p *Parser) DeprecatedFields() []string {
+	reachable := p.reachableMessages()
+
+	var deprecated []string
+	for _, name := range sortedKeys(reachable) {
+		for _, field := range p.messages[name].Fields {
+			if field.Deprecated() {
+				deprecated = append(deprecated, name+"."+field.Name)
+			}
+		}
+	}
+	return deprecated
+}
+
+// reachableMessages 返回从路由消息出发，沿消息类型字段可以到达的所有消息全名
+func (p *Parser) reachableMessages() map[string]bool {
+	reachable := make(map[string]bool)
+
+	var walk func(name string)
+	walk = func(name string) {
+		msg, found := p.messages[name]
+		if !found || reachable[name] {
+			return
+		}
+		reachable[name] = true
+
+		for _, field := range msg.Fields {
+			if field.Type == TypeMessage {
+				walk(field.TypeName)
+			}
+		}
+	}
+
+	for _, routes := range []map[string]string{p.serverRoutes, p.clientRoutes} {
+		for _, msgName := range routes {
+			if msg, found := p.lookupMessage(msgName); found {
+				walk(msg.FullName())
+			}
+		}
+	}
+
+	return reachable
+}
+
+// getProtoFiles 获取所有 proto 文件路径
+func (p *Parser) getProtoFiles() ([]string, error) {
+	if p.options.ManifestFile != "" {
+		return readManifest(p.options.ManifestFile)
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+
+	addFile := func(file string) {
+		if p.isExcluded(p.relativeProtoPath(file)) {
+			return
+		}
+
+		key := file
+		if abs, err := filepath.Abs(file); err == nil {
+			key = abs
+		}
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		files = append(files, file)
+	}
+
+	// 添加直接指定的文件，支持 glob 模式（含 ** 递归匹配）
+	for _, pattern := range p.options.ProtoFiles {
+		if !hasGlobMeta(pattern) {
+			addFile(pattern)
+			continue
+		}
+
+		matches, err := globFiles(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("glob 未匹配到任何文件: %s", pattern)
+		}
+		for _, match := range matches {
+			addFile(match)
+		}
+	}
+
+	// 依次扫描各个目录，多个目录包含同一文件（如目录嵌套）时只添加一次
+	for _, dir := range p.options.ScanDirs() {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && p.options.MatchExtension(info.Name()) {
+				addFile(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// readManifest 读取 proto 文件清单，返回按清单顺序排列的文件路径，重复或不存在的文件返回错误
+func readManifest(manifest string) ([]string, error) {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("读取 proto 文件清单失败: %w", err)
+	}
+
+	var entries []string
+	if content := bytes.TrimSpace(data); len(content) > 0 && content[0] == '[' {
+		if err := jsoniter.Unmarshal(content, &entries); err != nil {
+			return nil, fmt.Errorf("解析 proto 文件清单失败: %s, %w", manifest, err)
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+	}
+
+	dir := filepath.Dir(manifest)
+	files := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		file := entry
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+
+		if seen[file] {
+			return nil, fmt.Errorf("proto 文件清单中存在重复的文件: %s, %s", manifest, entry)
+		}
+		seen[file] = true
+
+		if _, err := os.Stat(file); err != nil {
+			return nil, fmt.Errorf("proto 文件清单中的文件不存在: %s, %w", manifest, err)
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// relativeProtoPath 返回用于排除匹配的相对路径，扫描目录下的文件相对于所在的扫描目录，其他文件保持原路径
+func (p *Parser) relativeProtoPath(file string) string {
+	for _, dir := range p.options.ScanDirs() {
+		if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+
+	return filepath.ToSlash(file)
+}
+
+// isExcluded 判断文件是否匹配 ExcludePatterns
+// 不含 "/" 的模式同时匹配文件名，如 "*_test.proto" 可排除任意目录下的测试文件
+func (p *Parser) isExcluded(rel string) bool {
+	for _, pattern := range p.options.ExcludePatterns {
+		pattern = filepath.ToSlash(pattern)
+		if matchGlobSegments(strings.Split(pattern, "/"), strings.Split(rel, "/")) {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(rel)); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasGlobMeta 判断路径是否包含 glob 通配符
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// globFiles 展开 glob 模式，在 filepath.Glob 的基础上支持 ** 匹配任意层目录
+func globFiles(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+
+	// 从第一个包含通配符的段之前的目录开始遍历
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	static := 0
+	for static < len(segments) && !hasGlobMeta(segments[static]) {
+		static++
+	}
+
+	root := strings.Join(segments[:static], "/")
+	if root == "" {
+		root = "."
+		if strings.HasPrefix(pattern, "/") {
+			root = "/"
+		}
+	}
+
+	var matches []string
+	err := filepath.Walk(filepath.FromSlash(root), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == filepath.FromSlash(root) && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(filepath.FromSlash(root), path)
+		if err != nil {
+			return err
+		}
+		if matchGlobSegments(segments[static:], strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// matchGlobSegments 按路径段匹配，** 段匹配零个或多个目录
+func matchGlobSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlobSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	matched, err := path.Match(pattern[0], name[0])
+	if err != nil || !matched {
+		return false
+	}
+
+	return matchGlobSegments(pattern[1:], name[1:])
+}
+
+// getFSProtoFiles 获取 ProtoFS 中 ProtoFSDir 目录下的所有 proto 文件路径
+func (p *Parser) getFSProtoFiles() ([]string, error) {
+	if p.options.ProtoFS == nil {
+		return nil, nil
+	}
+
+	root := p.options.ProtoFSDir
+	if root == "" {
+		root = "."
+	}
+
+	var files []string
+	err := fs.WalkDir(p.options.ProtoFS, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !p.options.MatchExtension(d.Name()) {
+			return nil
+		}
+
+		rel := path
+		if root != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+		}
+		if !p.isExcluded(rel) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// resolveImport 解析 import 的文件路径
+// 磁盘文件：先相对于 import 所在文件的目录查找，再依次在 Options.ImportPaths 中查找
+// ProtoFS 文件：先相对于 import 所在文件的目录查找，再在 ProtoFSDir 中查找
+func (p *Parser) resolveImport(from protoSource, importPath string) (protoSource, error) {
+	if from.fsys != nil {
+		dirs := []string{path.Dir(from.path)}
+		if p.options.ProtoFSDir != "" {
+			dirs = append(dirs, p.options.ProtoFSDir)
+		}
+
+		for _, dir := range dirs {
+			file := path.Join(dir, importPath)
+			if info, err := fs.Stat(from.fsys, file); err == nil && !info.IsDir() {
+				return protoSource{fsys: from.fsys, path: file}, nil
+			}
+		}
+
+		return protoSource{}, fmt.Errorf("import 文件未找到: file=%s, import=%s, searched=%v", from, importPath, dirs)
+	}
+
+	dirs := make([]string, 0, len(p.options.ImportPaths)+1)
+	dirs = append(dirs, filepath.Dir(from.path))
+	dirs = append(dirs, p.options.ImportPaths...)
+
+	for _, dir := range dirs {
+		file := filepath.Join(dir, importPath)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return protoSource{path: file}, nil
+		}
+	}
+
+	return protoSource{}, fmt.Errorf("import 文件未找到: file=%s, import=%s, searched=%v", from, importPath, dirs)
+}
+
+// parseSource 解析磁盘或 ProtoFS 中的 proto 文件
+func (p *Parser) parseSource(source protoSource) ([]string, error) {
+	if source.fsys == nil {
+		return p.parseFile(source.path)
+	}
+
+	data, err := fs.ReadFile(source.fsys, source.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseReader(source.String(), bytes.NewReader(data))
+}
+
+// parseFile 解析单个 proto 文件，返回文件中 import 的路径列表
+func (p *Parser) parseFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return p.parseReader(filePath, file)
+}
+
+// parseReader 解析 proto 内容，返回其中 import 的路径列表
+func (p *Parser) parseReader(name string, r io.Reader) ([]string, error) {
+	maxLineBytes := p.options.MaxLineBytes
+	if maxLineBytes <= 0 {
+		maxLineBytes = DefaultMaxLineBytes
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	// blocks 当前所在的大括号层级，message 为 nil 表示非 message 的 block（如 oneof、service）
+	var blocks []protoBlock
+	var currentEnum *ProtoEnum
+
+	// 正则表达式
+	messageRegex := regexp.MustCompile(`^\s*message\s+(\w+)\s*(\{)?\s*$`)
+	enumRegex := regexp.MustCompile(`^\s*enum\s+(\w+)\s*(\{)?\s*$`)
+	enumValueRegex := regexp.MustCompile(`^\s*(\w+)\s*=\s*(-?\d+)\s*(\[[^\]]*\])?\s*;`)
+	fieldRegex := regexp.MustCompile(`^\s*(?:(repeated|required|optional)\s+)?(\.?\w+(?:\.\w+)*)\s+(\w+)\s*=\s*(\w+)\s*(?:\[(.*)\])?\s*;`)
+	mapRegex := regexp.MustCompile(`^\s*map\s*<\s*([\w.]+)\s*,\s*([\w.]+)\s*>\s+(\w+)\s*=\s*(\w+)\s*(?:\[(.*)\])?\s*;`)
+	packageRegex := regexp.MustCompile(`^\s*package\s+([\w.]+)\s*;`)
+	importRegex := regexp.MustCompile(`^\s*import\s+(?:public\s+|weak\s+)?"([^"]+)"\s*;`)
+	serviceRegex := regexp.MustCompile(`^\s*service\s+(\w+)\s*(\{)?\s*$`)
+	rpcRegex := regexp.MustCompile(`^\s*rpc\s+(\w+)\s*\(\s*(stream\s+)?([\w.]+)\s*\)\s*returns\s*\(\s*(stream\s+)?([\w.]+)\s*\)`)
+	oneofRegex := regexp.MustCompile(`^\s*oneof\s+(\w+)\s*(\{)?\s*$`)
+	reservedRegex := regexp.MustCompile(`^\s*reserved\s+(.+?)\s*;`)
+	syntaxRegex := regexp.MustCompile(`^\s*syntax\s*=\s*["']([^"']*)["']\s*;`)
+	optionRegex := regexp.MustCompile(`^\s*option(?:\s+\w|\s*\()`)
+
+	// currentMessage 返回最近的外层 message
+	currentMessage := func() *ProtoMessage {
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if blocks[i].message != nil {
+				return blocks[i].message
+			}
+		}
+		return nil
+	}
+
+	// currentOneof 返回当前所在的 oneof 名称
+	currentOneof := func() string {
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if blocks[i].message != nil {
+				return ""
+			}
+			if blocks[i].oneof != "" {
+				return blocks[i].oneof
+			}
+		}
+		return ""
+	}
+
+	// qualifiedName 嵌套定义使用外层 message 名称作为前缀，如 Outer.Inner
+	qualifiedName := func(name string) string {
+		if parent := currentMessage(); parent != nil {
+			return parent.Name + "." + name
+		}
+		return name
+	}
+
+	var inComment bool    // 是否处于跨行的 /* */ 注释中
+	var docLines []string // 紧邻下一个声明之前的 // 注释，作为该声明的文档
+	var pending string    // 未以 ; { } 结束的语句（如格式化工具折行的字段），与后续行拼接后再解析
+	var pendingDoc string // pending 语句的文档
+	var optionDepth int   // 聚合 option 值（option (x) = { ... };）中尚未闭合的大括号数量
+	var imports []string
+	var pkg string            // 当前文件的包名
+	var syntax = SyntaxProto2 // 当前文件的语法版本，未声明时按 protobuf 的规则视为 proto2
+	var syntaxDeclared bool   // 是否声明了 syntax
+
+	for lineNo := 0; scanner.Scan(); lineNo++ {
+		// Windows 下编写的文件可能带 UTF-8 BOM 和 CRLF 换行
+		raw := strings.TrimSuffix(scanner.Text(), "\r")
+		if lineNo == 0 {
+			raw = strings.TrimPrefix(raw, "\uFEFF")
+		}
+
+		// 整行的 // 注释累积为下一个 message/字段的文档，空行或其他语句会中断
+		if trimmedRaw := strings.TrimSpace(raw); !inComment && strings.HasPrefix(trimmedRaw, "//") {
+			docLines = append(docLines, strings.TrimSpace(strings.TrimLeft(trimmedRaw, "/")))
+			continue
+		}
+		doc := strings.Join(docLines, "\n")
+		docLines = nil
+
+		// 先去掉注释，避免注释中的大括号、关键字影响解析，行尾注释用于解析字段的校验约束
+		var text, comment string
+		text, comment, inComment = splitComment(raw, inComment)
+		if pending != "" {
+			text = pending + " " + text
+			doc = pendingDoc
+			pending = ""
+		}
+
+		// 按语句拆分，同一行的多个声明（如 message A { ... } message B { ... }）依次处理
+		// 行尾未结束的语句暂存，等到后续行出现结束符后再解析
+		statements := splitStatements(text)
+		if last := len(statements) - 1; last >= 0 && !strings.ContainsAny(statements[last][len(statements[last])-1:], "{;}") {
+			pending, pendingDoc = statements[last], doc
+			statements = statements[:last]
+		}
+
+		for _, line := range statements {
+			// 跳过空行
+			trimmedLine := strings.TrimSpace(line)
+			if trimmedLine == "" {
+				continue
+			}
+
+			// option 语句（文件、message、enum、service 级别）对 pomelo 没有意义，整体跳过
+			// 聚合值中的大括号不计入 block 层级，其中的内容也不按字段解析
+			if optionDepth > 0 || optionRegex.MatchString(line) {
+				optionDepth = max(optionDepth+countBraces(line), 0)
+				continue
+			}
+
+			// 顶层的 import 语句
+			if len(blocks) == 0 {
+				if matches := importRegex.FindStringSubmatch(line); matches != nil {
+					imports = append(imports, matches[1])
+					continue
+				}
+
+				if matches := packageRegex.FindStringSubmatch(line); matches != nil {
+					pkg = matches[1]
+					continue
+				}
+
+				if matches := syntaxRegex.FindStringSubmatch(line); matches != nil {
+					if matches[1] != SyntaxProto2 && matches[1] != SyntaxProto3 {
+						return nil, fmt.Errorf("不支持的 syntax: file=%s, syntax=%s", name, matches[1])
+					}
+					syntax = matches[1]
+					syntaxDeclared = true
+					continue
+				}
+
+				if matches := serviceRegex.FindStringSubmatch(line); matches != nil {
+					service := &ProtoService{
+						Name:       matches[1],
+						Package:    pkg,
+						Methods:    make([]*ProtoRPC, 0),
+						SourceFile: name,
+					}
+					p.services = append(p.services, service)
+					blocks = append(blocks, protoBlock{service: service})
+					continue
+				}
+			}
+
+			// service 内部的 rpc 定义
+			if len(blocks) > 0 && blocks[len(blocks)-1].service != nil {
+				if matches := rpcRegex.FindStringSubmatch(line); matches != nil {
+					service := blocks[len(blocks)-1].service
+					service.Methods = append(service.Methods, &ProtoRPC{
+						Name:           matches[1],
+						RequestType:    matches[3],
+						ResponseType:   matches[5],
+						RequestStream:  matches[2] != "",
+						ResponseStream: matches[4] != "",
+					})
+				}
+			}
+
+			// 在 enum 内部：只解析枚举值，enum 的大括号不计入 block 层级
+			if currentEnum != nil {
+				if matches := enumValueRegex.FindStringSubmatch(line); matches != nil {
+					value, _ := strconv.Atoi(matches[2])
+					if value < 0 {
+						// pomelo 按 uInt32 编码枚举，负值会被编码为超大的无符号数，客户端无法正确解码
+						// 严格模式下返回错误，否则输出警告
+						err := fmt.Errorf("枚举值不能为负数: file=%s, enum=%s, value=%s = %d",
+							name, currentEnum.FullName(), matches[1], value)
+						if p.options.StrictMode {
+							return nil, err
+						}
+						clog.Warnf("[ProtoParser] %v", err)
+					}
+
+					currentEnum.Values = append(currentEnum.Values, &ProtoEnumValue{
+						Name:  matches[1],
+						Value: value,
+					})
+				}
+
+				if strings.Contains(line, "}") {
+					if added, err := p.register(name, currentEnum.FullName()); err != nil {
+						return nil, err
+					} else if added {
+						p.enums[currentEnum.FullName()] = currentEnum
+					}
+					currentEnum = nil
+				}
+				continue
+			}
+
+			// 检查 enum 开始（顶层或 message 内部）
+			if matches := enumRegex.FindStringSubmatch(line); matches != nil {
+				currentEnum = &ProtoEnum{
+					Name:    qualifiedName(matches[1]),
+					Package: pkg,
+					Values:  make([]*ProtoEnumValue, 0),
+				}
+				continue
+			}
+
+			// 检查 message 开始（顶层或 message 内部）
+			if matches := messageRegex.FindStringSubmatch(line); matches != nil {
+				blocks = append(blocks, protoBlock{
+					message: &ProtoMessage{
+						Name:       qualifiedName(matches[1]),
+						Package:    pkg,
+						Fields:     make([]*ProtoField, 0),
+						Syntax:     syntax,
+						Doc:        doc,
+						SourceFile: name,
+					},
+				})
+				doc = ""
+				continue
+			}
+
+			// 检查 oneof 开始，pomelo 没有 oneof，成员按普通 optional 字段处理
+			if matches := oneofRegex.FindStringSubmatch(line); matches != nil && currentMessage() != nil {
+				blocks = append(blocks, protoBlock{oneof: matches[1]})
+				continue
+			}
+
+			// 在 message 内部
+			if msg := currentMessage(); msg != nil {
+				// 解析 reserved 声明: reserved 2, 9 to 11; reserved "foo";
+				if matches := reservedRegex.FindStringSubmatch(line); matches != nil {
+					p.parseReserved(name, msg, matches[1])
+				} else if matches := mapRegex.FindStringSubmatch(line); matches != nil {
+					if tag, ok, err := p.fieldTag(name, msg, matches[3], matches[4]); err != nil {
+						return nil, err
+					} else if ok {
+						p.parseMapField(msg, matches, tag)
+					}
+				} else if matches := fieldRegex.FindStringSubmatch(line); matches != nil {
+					// 解析普通字段
+					// proto2 需要显式声明 required/optional/repeated，proto3 未声明时按 optional 处理
+					label := matches[1]
+					fieldType := matches[2]
+					fieldName := matches[3]
+					tag, ok, err := p.fieldTag(name, msg, fieldName, matches[4])
+					if err != nil {
+						return nil, err
+					}
+					if !ok {
+						continue
+					}
+
+					constraints, err := p.parseConstraints(name, msg, fieldName, doc+"\n"+comment)
+					if err != nil {
+						return nil, err
+					}
+
+					field := &ProtoField{
+						Name:      fieldName,
+						Tag:       tag,
+						Repeated:  label == "repeated",
+						Required:  label == "required",
+						OneofName: currentOneof(),
+						Options:   parseFieldOptions(matches[5]),
+						Doc:       doc,
+					}
+					field.Constraints = constraints
+					doc, comment = "", ""
+
+					// 判断类型
+					if pomeloType, ok := p.pomeloType(fieldType); ok {
+						field.Type = pomeloType
+					} else {
+						// 自定义消息类型，所有文件解析完成后再解析引用
+						field.Type = TypeMessage
+						field.TypeName = fieldType
+					}
+
+					// 自定义类型可能是枚举，先按可 packed 处理，解析引用后消息类型会被清除
+					if field.Repeated && field.Type != TypeString && field.Type != TypeBytes {
+						field.Packed = isPacked(syntax, field.Options)
+					}
+					if field.Type == TypeMessage {
+						field.declaredType, field.declaredPacked = field.TypeName, field.Packed
+					}
+
+					if err := p.checkLabel(name, msg, field, label, syntax, syntaxDeclared); err != nil {
+						return nil, err
+					}
+
+					msg.Fields = append(msg.Fields, field)
+				}
+			}
+
+			// 计算大括号层级，message 结束时注册
+			for i := strings.Count(line, "{"); i > 0; i-- {
+				blocks = append(blocks, protoBlock{})
+			}
+
+			for i := strings.Count(line, "}"); i > 0 && len(blocks) > 0; i-- {
+				closed := blocks[len(blocks)-1]
+				blocks = blocks[:len(blocks)-1]
+				if closed.message != nil {
+					if err := p.validateMessage(name, closed.message); err != nil {
+						return nil, err
+					}
+					if added, err := p.register(name, closed.message.FullName()); err != nil {
+						return nil, err
+					} else if added {
+						p.messages[closed.message.FullName()] = closed.message
+					}
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("存在超过 %d 字节的行，文件未完整解析，可调整 Options.MaxLineBytes: %w", maxLineBytes, err)
+		}
+		return nil, err
+	}
+
+	if strings.TrimSpace(pending) != "" {
+		clog.Warnf("[ProtoParser] 文件末尾存在未结束的语句，已忽略: file=%s, statement=%s", name, strings.TrimSpace(pending))
+	}
+
+	if !syntaxDeclared {
+		clog.Warnf("[ProtoParser] 未声明 syntax，按 proto2 处理: file=%s", name)
+	}
+
+	return imports, nil
+}
+
+// validateMessage 校验单个 message 的定义
+// 严格模式下返回错误，否则只输出警告
+func (p *Parser) validateMessage(filePath string, msg *ProtoMessage) error {
+	tags := make(map[int]*ProtoField, len(msg.Fields))
+	names := make(map[string]*ProtoField, len(msg.Fields))
+	for _, field := range msg.Fields {
+		if err := p.checkFieldName(filePath, msg, field, names); err != nil {
+			return err
+		}
+
+		if err := p.checkTagRange(filePath, msg, field); err != nil {
+			return err
+		}
+
+		if err := p.checkReserved(filePath, msg, field); err != nil {
+			return err
+		}
+
+		exist, found := tags[field.Tag]
+		if !found {
+			tags[field.Tag] = field
+			continue
+		}
+
+		err := fmt.Errorf("字段标签号重复: file=%s, message=%s, tag=%d, fields=[%s, %s]",
+			filePath, msg.FullName(), field.Tag, exist.Name, field.Name)
+		if p.options.StrictMode {
+			return err
+		}
+		clog.Warnf("[ProtoParser] %v", err)
+	}
+
+	return nil
+}
+
+// checkFieldName 检查生成 schema 时的字段名（json_name 或转换命名风格后）是否与同一 message 中的其他字段冲突
+func (p *Parser) checkFieldName(filePath string, msg *ProtoMessage, field *ProtoField, names map[string]*ProtoField) error {
+	name := p.fieldName(field)
+	exist, found := names[name]
+	if !found {
+		names[name] = field
+		return nil
+	}
+
+	err := fmt.Errorf("字段名重复: file=%s, message=%s, name=%s, fields=[%s, %s]",
+		filePath, msg.FullName(), name, exist.Name, field.Name)
+	if p.options.StrictMode {
+		return err
+	}
+	clog.Warnf("[ProtoParser] %v", err)
+	return nil
+}
+
+// pomeloType 获取 proto 类型对应的 Pomelo 类型，Options.TypeOverrides 优先于内置映射
+// 开启 MapWellKnownTypes 时，google.protobuf 的 Timestamp、Duration 和包装类型映射为基础类型
+func (p *Parser) pomeloType(protoType string) (FieldType, bool) {
+	if t, ok := p.options.TypeOverrides[protoType]; ok {
+		return t, true
+	}
+
+	if p.options.MapWellKnownTypes {
+		if t, ok := wellKnownTypeMapping[strings.TrimPrefix(protoType, ".")]; ok {
+			return t, true
+		}
+	}
+
+	return GetPomeloType(protoType)
+}
+
+// fieldTag 解析字段标签号，支持十六进制（0x 前缀）、八进制（0 前缀）和数字分组的下划线（如 1_000）
+// 无法解析时严格模式下返回错误，否则输出警告并忽略该字段（ok 为 false）。范围由 checkTagRange 检查
+func (p *Parser) fieldTag(filePath string, msg *ProtoMessage, fieldName, raw string) (tag int, ok bool, err error) {
+	n, parseErr := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 0, 64)
+	if parseErr == nil {
+		return int(n), true, nil
+	}
+
+	err = fmt.Errorf("字段标签号无效: file=%s, message=%s, field=%s, tag=%s", filePath, msg.FullName(), fieldName, raw)
+	if p.options.StrictMode {
+		return 0, false, err
+	}
+
+	clog.Warnf("[ProtoParser] %v", err)
+	return 0, false, nil
+}
+
+// parseMapField 解析 map 字段，在 wire 上表现为 repeated message Entry
+// 字段的 [...] 选项（如 json_name、deprecated）作用于 map 字段本身
+func (p *Parser) parseMapField(msg *ProtoMessage, matches []string, tag int) {
+	keyTypeRaw := matches[1]
+	valueTypeRaw := matches[2]
+	fieldName := matches[3]
+
+	// 生成 map entry message
+	// 注意：该名字不会出现在 wire 上，只要 schema 内一致即可
+	entryMsgName := msg.Name + "_" + fieldName + "Entry"
+
+	// 构建 entry 消息
+	entryMsg := &ProtoMessage{
+		Name:       entryMsgName,
+		Package:    msg.Package,
+		Fields:     make([]*ProtoField, 0, 2),
+		MapEntry:   true,
+		Syntax:     msg.Syntax,
+		SourceFile: msg.SourceFile,
+	}
+
+	// key 字段（tag=1）
+	keyField := &ProtoField{
+		Name:     "key",
+		Tag:      1,
+		Repeated: false,
+	}
+	if pomeloType, ok := p.pomeloType(keyTypeRaw); ok && mapKeyTypes[keyTypeRaw] {
+		keyField.Type = pomeloType
+	} else {
+		// map 的 key 必须是整型或 string；如果解析失败，退化为 string
+		clog.Warnf("[ProtoParser] map key 类型不支持，已退化为 string: %s (field=%s.%s)", keyTypeRaw, msg.Name, fieldName)
+		keyField.Type = TypeString
+	}
+	entryMsg.Fields = append(entryMsg.Fields, keyField)
+
+	// value 字段（tag=2）
+	valueField := &ProtoField{
+		Name:     "value",
+		Tag:      2,
+		Repeated: false,
+	}
+	if pomeloType, ok := p.pomeloType(valueTypeRaw); ok {
+		valueField.Type = pomeloType
+	} else {
+		valueField.Type = TypeMessage
+		valueField.TypeName = valueTypeRaw
+		valueField.declaredType = valueTypeRaw
+	}
+	entryMsg.Fields = append(entryMsg.Fields, valueField)
+
+	// 注册 entry message
+	if _, exists := p.messages[entryMsg.FullName()]; !exists {
+		p.messages[entryMsg.FullName()] = entryMsg
+	}
+
+	// 当前 message 添加 map 字段：在 wire 上表现为 repeated message Entry
+	mapField := &ProtoField{
+		Name:     fieldName,
+		Tag:      tag,
+		Repeated: true,
+		Type:     TypeMessage,
+		TypeName: entryMsg.FullName(),
+		Options:  parseFieldOptions(matches[5]),
+	}
+	msg.Fields = append(msg.Fields, mapField)
+}
+
+// parseReserved 解析 reserved 声明的标签号（单个、逗号列表、a to b、a to max）和字段名
+func (p *Parser) parseReserved(filePath string, msg *ProtoMessage, raw string) {
+	for _, item := range splitOptions(raw) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if item[0] == '"' || item[0] == '\'' {
+			msg.Reserved.Names = append(msg.Reserved.Names, strings.Trim(item, `"'`))
+			continue
+		}
+
+		start, end, isRange := strings.Cut(item, " to ")
+		startTag, err := strconv.Atoi(strings.TrimSpace(start))
+		endTag := startTag
+		if err == nil && isRange {
+			if end = strings.TrimSpace(end); end == "max" {
+				endTag = MaxFieldTag
+			} else {
+				endTag, err = strconv.Atoi(end)
+			}
+		}
+
+		if err != nil || endTag < startTag {
+			clog.Warnf("[ProtoParser] 无效的 reserved 声明: file=%s, message=%s, reserved=%s", filePath, msg.FullName(), item)
+			continue
+		}
+
+		msg.Reserved.Ranges = append(msg.Reserved.Ranges, ProtoTagRange{Start: startTag, End: endTag})
+	}
+}
+
+// checkLabel 按语法版本检查字段的 required/optional/repeated 声明
+// proto3 不支持 required，按 optional 处理；显式声明 proto2 的文件中，oneof 以外的字段必须声明修饰符
+// 严格模式下返回错误，否则只输出警告
+func (p *Parser) checkLabel(filePath string, msg *ProtoMessage, field *ProtoField, label, syntax string, declared bool) error {
+	var err error
+	switch {
+	case syntax == SyntaxProto3 && label == string(ModifierRequired):
+		field.Required = false
+		err = fmt.Errorf("proto3 不支持 required 字段，按 optional 处理: file=%s, message=%s, field=%s",
+			filePath, msg.FullName(), field.Name)
+	case syntax == SyntaxProto2 && declared && label == "" && field.OneofName == "":
+		err = fmt.Errorf("proto2 字段未声明 required/optional/repeated，按 optional 处理: file=%s, message=%s, field=%s",
+			filePath, msg.FullName(), field.Name)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	if p.options.StrictMode {
+		return err
+	}
+
+	clog.Warnf("[ProtoParser] %v", err)
+	return nil
+}
+
+// checkTagRange 检查字段标签号是否在合法范围内，且不在 protobuf 内部保留的 19000~19999 之间
+func (p *Parser) checkTagRange(filePath string, msg *ProtoMessage, field *ProtoField) error {
+	var err error
+	if field.Tag < 1 || field.Tag > MaxFieldTag {
+		err = fmt.Errorf("字段标签号超出范围 1~%d: file=%s, message=%s, field=%s, tag=%d",
+			MaxFieldTag, filePath, msg.FullName(), field.Name, field.Tag)
+	} else if field.Tag >= FirstReservedTag && field.Tag <= LastReservedTag {
+		err = fmt.Errorf("字段标签号在 protobuf 保留范围 %d~%d 内: file=%s, message=%s, field=%s, tag=%d",
+			FirstReservedTag, LastReservedTag, filePath, msg.FullName(), field.Name, field.Tag)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	if p.options.StrictMode {
+		return err
+	}
+
+	clog.Warnf("[ProtoParser] %v", err)
+	return nil
+}
+
+// checkReserved 检查字段的标签号和名称是否与 reserved 声明冲突
+func (p *Parser) checkReserved(filePath string, msg *ProtoMessage, field *ProtoField) error {
+	var err error
+	if msg.Reserved.HasTag(field.Tag) {
+		err = fmt.Errorf("字段标签号已被 reserved: file=%s, message=%s, field=%s, tag=%d",
+			filePath, msg.FullName(), field.Name, field.Tag)
+	} else if msg.Reserved.HasName(field.Name) {
+		err = fmt.Errorf("字段名已被 reserved: file=%s, message=%s, field=%s",
+			filePath, msg.FullName(), field.Name)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	if p.options.StrictMode {
+		return err
+	}
+
+	clog.Warnf("[ProtoParser] %v", err)
+	return nil
+}
+
+// isPacked 根据语法版本和字段选项判断重复的数值字段是否为 packed 编码
+// proto3 默认 packed，可通过 [packed = false] 关闭；proto2 需要显式声明 [packed = true]
+func isPacked(syntax string, options map[string]string) bool {
+	if packed, found := options["packed"]; found {
+		return packed == "true"
+	}
+	return syntax == SyntaxProto3
+}
+
+// checkPacked 对未 packed 的重复数值字段输出警告
+func (p *Parser) checkPacked() {
+	for _, name := range sortedKeys(p.messages) {
+		for _, field := range p.messages[name].Fields {
+			if !field.Repeated || field.Packed {
+				continue
+			}
+			if field.Type == TypeString || field.Type == TypeBytes || field.Type == TypeMessage {
+				continue
+			}
+			clog.Warnf("[ProtoParser] 重复的数值字段未使用 packed 编码，pomelo 会按 packed 编解码: message=%s, field=%s", name, field.Name)
+		}
+	}
+}
+
+// parseFieldOptions 解析字段的 [key = value, ...] 选项，字符串值会去掉引号
+func parseFieldOptions(raw string) map[string]string {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+
+	options := make(map[string]string)
+	for _, item := range splitOptions(raw) {
+		key, value, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			if unquoted, err := strconv.Unquote(`"` + value[1:len(value)-1] + `"`); err == nil {
+				value = unquoted
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		}
+
+		options[key] = value
+	}
+
+	return options
+}
+
+// splitOptions 按逗号拆分选项，忽略引号内的逗号
+func splitOptions(raw string) []string {
+	var items []string
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, raw[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(items, raw[start:])
+}
+
+// splitStatements 将一行拆分为以 {、; 或 } 结尾的语句，引号和 [] 内的字符不作为分隔
+// 行尾没有结束符的部分（如 { 在下一行的 message 声明）作为最后一条语句
+func splitStatements(line string) []string {
+	var statements []string
+	var quote byte
+	depth := 0
+	start := 0
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && (c == '{' || c == ';' || c == '}'):
+			statements = append(statements, line[start:i+1])
+			start = i + 1
+		}
+	}
+
+	if strings.TrimSpace(line[start:]) != "" {
+		statements = append(statements, line[start:])
+	}
+
+	return statements
 }
 
-// NewParser 创建解析器
-func NewParser(opts Options) *Parser {
-	return &Parser{
-		options:  opts,
-		messages: make(map[string]*ProtoMessage),
+// countBraces 统计语句中字符串字面量之外的 { 与 } 数量之差
+func countBraces(line string) int {
+	var quote byte
+	n := 0
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			n++
+		case c == '}':
+			n--
+		}
 	}
+
+	return n
 }
 
-// Parse 解析 proto 文件并生成 Pomelo Schema
-func (p *Parser) Parse() (*ProtoSchema, error) {
-	if !p.options.HasProtoConfig() {
-		return nil, nil
+// splitComment 去掉一行中的 // 和 /* */ 注释，字符串字面量中的内容保持不变
+// inComment 表示该行开始时是否处于跨行的块注释中，返回值为去掉注释后的内容、行尾 // 注释的内容和行尾的块注释状态
+func splitComment(line string, inComment bool) (text, comment string, stillInComment bool) {
+	var sb strings.Builder
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		if inComment {
+			if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+				inComment = false
+				i++
+			}
+			continue
+		}
+
+		if quote != 0 {
+			sb.WriteByte(c)
+			if c == '\\' && i+1 < len(line) {
+				i++
+				sb.WriteByte(line[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		if c == '/' && i+1 < len(line) {
+			if line[i+1] == '/' {
+				comment = strings.TrimSpace(strings.TrimLeft(line[i:], "/"))
+				break
+			}
+			if line[i+1] == '*' {
+				inComment = true
+				i++
+				continue
+			}
+		}
+
+		if c == '"' || c == '\'' {
+			quote = c
+		}
+		sb.WriteByte(c)
 	}
 
-	// 获取所有 proto 文件
-	files, err := p.getProtoFiles()
-	if err != nil {
-		return nil, fmt.Errorf("获取 proto 文件失败: %w", err)
+	return sb.String(), comment, inComment
+}
+
+// normalizeTypeName 将带包名的类型引用简化为最后一段（例如 foo.bar.Baz -> Baz）
+func normalizeTypeName(t string) string {
+	if strings.Contains(t, ".") {
+		parts := strings.Split(t, ".")
+		return parts[len(parts)-1]
 	}
+	return t
+}
 
-	if len(files) == 0 {
-		clog.Warn("[ProtoParser] 没有找到 proto 文件")
-		return nil, nil
+// resolveFieldTypes 解析自定义类型的字段，区分消息类型与枚举类型
+// 引用的类型名会被替换为注册时使用的限定名称（如 Outer.Inner）
+func (p *Parser) resolveFieldTypes() {
+	for _, msg := range p.messages {
+		for _, field := range msg.Fields {
+			// 从声明的类型重新解析，引用的类型所在文件被 ReparseFile 更新后结果与完整解析一致
+			if field.declaredType != "" {
+				field.Type, field.TypeName, field.Packed = TypeMessage, field.declaredType, field.declaredPacked
+			}
+
+			if field.Type != TypeMessage {
+				continue
+			}
+
+			if name, ok := resolveTypeName(p.messages, msg.FullName(), field.TypeName); ok {
+				field.TypeName = name
+				field.Packed = false
+				continue
+			}
+
+			if name, ok := resolveTypeName(p.enums, msg.FullName(), field.TypeName); ok {
+				field.Type = TypeEnum
+				field.TypeName = name
+				continue
+			}
+
+			// google.protobuf.Empty 没有字段，注册为空消息
+			if p.options.MapWellKnownTypes && strings.TrimPrefix(field.TypeName, ".") == wellKnownEmpty {
+				if _, exists := p.messages[wellKnownEmpty]; !exists {
+					p.messages[wellKnownEmpty] = &ProtoMessage{
+						Name:    "Empty",
+						Package: "google.protobuf",
+						Fields:  make([]*ProtoField, 0),
+					}
+				}
+				field.TypeName = wellKnownEmpty
+				continue
+			}
+
+			if msg.MapEntry {
+				clog.Warnf("[ProtoParser] map value 类型未找到: %s (entry=%s)", field.TypeName, msg.FullName())
+			}
+
+			field.TypeName = normalizeTypeName(field.TypeName)
+			field.Packed = false
+		}
 	}
+}
 
-	// 解析所有 proto 文件
-	for _, file := range files {
-		if err := p.parseFile(file); err != nil {
-			clog.Warnf("[ProtoParser] 解析文件失败: %s, 错误: %v", file, err)
-			continue
+// collectRoutes 合并手动配置的路由和 service 自动生成的路由，手动配置优先
+func (p *Parser) collectRoutes() {
+	p.serverRoutes = make(map[string]string, len(p.options.ServerRoutes))
+	p.clientRoutes = make(map[string]string, len(p.options.ClientRoutes))
+	p.streamRoutes = make(map[string]RouteStream)
+
+	if p.options.AutoRouteFromService {
+		for _, service := range p.services {
+			for _, rpc := range service.Methods {
+				route := p.serviceRoute(service, rpc)
+				p.clientRoutes[route] = p.rpcTypeName(service, rpc.RequestType)
+				p.serverRoutes[route] = p.rpcTypeName(service, rpc.ResponseType)
+				if rpc.RequestStream || rpc.ResponseStream {
+					p.streamRoutes[route] = RouteStream{Request: rpc.RequestStream, Response: rpc.ResponseStream}
+				}
+			}
 		}
 	}
 
-	// 生成 Pomelo Schema
-	schema := p.buildSchema()
-	return schema, nil
+	for _, mapping := range p.options.RouteMappings {
+		if mapping.RequestMsg != "" {
+			p.clientRoutes[mapping.Route] = mapping.RequestMsg
+		}
+		if mapping.ResponseMsg != "" {
+			p.serverRoutes[mapping.Route] = mapping.ResponseMsg
+		}
+	}
+
+	for route, msgName := range p.options.ServerRoutes {
+		p.serverRoutes[route] = msgName
+	}
+
+	for route, msgName := range p.options.ClientRoutes {
+		p.clientRoutes[route] = msgName
+	}
 }
 
-// getProtoFiles 获取所有 proto 文件路径
-func (p *Parser) getProtoFiles() ([]string, error) {
-	var files []string
+// serviceRoute 根据 service 和 rpc 名称生成路由，如 connector.entryHandler.entry
+func (p *Parser) serviceRoute(service *ProtoService, rpc *ProtoRPC) string {
+	route := lowerFirst(service.Name) + "." + lowerFirst(rpc.Name)
+	if p.options.RoutePrefix != "" {
+		route = p.options.RoutePrefix + "." + route
+	}
+	return route
+}
 
-	// 添加直接指定的文件
-	files = append(files, p.options.ProtoFiles...)
+// rpcTypeName 在 service 所在包的作用域中解析 rpc 的消息类型
+func (p *Parser) rpcTypeName(service *ProtoService, typeName string) string {
+	if name, ok := resolveTypeName(p.messages, service.Package, typeName); ok {
+		return name
+	}
+	return typeName
+}
 
-	// 扫描目录
-	if p.options.ProtoDir != "" {
-		err := filepath.Walk(p.options.ProtoDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() && strings.HasSuffix(info.Name(), ".proto") {
-				files = append(files, path)
+// lowerFirst 首字母小写
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// checkRoutes StrictRoutes 下检查路由配置的消息是否都已定义，返回汇总所有缺失路由的错误
+func (p *Parser) checkRoutes() error {
+	if !p.options.StrictRoutes {
+		return nil
+	}
+
+	missing := func(routes map[string]string) []string {
+		var result []string
+		for _, route := range sortedKeys(routes) {
+			if _, found := p.lookupMessage(routes[route]); !found {
+				result = append(result, fmt.Sprintf("%s(message=%s)", route, routes[route]))
 			}
-			return nil
-		})
-		if err != nil {
-			return nil, err
 		}
+		return result
 	}
 
-	return files, nil
+	var details []string
+	if server := missing(p.serverRoutes); len(server) > 0 {
+		details = append(details, fmt.Sprintf("服务端路由: [%s]", strings.Join(server, ", ")))
+	}
+	if client := missing(p.clientRoutes); len(client) > 0 {
+		details = append(details, fmt.Sprintf("客户端路由: [%s]", strings.Join(client, ", ")))
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("路由消息未找到: %s", strings.Join(details, "; "))
 }
 
-// parseFile 解析单个 proto 文件
-func (p *Parser) parseFile(filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
+// checkUnknownTypes 开启 FailOnUnknownTypes 时检查所有消息中类型未定义的字段
+func (p *Parser) checkUnknownTypes() error {
+	if !p.options.FailOnUnknownTypes {
+		return nil
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	var currentMessage *ProtoMessage
-	var braceCount int
-	var inMessage bool
+	var unknown []string
+	for _, name := range sortedKeys(p.messages) {
+		for _, field := range p.messages[name].Fields {
+			if _, found := p.messages[field.TypeName]; field.Type == TypeMessage && !found {
+				unknown = append(unknown, fmt.Sprintf("%s.%s(type=%s)", name, field.Name, field.TypeName))
+			}
+		}
+	}
 
-	// 正则表达式
-	messageRegex := regexp.MustCompile(`^\s*message\s+(\w+)\s*\{?\s*$`)
-	fieldRegex := regexp.MustCompile(`^\s*(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+)\s*;`)
-	mapRegex := regexp.MustCompile(`^\s*map\s*<\s*([\w.]+)\s*,\s*([\w.]+)\s*>\s+(\w+)\s*=\s*(\d+)\s*;`)
-	openBraceRegex := regexp.MustCompile(`\{`)
-	closeBraceRegex := regexp.MustCompile(`\}`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// 跳过注释和空行
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "//") {
-			continue
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("存在未知的字段类型: %s", strings.Join(unknown, ", "))
+}
+
+// checkUnresolvedTypes 检查路由消息（包含其嵌套引用的消息）中未定义的消息类型
+// 严格模式下返回汇总的错误，否则输出每个未定义引用的警告
+func (p *Parser) checkUnresolvedTypes() error {
+	var unresolved []string
+	visited := make(map[string]bool)
+
+	var walk func(msg *ProtoMessage)
+	walk = func(msg *ProtoMessage) {
+		if visited[msg.FullName()] {
+			return
 		}
+		visited[msg.FullName()] = true
 
-		// 检查 message 开始
-		if matches := messageRegex.FindStringSubmatch(line); matches != nil {
-			messageName := matches[1]
-			currentMessage = &ProtoMessage{
-				Name:   messageName,
-				Fields: make([]*ProtoField, 0),
+		for _, field := range msg.Fields {
+			if field.Type != TypeMessage {
+				continue
 			}
-			inMessage = true
-			braceCount = strings.Count(line, "{") - strings.Count(line, "}")
-			if braceCount == 0 {
-				braceCount = 1 // message 定义后 { 可能在下一行
+
+			nested, found := p.messages[field.TypeName]
+			if !found {
+				unresolved = append(unresolved, fmt.Sprintf("%s.%s(type=%s)", msg.FullName(), field.Name, field.TypeName))
+				continue
+			}
+			walk(nested)
+		}
+	}
+
+	for _, routes := range []map[string]string{p.serverRoutes, p.clientRoutes} {
+		for _, route := range sortedKeys(routes) {
+			if msg, found := p.lookupMessage(routes[route]); found {
+				walk(msg)
 			}
-			continue
 		}
+	}
 
-		// 在 message 内部
-		if inMessage && currentMessage != nil {
-			// 计算大括号
-			braceCount += len(openBraceRegex.FindAllString(line, -1))
-			braceCount -= len(closeBraceRegex.FindAllString(line, -1))
+	if len(unresolved) == 0 {
+		return nil
+	}
 
-			// 解析 map 字段: map<keyType, valueType> fieldName = tag;
-			if matches := mapRegex.FindStringSubmatch(line); matches != nil {
-				keyTypeRaw := matches[1]
-				valueTypeRaw := matches[2]
-				fieldName := matches[3]
-				tag, _ := strconv.Atoi(matches[4])
+	if p.options.StrictMode {
+		return fmt.Errorf("存在未定义的消息类型引用: %s", strings.Join(unresolved, ", "))
+	}
 
-				keyType := normalizeTypeName(keyTypeRaw)
-				valueType := normalizeTypeName(valueTypeRaw)
+	for _, ref := range unresolved {
+		clog.Warnf("[ProtoParser] 未定义的消息类型引用: %s", ref)
+	}
 
-				// 生成 map entry message
-				// 注意：该名字不会出现在 wire 上，只要 schema 内一致即可
-				entryMsgName := currentMessage.Name + "_" + fieldName + "Entry"
+	return nil
+}
 
-				// 构建 entry 消息
-				entryMsg := &ProtoMessage{
-					Name:   entryMsgName,
-					Fields: make([]*ProtoField, 0, 2),
-				}
+// sortedKeys 返回排序后的 key 列表，保证遍历顺序稳定
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-				// key 字段（tag=1）
-				keyField := &ProtoField{
-					Name:     "key",
-					Tag:      1,
-					Repeated: false,
-				}
-				if pomeloType, ok := GetPomeloType(keyType); ok {
-					keyField.Type = pomeloType
-				} else {
-					// map 的 key 必须是标量类型；如果解析失败，退化为 string
-					clog.Warnf("[ProtoParser] map key 类型不支持，已退化为 string: %s (field=%s.%s)", keyTypeRaw, currentMessage.Name, fieldName)
-					keyField.Type = TypeString
-				}
-				entryMsg.Fields = append(entryMsg.Fields, keyField)
+// resolveTypeName 按 protobuf 的作用域规则解析类型引用
+// 先从当前作用域由内向外查找（game.Outer.Inner.T -> game.Outer.T -> game.T -> T），
+// 找不到时按名称后缀匹配（如 Hero 或 battle.Hero），存在歧义时视为未找到
+func resolveTypeName[T any](registry map[string]T, scope, typeName string) (string, bool) {
+	typeName = strings.TrimPrefix(typeName, ".")
 
-				// value 字段（tag=2）
-				valueField := &ProtoField{
-					Name:     "value",
-					Tag:      2,
-					Repeated: false,
-				}
-				if pomeloType, ok := GetPomeloType(valueType); ok {
-					valueField.Type = pomeloType
-				} else {
-					valueField.Type = TypeMessage
-					valueField.TypeName = valueType
-				}
-				entryMsg.Fields = append(entryMsg.Fields, valueField)
+	for scope != "" {
+		name := scope + "." + typeName
+		if _, ok := registry[name]; ok {
+			return name, true
+		}
 
-				// 注册 entry message
-				if _, exists := p.messages[entryMsgName]; !exists {
-					p.messages[entryMsgName] = entryMsg
-				}
+		idx := strings.LastIndex(scope, ".")
+		if idx < 0 {
+			break
+		}
+		scope = scope[:idx]
+	}
 
-				// 当前 message 添加 map 字段：在 wire 上表现为 repeated message Entry
-				mapField := &ProtoField{
-					Name:     fieldName,
-					Tag:      tag,
-					Repeated: true,
-					Type:     TypeMessage,
-					TypeName: entryMsgName,
-				}
-				currentMessage.Fields = append(currentMessage.Fields, mapField)
-			} else if matches := fieldRegex.FindStringSubmatch(line); matches != nil {
-				// 解析普通字段
-				repeated := strings.TrimSpace(matches[1]) == "repeated"
-				fieldType := matches[2]
-				fieldName := matches[3]
-				tag, _ := strconv.Atoi(matches[4])
-
-				field := &ProtoField{
-					Name:     fieldName,
-					Tag:      tag,
-					Repeated: repeated,
-				}
+	if _, ok := registry[typeName]; ok {
+		return typeName, true
+	}
 
-				// 判断类型
-				if pomeloType, ok := GetPomeloType(fieldType); ok {
-					field.Type = pomeloType
-				} else {
-					// 自定义消息类型
-					field.Type = TypeMessage
-					field.TypeName = normalizeTypeName(fieldType)
-				}
+	return resolveShortName(registry, typeName)
+}
 
-				currentMessage.Fields = append(currentMessage.Fields, field)
-			}
+// resolveShortName 按名称后缀查找唯一匹配的类型
+func resolveShortName[T any](registry map[string]T, shortName string) (string, bool) {
+	found := ""
+	for name := range registry {
+		if name != shortName && !strings.HasSuffix(name, "."+shortName) {
+			continue
+		}
 
-			// 解析字段
-			// message 结束
-			if braceCount <= 0 {
-				p.messages[currentMessage.Name] = currentMessage
-				currentMessage = nil
-				inMessage = false
-			}
+		if found != "" {
+			return "", false
 		}
+		found = name
 	}
 
-	return scanner.Err()
+	return found, found != ""
 }
 
-// normalizeTypeName 将带包名的类型引用简化为最后一段（例如 foo.bar.Baz -> Baz）
-func normalizeTypeName(t string) string {
-	if strings.Contains(t, ".") {
-		parts := strings.Split(t, ".")
-		return parts[len(parts)-1]
+// lookupMessage 根据限定名称或唯一的短名称查找消息
+func (p *Parser) lookupMessage(name string) (*ProtoMessage, bool) {
+	if name, ok := resolveTypeName(p.messages, "", name); ok {
+		return p.messages[name], true
 	}
-	return t
+	return nil, false
+}
+
+// schemaName 返回类型在 schema 中使用的名称
+// 默认不带包名（与未声明 package 时保持一致），不同包中存在同名类型时使用完整名称
+func (p *Parser) schemaName(fullName string) string {
+	var name string
+	if msg, ok := p.messages[fullName]; ok {
+		name = msg.Name
+	} else if enum, ok := p.enums[fullName]; ok {
+		name = enum.Name
+	} else {
+		return fullName
+	}
+
+	if name == fullName {
+		return name
+	}
+
+	for key, msg := range p.messages {
+		if key != fullName && msg.Name == name {
+			return fullName
+		}
+	}
+
+	for key, enum := range p.enums {
+		if key != fullName && enum.Name == name {
+			return fullName
+		}
+	}
+
+	return name
 }
 
 // buildSchema 构建 Pomelo Schema（标准格式）
 func (p *Parser) buildSchema() *ProtoSchema {
 	schema := &ProtoSchema{
-		Version: 0, // 先设为0，后面根据内容计算
-		Server:  make(map[string]interface{}),
-		Client:  make(map[string]interface{}),
+		Version:        0, // 先设为0，后面根据内容计算
+		Server:         make(map[string]interface{}),
+		Client:         make(map[string]interface{}),
+		ServerMsgNames: make(map[string]string),
+		ClientMsgNames: make(map[string]string),
 	}
 
 	// 构建服务端路由 Schema
-	for route, msgName := range p.options.ServerRoutes {
-		if msg, ok := p.messages[msgName]; ok {
+	for route, msgName := range p.serverRoutes {
+		if msg, ok := p.lookupMessage(msgName); ok {
 			schema.Server[route] = p.buildRouteSchema(msg)
+			schema.ServerMsgNames[route] = msg.FullName()
 		} else {
 			clog.Warnf("[ProtoParser] 服务端路由消息未找到: route=%s, message=%s", route, msgName)
 		}
 	}
 
 	// 构建客户端路由 Schema
-	for route, msgName := range p.options.ClientRoutes {
-		if msg, ok := p.messages[msgName]; ok {
+	for route, msgName := range p.clientRoutes {
+		if msg, ok := p.lookupMessage(msgName); ok {
 			schema.Client[route] = p.buildRouteSchema(msg)
+			schema.ClientMsgNames[route] = msg.FullName()
 		} else {
 			clog.Warnf("[ProtoParser] 客户端路由消息未找到: route=%s, message=%s", route, msgName)
 		}
 	}
 
+	if len(p.streamRoutes) > 0 {
+		schema.StreamRoutes = make(map[string]RouteStream, len(p.streamRoutes))
+		for route, stream := range p.streamRoutes {
+			schema.StreamRoutes[route] = stream
+		}
+	}
+
+	// 收集客户端路由的字段校验约束
+	for route, msgName := range p.clientRoutes {
+		if msg, ok := p.lookupMessage(msgName); ok {
+			if constraints := p.routeConstraints(msg); len(constraints) > 0 {
+				if schema.Constraints == nil {
+					schema.Constraints = make(map[string]map[string]FieldConstraints)
+				}
+				schema.Constraints[route] = constraints
+			}
+		}
+	}
+
 	if p.options.GlobalMessages {
 		globalMessages := make(map[string]interface{})
 		p.collectGlobalMessages(schema.Server, globalMessages)
@@ -287,15 +2082,15 @@ func (p *Parser) buildSchema() *ProtoSchema {
 	if p.options.Version > 0 {
 		schema.Version = p.options.Version
 	} else {
-		schema.Version = p.calculateSchemaVersion(schema)
+		schema.Version = SchemaVersion(schema)
 	}
 
 	return schema
 }
 
-// calculateSchemaVersion 基于 schema 内容计算版本号
+// SchemaVersion 基于 schema 内容计算版本号
 // 使用 CRC32 hash，确保相同内容生成相同版本号
-func (p *Parser) calculateSchemaVersion(schema *ProtoSchema) int {
+func SchemaVersion(schema *ProtoSchema) int {
 	// 创建一个临时结构用于计算 hash（不包含 version 字段）
 	hashData := struct {
 		Server   map[string]interface{} `json:"server"`
@@ -331,13 +2126,42 @@ func (p *Parser) calculateSchemaVersion(schema *ProtoSchema) int {
 //	  "__messages__": {
 //	    "Hero": {
 //	      "optional int32 configId": 1,
-//	      "optional string name": 2
+//	      "optional string name": 2,
+//	      "optional uInt32 quality": 3
 //	    }
+//	  },
+//	  "__enums__": {
+//	    "Quality": {"NORMAL": 0, "RARE": 1}
 //	  }
 //	}
 func (p *Parser) buildRouteSchema(msg *ProtoMessage) map[string]interface{} {
-	result := make(map[string]interface{})
 	nestedMessages := make(map[string]interface{})
+	enums := make(map[string]interface{})
+
+	// visited 记录已进入的嵌套消息，与 nestedMessages 分开维护，相互引用的消息（A -> B -> A）也只展开一次
+	// 路由消息本身不标记，被嵌套消息引用时仍会收集到 __messages__ 中
+	visited := make(map[string]bool)
+
+	result := p.buildMessageSchema(msg, nestedMessages, enums, visited)
+
+	// 如果有嵌套消息，添加 __messages__ 字段
+	if len(nestedMessages) > 0 {
+		result[MessagesKey] = nestedMessages
+	}
+
+	// 如果引用了枚举，添加 __enums__ 字段
+	if len(enums) > 0 {
+		result[EnumsKey] = enums
+	}
+
+	return result
+}
+
+// buildMessageSchema 构建单个消息的字段定义，同时收集引用的嵌套消息和枚举
+// 字段声明了默认值时，添加 __defaults__ 字段
+func (p *Parser) buildMessageSchema(msg *ProtoMessage, nestedMessages, enums map[string]interface{}, visited map[string]bool) map[string]interface{} {
+	result := make(map[string]interface{})
+	defaults := make(map[string]interface{})
 
 	// 按标签号排序字段
 	sortedFields := make([]*ProtoField, len(msg.Fields))
@@ -351,82 +2175,161 @@ func (p *Parser) buildRouteSchema(msg *ProtoMessage) map[string]interface{} {
 		fieldKey := p.buildFieldKey(field)
 		result[fieldKey] = field.Tag
 
+		if value, ok := p.fieldDefault(msg, field); ok {
+			defaults[p.fieldName(field)] = value
+		}
+
 		// 如果是嵌套消息类型，递归收集嵌套消息定义
-		if field.Type == TypeMessage {
-			p.collectNestedMessages(field.TypeName, nestedMessages)
+		switch field.Type {
+		case TypeMessage:
+			p.collectNestedMessages(field.TypeName, nestedMessages, enums, visited)
+		case TypeEnum:
+			p.collectEnum(field.TypeName, enums)
 		}
 	}
 
-	// 如果有嵌套消息，添加 __messages__ 字段
-	if len(nestedMessages) > 0 {
-		result[MessagesKey] = nestedMessages
+	if len(defaults) > 0 {
+		result[DefaultsKey] = defaults
 	}
 
 	return result
 }
 
+// fieldDefault 按字段类型转换 [default = ...] 声明的默认值，枚举默认值转换为枚举数值
+func (p *Parser) fieldDefault(msg *ProtoMessage, field *ProtoField) (interface{}, bool) {
+	raw, found := field.Options["default"]
+	if !found {
+		return nil, false
+	}
+
+	var (
+		value interface{}
+		err   error
+	)
+
+	switch field.Type {
+	case TypeString, TypeBytes:
+		value = raw
+	case TypeBool:
+		value, err = strconv.ParseBool(raw)
+	case TypeFloat, TypeDouble:
+		value, err = strconv.ParseFloat(raw, 64)
+	case TypeInt32, TypeSInt32, TypeInt64, TypeSInt64:
+		value, err = strconv.ParseInt(raw, 0, 64)
+	case TypeUInt32, TypeUInt64:
+		value, err = strconv.ParseUint(raw, 0, 64)
+	case TypeEnum:
+		err = fmt.Errorf("枚举值未找到")
+		if enum, ok := p.enums[field.TypeName]; ok {
+			for _, enumValue := range enum.Values {
+				if enumValue.Name == raw {
+					value, err = enumValue.Value, nil
+					break
+				}
+			}
+		}
+	default:
+		err = fmt.Errorf("类型不支持默认值")
+	}
+
+	if err != nil {
+		clog.Warnf("[ProtoParser] 字段默认值无效: message=%s, field=%s, default=%s, 错误: %v",
+			msg.FullName(), field.Name, raw, err)
+		return nil, false
+	}
+
+	// Int64AsString 时 64 位整数字段按 string 下发，默认值同样使用字符串形式
+	if p.options.Int64AsString && (field.Type == TypeInt64 || field.Type == TypeSInt64 || field.Type == TypeUInt64) {
+		value = fmt.Sprint(value)
+	}
+
+	return value, true
+}
+
 // buildFieldKey 构建字段的 key
-// 格式: "修饰符 类型 字段名"
+// 格式: "修饰符 类型 字段名"，字段名见 fieldName；设置了 Options.FieldKeyFormatter 时优先使用
 func (p *Parser) buildFieldKey(field *ProtoField) string {
+	if p.options.FieldKeyFormatter != nil {
+		if key := p.options.FieldKeyFormatter(field); key != "" {
+			return key
+		}
+	}
+
 	var modifier FieldModifier
 	var typeStr string
 
 	// 确定修饰符
-	if field.Repeated {
+	switch {
+	case field.Repeated:
 		modifier = ModifierRepeated
-	} else {
+	case field.Required:
+		modifier = ModifierRequired
+	default:
 		modifier = ModifierOptional
 	}
 
 	// 确定类型字符串
-	if field.Type == TypeMessage {
+	switch field.Type {
+	case TypeMessage:
 		// 嵌套消息类型使用原始类型名
-		typeStr = "message " + field.TypeName
-	} else {
+		typeStr = "message " + p.schemaName(field.TypeName)
+	case TypeEnum:
+		// pomelo 没有枚举类型，按 varint 编码
+		typeStr = string(TypeUInt32)
+	case TypeInt64, TypeUInt64, TypeSInt64:
+		typeStr = string(field.Type)
+		if p.options.Int64AsString {
+			typeStr = string(TypeString)
+		}
+	default:
 		typeStr = string(field.Type)
 	}
 
-	return string(modifier) + " " + typeStr + " " + field.Name
+	return string(modifier) + " " + typeStr + " " + p.fieldName(field)
 }
 
-// collectNestedMessages 递归收集嵌套消息定义
-func (p *Parser) collectNestedMessages(msgName string, collected map[string]interface{}) {
-	// 避免重复收集
-	if _, exists := collected[msgName]; exists {
+// collectNestedMessages 递归收集嵌套消息定义，以及嵌套消息引用的枚举定义
+// 消息在递归进入前标记到 visited 中，循环引用时不会重复展开
+func (p *Parser) collectNestedMessages(msgName string, collected map[string]interface{}, enums map[string]interface{}, visited map[string]bool) {
+	// 避免重复收集（包括正在构建中的外层消息）
+	if visited[msgName] {
 		return
 	}
+	visited[msgName] = true
 
 	msg, ok := p.messages[msgName]
 	if !ok {
 		return
 	}
 
-	// 构建该消息的 schema
-	msgSchema := make(map[string]interface{})
+	// 构建该消息的 schema，递归收集嵌套消息
+	collected[p.schemaName(msgName)] = p.buildMessageSchema(msg, collected, enums, visited)
+}
 
-	// 按标签号排序字段
-	sortedFields := make([]*ProtoField, len(msg.Fields))
-	copy(sortedFields, msg.Fields)
-	sort.Slice(sortedFields, func(i, j int) bool {
-		return sortedFields[i].Tag < sortedFields[j].Tag
-	})
+// collectEnum 收集枚举定义，格式: {"NAME": value}
+func (p *Parser) collectEnum(enumName string, collected map[string]interface{}) {
+	if _, exists := collected[p.schemaName(enumName)]; exists {
+		return
+	}
 
-	for _, field := range sortedFields {
-		fieldKey := p.buildFieldKey(field)
-		msgSchema[fieldKey] = field.Tag
+	enum, ok := p.enums[enumName]
+	if !ok {
+		return
+	}
 
-		// 递归收集嵌套消息
-		if field.Type == TypeMessage {
-			p.collectNestedMessages(field.TypeName, collected)
-		}
+	values := make(map[string]interface{}, len(enum.Values))
+	for _, v := range enum.Values {
+		values[v.Name] = v.Value
 	}
 
-	collected[msgName] = msgSchema
+	collected[p.schemaName(enumName)] = values
 }
 
+// collectGlobalMessages 将路由中的 __messages__ 移动到全局消息定义中
 func (p *Parser) collectGlobalMessages(routes map[string]interface{}, global map[string]interface{}) {
-	for _, routeSchema := range routes {
-		schemaMap, ok := routeSchema.(map[string]interface{})
+	// 按路由名顺序合并，同名消息冲突时结果保持稳定
+	for _, route := range sortedKeys(routes) {
+		schemaMap, ok := routes[route].(map[string]interface{})
 		if !ok {
 			continue
 		}
@@ -452,7 +2355,41 @@ func (p *Parser) collectGlobalMessages(routes map[string]interface{}, global map
 	}
 }
 
-// GetMessages 获取所有解析的消息
+// GetMessages 获取所有解析的消息，key 为消息全名
+// 返回的是 map 的副本，增删 key 不影响解析器；遍历顺序不固定，需要稳定顺序时使用 SortedMessageNames
 func (p *Parser) GetMessages() map[string]*ProtoMessage {
-	return p.messages
+	messages := make(map[string]*ProtoMessage, len(p.messages))
+	for name, msg := range p.messages {
+		messages[name] = msg
+	}
+	return messages
+}
+
+// GetMessage 根据消息全名或唯一的短名称查找消息
+func (p *Parser) GetMessage(name string) (*ProtoMessage, bool) {
+	return p.lookupMessage(name)
+}
+
+// SortedMessageNames 返回按字典序排列的消息全名，用于生成稳定的输出
+func (p *Parser) SortedMessageNames() []string {
+	return sortedKeys(p.messages)
+}
+
+// GetEnums 获取所有解析的枚举（副本，Reset 不会影响已返回的结果）
+func (p *Parser) GetEnums() map[string]*ProtoEnum {
+	enums := make(map[string]*ProtoEnum, len(p.enums))
+	for name, enum := range p.enums {
+		enums[name] = enum
+	}
+	return enums
+}
+
+// GetFiles 获取 Parse 解析过的磁盘文件列表（含 import 的文件），不包含 ProtoFS 中的文件
+func (p *Parser) GetFiles() []string {
+	return p.files
+}
+
+// GetServices 获取所有解析的 service
+func (p *Parser) GetServices() []*ProtoService {
+	return p.services
 }
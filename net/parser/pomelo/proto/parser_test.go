@@ -0,0 +1,3127 @@
+package pomeloProto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func writeProtoFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func parseOptions(t *testing.T, opts Options) *ProtoSchema {
+	t.Helper()
+
+	schema, err := NewParser(opts).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if schema == nil {
+		t.Fatal("schema is nil")
+	}
+
+	return schema
+}
+
+const heroProto = `
+syntax = "proto3";
+
+message Hero {
+    int32 configId = 1;
+    string name = 2;
+}
+
+message HeroListResponse {
+    uint32 code = 1;
+    repeated Hero heroes = 2;
+}
+`
+
+func TestParseAutoVersionStable(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+
+	first := parseOptions(t, opts)
+	second := parseOptions(t, opts)
+
+	if first.Version <= 0 {
+		t.Fatalf("version = %d, want > 0", first.Version)
+	}
+
+	if first.Version != second.Version {
+		t.Fatalf("version not stable. first = %d, second = %d", first.Version, second.Version)
+	}
+}
+
+func TestParseAutoVersionChangedByTag(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+
+	before := parseOptions(t, opts)
+
+	writeProtoFile(t, dir, "hero.proto", `
+message Hero {
+    int32 configId = 1;
+    string name = 3;
+}
+
+message HeroListResponse {
+    uint32 code = 1;
+    repeated Hero heroes = 2;
+}
+`)
+
+	after := parseOptions(t, opts)
+	if before.Version == after.Version {
+		t.Fatalf("version should change after tag changed. version = %d", before.Version)
+	}
+}
+
+func TestParseManualVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.Version = 7
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+
+	schema := parseOptions(t, opts)
+	if schema.Version != 7 {
+		t.Fatalf("version = %d, want 7", schema.Version)
+	}
+}
+
+func TestParseEnum(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "item.proto", `
+enum Quality {
+    NORMAL = 1;
+    RARE = 2;
+}
+
+message ItemResponse {
+    enum Status {
+        option allow_alias = true;
+        OK = 0;
+        SUCCESS = 0;
+        FAIL = -1;
+    }
+
+    Quality quality = 1;
+    Status status = 2;
+    int32 count = 3;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.itemHandler.info"] = "ItemResponse"
+
+	schema := parseOptions(t, opts)
+
+	route, ok := schema.Server["game.itemHandler.info"].(map[string]interface{})
+	if !ok {
+		t.Fatal("route schema not found")
+	}
+
+	for _, key := range []string{"optional uInt32 quality", "optional uInt32 status", "optional int32 count"} {
+		if _, found := route[key]; !found {
+			t.Fatalf("field key %q not found in %v", key, route)
+		}
+	}
+
+	if _, found := route[MessagesKey]; found {
+		t.Fatalf("enum should not be emitted as message. route = %v", route)
+	}
+
+	enums, ok := route[EnumsKey].(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s not found in %v", EnumsKey, route)
+	}
+
+	quality, ok := enums["Quality"].(map[string]interface{})
+	if !ok || quality["NORMAL"] != 1 || quality["RARE"] != 2 {
+		t.Fatalf("Quality enum = %v", enums["Quality"])
+	}
+
+	status, ok := enums["ItemResponse.Status"].(map[string]interface{})
+	if !ok || status["OK"] != 0 || status["SUCCESS"] != 0 || status["FAIL"] != -1 {
+		t.Fatalf("ItemResponse.Status enum = %v", enums["ItemResponse.Status"])
+	}
+}
+
+func TestParseNegativeEnumStrict(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	parser := NewParser(opts)
+	err := parser.ParseString("item.proto", `
+enum Status {
+    OK = 0;
+    FAIL = -1; }
+`)
+	if err == nil || !strings.Contains(err.Error(), "枚举值不能为负数") || !strings.Contains(err.Error(), "FAIL") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestParseRepeatedEnum(t *testing.T) {
+	for _, global := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.GlobalMessages = global
+		opts.ServerRoutes["game.paintHandler.info"] = "Paint"
+		parser := NewParser(opts)
+		err := parser.ParseString("paint.proto", `
+syntax = "proto3";
+package game;
+
+enum Color {
+    RED = 0;
+    BLUE = 1;
+}
+
+message Brush {
+    repeated Color colors = 1;
+}
+
+message Paint {
+    enum Shade {
+        LIGHT = 0;
+        DARK = 1;
+    }
+
+    repeated Color colors = 4;
+    repeated Shade shades = 5;
+    repeated .game.Color qualified = 6;
+    repeated Brush brushes = 7;
+}
+`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		schema, err := parser.BuildSchema()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		paint, _ := parser.GetMessage("game.Paint")
+		for _, field := range paint.Fields[:3] {
+			if field.Type != TypeEnum || !field.Repeated || !field.Packed {
+				t.Fatalf("global=%v, field = %+v", global, field)
+			}
+		}
+
+		route := schema.Server["game.paintHandler.info"].(map[string]interface{})
+		for _, key := range []string{"repeated uInt32 colors", "repeated uInt32 shades", "repeated uInt32 qualified", "repeated message Brush brushes"} {
+			if _, found := route[key]; !found {
+				t.Fatalf("global=%v, field key %q not found in %v", global, key, route)
+			}
+		}
+
+		// 只有 Brush 是嵌套消息，枚举不出现在 __messages__ 中
+		messages, _ := route[MessagesKey].(map[string]interface{})
+		if global {
+			messages = schema.Messages
+		}
+		if len(messages) != 1 || messages["Brush"] == nil {
+			t.Fatalf("global=%v, messages = %v", global, messages)
+		}
+		brush := messages["Brush"].(map[string]interface{})
+		if _, found := brush["repeated uInt32 colors"]; !found {
+			t.Fatalf("global=%v, brush = %v", global, brush)
+		}
+
+		// 重复的枚举按 packed varint 编解码
+		value := map[string]interface{}{"colors": []int{1, 0}, "shades": []int{1}}
+		data, err := schema.Encode("game.paintHandler.info", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := schema.Decode("game.paintHandler.info", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{
+			"colors": []interface{}{uint32(1), uint32(0)},
+			"shades": []interface{}{uint32(1)},
+		}
+		if !reflect.DeepEqual(decoded, want) {
+			t.Fatalf("global=%v, decoded = %v", global, decoded)
+		}
+	}
+}
+
+func TestParseNestedMessage(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "nested.proto", `
+message Outer {
+    message Inner {
+        message Deep {
+            int32 value = 1;
+        }
+
+        string name = 1;
+        Deep deep = 2;
+    }
+
+    int32 id = 1;
+    Inner inner = 2;
+}
+
+message Other {
+    message Inner {
+        bool flag = 1;
+    }
+
+    Inner inner = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.outerHandler.get"] = "Outer"
+	opts.ServerRoutes["game.otherHandler.get"] = "Other"
+	opts.ServerRoutes["game.deepHandler.get"] = "Deep"
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Outer", "Outer.Inner", "Outer.Inner.Deep", "Other", "Other.Inner"} {
+		if _, found := parser.GetMessages()[name]; !found {
+			t.Fatalf("message %s not registered", name)
+		}
+	}
+
+	outer := schema.Server["game.outerHandler.get"].(map[string]interface{})
+	if outer["optional int32 id"] != 1 || outer["optional message Outer.Inner inner"] != 2 {
+		t.Fatalf("outer schema = %v", outer)
+	}
+
+	messages := outer[MessagesKey].(map[string]interface{})
+	inner, ok := messages["Outer.Inner"].(map[string]interface{})
+	if !ok || inner["optional message Outer.Inner.Deep deep"] != 2 {
+		t.Fatalf("Outer.Inner schema = %v", messages["Outer.Inner"])
+	}
+
+	if _, ok := messages["Outer.Inner.Deep"]; !ok {
+		t.Fatalf("Outer.Inner.Deep not collected. messages = %v", messages)
+	}
+
+	other := schema.Server["game.otherHandler.get"].(map[string]interface{})
+	if other["optional message Other.Inner inner"] != 1 {
+		t.Fatalf("other schema = %v", other)
+	}
+
+	deep, ok := schema.Server["game.deepHandler.get"].(map[string]interface{})
+	if !ok || deep["optional int32 value"] != 1 {
+		t.Fatalf("route by unambiguous short name not resolved. deep = %v", deep)
+	}
+}
+
+func TestParseForwardReference(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "a_bag.proto", `
+syntax = "proto3";
+
+message BagResponse {
+    repeated Item items = 1;
+    repeated Reward rewards = 2;
+    Quality best = 3;
+}
+
+message Item {
+    int32 id = 1;
+    repeated Reward rewards = 2;
+}
+
+enum Quality {
+    NORMAL = 0;
+    RARE = 1;
+}
+`)
+	writeProtoFile(t, dir, "b_reward.proto", `
+syntax = "proto3";
+
+message Reward {
+    int32 itemId = 1;
+    Quality quality = 2;
+}
+`)
+
+	// 并发解析时文件的解析顺序不确定，引用在所有文件解析完成后统一处理
+	for _, concurrency := range []int{1, 4} {
+		opts := DefaultOptions()
+		opts.ProtoDir = dir
+		opts.StrictMode = true
+		opts.ParseConcurrency = concurrency
+		opts.ServerRoutes["game.bag.list"] = "BagResponse"
+
+		schema := parseOptions(t, opts)
+
+		route := schema.Server["game.bag.list"].(map[string]interface{})
+		for key, tag := range map[string]int{
+			"repeated message Item items":     1,
+			"repeated message Reward rewards": 2,
+			"optional uInt32 best":            3,
+		} {
+			if route[key] != tag {
+				t.Fatalf("route[%q] = %v, schema = %v", key, route[key], route)
+			}
+		}
+
+		messages := route[MessagesKey].(map[string]interface{})
+		item, _ := messages["Item"].(map[string]interface{})
+		reward, _ := messages["Reward"].(map[string]interface{})
+		if item["repeated message Reward rewards"] != 2 || reward["optional uInt32 quality"] != 2 {
+			t.Fatalf("messages = %v", messages)
+		}
+		if enums, _ := route[EnumsKey].(map[string]interface{}); enums["Quality"] == nil {
+			t.Fatalf("enums = %v", route[EnumsKey])
+		}
+	}
+}
+
+func TestParseQualifiedNestedReference(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "qualified.proto", `
+package game;
+
+message Outer {
+    message Inner {
+        message Deep {
+            int32 value = 1;
+        }
+
+        string name = 1;
+    }
+}
+
+message QualifiedResponse {
+    Outer.Inner inner = 1;
+    repeated Outer.Inner.Deep deeps = 2;
+    .game.Outer.Inner absolute = 3;
+    string title = 4;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.ServerRoutes["game.handler.qualified"] = "QualifiedResponse"
+
+	schema := parseOptions(t, opts)
+
+	resp := schema.Server["game.handler.qualified"].(map[string]interface{})
+	for key, tag := range map[string]int{
+		"optional message Outer.Inner inner":      1,
+		"repeated message Outer.Inner.Deep deeps": 2,
+		"optional message Outer.Inner absolute":   3,
+		"optional string title":                   4,
+	} {
+		if resp[key] != tag {
+			t.Fatalf("%q = %v, want %d. schema = %v", key, resp[key], tag, resp)
+		}
+	}
+
+	messages := resp[MessagesKey].(map[string]interface{})
+	for _, name := range []string{"Outer.Inner", "Outer.Inner.Deep"} {
+		if _, found := messages[name]; !found {
+			t.Fatalf("%s not collected. messages = %v", name, messages)
+		}
+	}
+}
+
+func TestParserReset(t *testing.T) {
+	dir := t.TempDir()
+	heroFile := writeProtoFile(t, dir, "hero.proto", `
+syntax = "proto3";
+
+enum Quality {
+    NORMAL = 0;
+}
+
+message Hero {
+    int32 id = 1;
+    Quality quality = 2;
+}
+
+service HeroHandler {
+    rpc Info (Hero) returns (Hero);
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.AutoRouteFromService = true
+
+	parser := NewParser(opts)
+	first, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := first.Server["heroHandler.info"]; !found {
+		t.Fatalf("schema = %v", first.Server)
+	}
+	messages := parser.GetMessages()
+	enums := parser.GetEnums()
+
+	// 文件变化后复用解析器重新解析
+	if err := os.Remove(heroFile); err != nil {
+		t.Fatal(err)
+	}
+	writeProtoFile(t, dir, "item.proto", `
+syntax = "proto3";
+
+message Item {
+    int32 id = 1;
+}
+`)
+
+	parser.Reset()
+	second, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := parser.SortedMessageNames(); !reflect.DeepEqual(names, []string{"Item"}) {
+		t.Fatalf("messages = %v, stale messages should be cleared", names)
+	}
+	if len(parser.GetEnums()) != 0 || len(parser.GetServices()) != 0 {
+		t.Fatalf("enums = %v, services = %v", parser.GetEnums(), parser.GetServices())
+	}
+	if files := parser.GetFiles(); len(files) != 1 || filepath.Base(files[0]) != "item.proto" {
+		t.Fatalf("files = %v", files)
+	}
+	if len(second.Server) != 0 {
+		t.Fatalf("routes from removed service should be cleared, schema = %v", second.Server)
+	}
+
+	// 之前获取的结果不受影响
+	if messages["Hero"] == nil || enums["Quality"] == nil {
+		t.Fatalf("previous results changed, messages = %v, enums = %v", messages, enums)
+	}
+}
+
+func TestParserReparseFile(t *testing.T) {
+	dir := t.TempDir()
+	heroFile := writeProtoFile(t, dir, "hero.proto", `
+syntax = "proto3";
+
+message Hero {
+    int32 id = 1;
+    repeated Kind kinds = 2;
+    Item item = 3;
+}
+
+service HeroHandler {
+    rpc Info (Hero) returns (Hero);
+}
+`)
+	itemFile := writeProtoFile(t, dir, "item.proto", `
+syntax = "proto3";
+
+enum Kind {
+    NONE = 0;
+}
+
+message Item {
+    int32 id = 1;
+}
+
+service ItemHandler {
+    rpc Info (Item) returns (Item);
+}
+`)
+	writeProtoFile(t, dir, "zone.proto", `
+syntax = "proto3";
+
+message Zone {
+    int32 id = 1;
+}
+
+service ZoneHandler {
+    rpc Info (Zone) returns (Zone);
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.AutoRouteFromService = true
+
+	parser := NewParser(opts)
+	if _, err := parser.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	hero, _ := parser.GetMessage("Hero")
+	if hero.SourceFile != heroFile {
+		t.Fatalf("source file = %s, want %s", hero.SourceFile, heroFile)
+	}
+
+	// Kind 由枚举改为消息，Item 增加字段，新增 Gem
+	writeProtoFile(t, dir, "item.proto", `
+syntax = "proto3";
+
+message Kind {
+    string name = 1;
+}
+
+message Item {
+    int32 id = 1;
+    repeated Gem gems = 2;
+}
+
+message Gem {
+    int32 id = 1;
+}
+
+service ItemHandler {
+    rpc Info (Item) returns (Item);
+}
+`)
+
+	if err := parser.ReparseFile(itemFile); err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := parser.SortedMessageNames(); !reflect.DeepEqual(names, []string{"Gem", "Hero", "Item", "Kind", "Zone"}) {
+		t.Fatalf("messages = %v", names)
+	}
+	if len(parser.GetEnums()) != 0 {
+		t.Fatalf("enums = %v", parser.GetEnums())
+	}
+	if unchanged, _ := parser.GetMessage("Hero"); unchanged != hero {
+		t.Fatal("messages from other files should not be reparsed")
+	}
+	if item, _ := parser.GetMessage("Item"); len(item.Fields) != 2 || item.SourceFile != itemFile {
+		t.Fatalf("item = %+v", item)
+	}
+
+	var services []string
+	for _, service := range parser.GetServices() {
+		services = append(services, service.Name)
+	}
+	if !reflect.DeepEqual(services, []string{"HeroHandler", "ItemHandler", "ZoneHandler"}) {
+		t.Fatalf("services = %v", services)
+	}
+	if len(parser.GetFiles()) != 3 {
+		t.Fatalf("files = %v", parser.GetFiles())
+	}
+
+	want, err := NewParser(opts).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("schema = %v, want %v", got, want)
+	}
+
+	// 解析失败时保留原有的结果
+	writeProtoFile(t, dir, "item.proto", "message Broken {\n    int32 id = 1;\n    int32 dup = 1;\n}\n")
+	if err := parser.ReparseFile(itemFile); err == nil {
+		t.Fatal("invalid file should return error in strict mode")
+	}
+	if _, found := parser.GetMessage("Gem"); !found {
+		t.Fatal("previous results should be kept")
+	}
+}
+
+func TestParserGetMessage(t *testing.T) {
+	parser := NewParser(DefaultOptions())
+	if err := parser.ParseString("game.proto", `
+package game;
+
+message Zebra { int32 id = 1; }
+message Apple { message Seed { int32 id = 1; } }
+message Mango { int32 id = 1; }
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"game.Apple", "game.Apple.Seed", "game.Mango", "game.Zebra"}
+	if names := parser.SortedMessageNames(); !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+
+	for _, name := range []string{"game.Apple.Seed", "Apple.Seed"} {
+		if msg, found := parser.GetMessage(name); !found || msg.FullName() != "game.Apple.Seed" {
+			t.Fatalf("GetMessage(%q) = %v, %v", name, msg, found)
+		}
+	}
+	if _, found := parser.GetMessage("Banana"); found {
+		t.Fatal("GetMessage should not find undefined message")
+	}
+
+	messages := parser.GetMessages()
+	delete(messages, "game.Zebra")
+	messages["game.Banana"] = &ProtoMessage{Name: "Banana", Package: "game"}
+
+	if _, found := parser.GetMessage("game.Zebra"); !found {
+		t.Fatal("deleting from GetMessages result should not affect parser")
+	}
+	if _, found := parser.GetMessage("game.Banana"); found {
+		t.Fatal("adding to GetMessages result should not affect parser")
+	}
+}
+
+func TestParseMapField(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "map.proto", `
+message Hero {
+    int32 configId = 1;
+}
+
+message BagResponse {
+    map<string, int32> scores = 1;
+    map<int64, Hero> heroes = 2;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.bagHandler.list"] = "BagResponse"
+
+	schema := parseOptions(t, opts)
+	route := schema.Server["game.bagHandler.list"].(map[string]interface{})
+
+	if route["repeated message BagResponse_scoresEntry scores"] != 1 ||
+		route["repeated message BagResponse_heroesEntry heroes"] != 2 {
+		t.Fatalf("map fields = %v", route)
+	}
+
+	messages := route[MessagesKey].(map[string]interface{})
+
+	scores, ok := messages["BagResponse_scoresEntry"].(map[string]interface{})
+	if !ok || scores["optional string key"] != 1 || scores["optional int32 value"] != 2 {
+		t.Fatalf("scores entry = %v", messages["BagResponse_scoresEntry"])
+	}
+
+	heroes, ok := messages["BagResponse_heroesEntry"].(map[string]interface{})
+	if !ok || heroes["optional int64 key"] != 1 || heroes["optional message Hero value"] != 2 {
+		t.Fatalf("heroes entry = %v", messages["BagResponse_heroesEntry"])
+	}
+
+	if _, ok := messages["Hero"]; !ok {
+		t.Fatalf("map value message not collected. messages = %v", messages)
+	}
+}
+
+func TestParseMapFieldOptions(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	opts.ServerRoutes["game.bagHandler.list"] = "BagResponse"
+	parser := NewParser(opts)
+	err := parser.ParseString("map.proto", `
+message BagResponse {
+    map<string, int32> attrs = 3 [deprecated = true];
+    map<string, int32> item_counts = 4 [json_name = "itemCounts"];
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := parser.GetMessage("BagResponse")
+	if len(msg.Fields) != 2 || !msg.Fields[0].Deprecated() {
+		t.Fatalf("fields = %+v", msg.Fields)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := schema.Server["game.bagHandler.list"].(map[string]interface{})
+	if route["repeated message BagResponse_attrsEntry attrs"] != 3 ||
+		route["repeated message BagResponse_item_countsEntry itemCounts"] != 4 {
+		t.Fatalf("map fields = %v", route)
+	}
+	if deprecated := parser.DeprecatedFields(); len(deprecated) != 1 || !strings.Contains(deprecated[0], "attrs") {
+		t.Fatalf("deprecated fields = %v", deprecated)
+	}
+}
+
+func TestParseMapFieldInvalidKey(t *testing.T) {
+	parser := NewParser(DefaultOptions())
+	msg := &ProtoMessage{Name: "Stats"}
+	parser.parseMapField(msg, []string{"", "double", "Unknown", "values", "1", ""}, 1)
+
+	entry, ok := parser.GetMessages()["Stats_valuesEntry"]
+	if !ok || !entry.MapEntry {
+		t.Fatal("map entry not registered")
+	}
+
+	if entry.Fields[0].Type != TypeString {
+		t.Fatalf("invalid map key should fall back to string. type = %s", entry.Fields[0].Type)
+	}
+
+	parser.resolveFieldTypes()
+	if entry.Fields[1].Type != TypeMessage || entry.Fields[1].TypeName != "Unknown" {
+		t.Fatalf("value field = %+v", entry.Fields[1])
+	}
+}
+
+func TestParseOneof(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "oneof.proto", `
+message RewardResponse {
+    int32 code = 1;
+    oneof reward {
+        int32 gold = 2;
+        string item = 3;
+        Hero hero = 4;
+    }
+    repeated int32 ids = 5;
+}
+
+message Hero {
+    int32 configId = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.rewardHandler.get"] = "RewardResponse"
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, ok := parser.GetMessages()["RewardResponse"]
+	if !ok || len(msg.Fields) != 5 {
+		t.Fatalf("RewardResponse = %+v", msg)
+	}
+
+	for _, field := range msg.Fields {
+		inOneof := field.Tag >= 2 && field.Tag <= 4
+		if inOneof && field.OneofName != "reward" {
+			t.Fatalf("field %s oneof = %q, want reward", field.Name, field.OneofName)
+		}
+		if !inOneof && field.OneofName != "" {
+			t.Fatalf("field %s oneof = %q, want empty", field.Name, field.OneofName)
+		}
+	}
+
+	route := schema.Server["game.rewardHandler.get"].(map[string]interface{})
+	for key, tag := range map[string]int{
+		"optional int32 code":        1,
+		"optional int32 gold":        2,
+		"optional string item":       3,
+		"optional message Hero hero": 4,
+		"repeated int32 ids":         5,
+	} {
+		if route[key] != tag {
+			t.Fatalf("field %q = %v, want %d. route = %v", key, route[key], tag, route)
+		}
+	}
+}
+
+func TestParseBraceOnOwnLine(t *testing.T) {
+	inline := `
+message Outer {
+    message Inner {
+        int32 id = 1;
+    }
+    enum State {
+        IDLE = 0;
+    }
+    oneof value {
+        Inner inner = 1;
+        State state = 2;
+    }
+    int32 code = 3;
+}
+
+message After {
+    int32 id = 1;
+}
+
+service Hero {
+    rpc Info (Outer) returns (After);
+}
+`
+	ownLine := `
+message Outer
+{
+    message Inner
+    {
+        int32 id = 1;
+    }
+    enum State
+    {
+        IDLE = 0;
+    }
+    oneof value
+    {
+        Inner inner = 1;
+        State state = 2;
+    }
+    int32 code = 3;
+}
+
+message After
+{
+    int32 id = 1;
+}
+
+service Hero
+{
+    rpc Info (Outer) returns (After);
+}
+`
+
+	parse := func(content string) *Parser {
+		opts := DefaultOptions()
+		opts.StrictMode = true
+		parser := NewParser(opts)
+		if err := parser.ParseString("brace.proto", content); err != nil {
+			t.Fatal(err)
+		}
+		return parser
+	}
+
+	want := parse(inline)
+	got := parse(ownLine)
+
+	if names := got.SortedMessageNames(); !reflect.DeepEqual(names, []string{"After", "Outer", "Outer.Inner"}) {
+		t.Fatalf("messages = %v", names)
+	}
+	for _, name := range want.SortedMessageNames() {
+		wantMsg, _ := want.GetMessage(name)
+		gotMsg, _ := got.GetMessage(name)
+		if !reflect.DeepEqual(gotMsg, wantMsg) {
+			t.Fatalf("message %s = %+v, want %+v", name, gotMsg, wantMsg)
+		}
+	}
+	if !reflect.DeepEqual(got.GetEnums(), want.GetEnums()) || len(got.GetEnums()) != 1 {
+		t.Fatalf("enums = %v", got.GetEnums())
+	}
+	if !reflect.DeepEqual(got.GetServices(), want.GetServices()) || len(got.GetServices()) != 1 {
+		t.Fatalf("services = %v", got.GetServices())
+	}
+}
+
+func TestParseWrappedField(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	parser := NewParser(opts)
+	err := parser.ParseString("wrapped.proto", `
+message SomeVeryLongMessageName {
+    int32 id = 1;
+}
+
+message Bag {
+    // wrapped items
+    repeated SomeVeryLongMessageName
+        items =
+        12;
+    int32 count = 13 [
+        default = 1
+    ];
+    message Inner {
+        int32 id = 1;
+    }
+}
+
+message After {
+    int32 id = 1;
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := parser.SortedMessageNames(); !reflect.DeepEqual(names, []string{"After", "Bag", "Bag.Inner", "SomeVeryLongMessageName"}) {
+		t.Fatalf("messages = %v", names)
+	}
+
+	bag, _ := parser.GetMessage("Bag")
+	if len(bag.Fields) != 2 {
+		t.Fatalf("fields = %d, want 2", len(bag.Fields))
+	}
+
+	items := bag.Fields[0]
+	if items.Name != "items" || items.Tag != 12 || !items.Repeated ||
+		items.Type != TypeMessage || items.TypeName != "SomeVeryLongMessageName" || items.Doc != "wrapped items" {
+		t.Fatalf("items = %+v", items)
+	}
+
+	if count := bag.Fields[1]; count.Name != "count" || count.Tag != 13 || count.Options["default"] != "1" {
+		t.Fatalf("count = %+v", count)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	parser := NewParser(opts)
+	err := parser.ParseString("option.proto", `
+syntax = "proto3";
+
+option java_package = "com.example.game";
+option (file_meta) = { owner: "game" };
+
+enum Color {
+    option allow_alias = true;
+    option (enum_meta) = {
+        label: "}"
+    };
+    RED = 0;
+    CRIMSON = 0;
+}
+
+message Hero {
+    option deprecated = true;
+    option (ui.form) = {
+        title: "hero"
+        layout: {
+            columns: 2
+            rows: [1, 2]
+        }
+        int32 fake = 99;
+    };
+    int32 id = 1;
+    message Inner {
+        option (inner) = { a: 1 b: { c: "{" } };
+        string name = 1;
+    }
+    Inner inner = 2;
+    optional int32 level = 3;
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := parser.SortedMessageNames(); !reflect.DeepEqual(names, []string{"Hero", "Hero.Inner"}) {
+		t.Fatalf("messages = %v", names)
+	}
+
+	hero, _ := parser.GetMessage("Hero")
+	if len(hero.Fields) != 3 || hero.Fields[0].Name != "id" || hero.Fields[1].Name != "inner" || hero.Fields[2].Name != "level" {
+		t.Fatalf("hero fields = %+v", hero.Fields)
+	}
+
+	inner, _ := parser.GetMessage("Hero.Inner")
+	if len(inner.Fields) != 1 || inner.Fields[0].Name != "name" {
+		t.Fatalf("inner fields = %+v", inner.Fields)
+	}
+
+	color, ok := parser.GetEnums()["Color"]
+	if !ok || len(color.Values) != 2 {
+		t.Fatalf("color = %+v", color)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "comment.proto", `
+/*
+ * message Fake {
+ *     int32 fake = 1;
+ * }
+ */
+message PlayerResponse { // player info {
+    int32 id = 1; // player id }
+    /* block comment with braces {
+       int32 ignored = 9;
+    } */
+    string name = 2; /* trailing */
+    int32 /* inline */ level = 3;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.playerHandler.info"] = "PlayerResponse"
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := parser.GetMessages()["Fake"]; found {
+		t.Fatal("message in block comment should be ignored")
+	}
+
+	route := schema.Server["game.playerHandler.info"].(map[string]interface{})
+	if len(route) != 3 ||
+		route["optional int32 id"] != 1 ||
+		route["optional string name"] != 2 ||
+		route["optional int32 level"] != 3 {
+		t.Fatalf("route = %v", route)
+	}
+}
+
+func TestSplitComment(t *testing.T) {
+	tests := []struct {
+		line        string
+		inComment   bool
+		want        string
+		wantComment string
+		wantIn      bool
+	}{
+		{`int32 hp = 1; // health`, false, `int32 hp = 1; `, `health`, false},
+		{`string url = 1 [default = "http://a/*b*/"]; // url`, false, `string url = 1 [default = "http://a/*b*/"]; `, `url`, false},
+		{`int32 a = 1; /* begin`, false, `int32 a = 1; `, ``, true},
+		{`still comment */ int32 b = 2;`, true, ` int32 b = 2;`, ``, false},
+		{`string s = 1 [default = 'it\'s // ok'];`, false, `string s = 1 [default = 'it\'s // ok'];`, ``, false},
+	}
+
+	for _, tt := range tests {
+		got, gotComment, gotIn := splitComment(tt.line, tt.inComment)
+		if got != tt.want || gotComment != tt.wantComment || gotIn != tt.wantIn {
+			t.Fatalf("splitComment(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.line, got, gotComment, gotIn, tt.want, tt.wantComment, tt.wantIn)
+		}
+	}
+}
+
+const duplicateTagProto = `
+message DuplicateResponse {
+    int32 a = 1;
+    int32 b = 1;
+}
+`
+
+func TestParseDuplicateTagStrict(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "duplicate.proto", duplicateTagProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.ServerRoutes["game.handler.duplicate"] = "DuplicateResponse"
+
+	_, err := NewParser(opts).Parse()
+	if err == nil {
+		t.Fatal("duplicate tag should return error in strict mode")
+	}
+
+	for _, s := range []string{"duplicate.proto", "DuplicateResponse", "a", "b"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("error %q should contain %q", err, s)
+		}
+	}
+}
+
+func TestParseDuplicateTagLenient(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "duplicate.proto", duplicateTagProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.handler.duplicate"] = "DuplicateResponse"
+
+	schema := parseOptions(t, opts)
+	if _, found := schema.Server["game.handler.duplicate"]; !found {
+		t.Fatal("lenient mode should keep the message")
+	}
+}
+
+const unresolvedProto = `
+message Hero {
+    int32 configId = 1;
+    Skil skill = 2;
+}
+
+message HeroResponse {
+    Hero hero = 1;
+    Equip equip = 2;
+}
+`
+
+func TestParseUnresolvedTypeStrict(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", unresolvedProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.ServerRoutes["game.heroHandler.get"] = "HeroResponse"
+
+	_, err := NewParser(opts).Parse()
+	if err == nil {
+		t.Fatal("unresolved type should return error in strict mode")
+	}
+
+	for _, s := range []string{"Hero.skill(type=Skil)", "HeroResponse.equip(type=Equip)"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("error %q should contain %q", err, s)
+		}
+	}
+}
+
+func TestParseUnresolvedTypeLenient(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", unresolvedProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.heroHandler.get"] = "HeroResponse"
+
+	schema := parseOptions(t, opts)
+	if _, found := schema.Server["game.heroHandler.get"]; !found {
+		t.Fatal("lenient mode should keep the route")
+	}
+}
+
+func TestParseNoProtoFiles(t *testing.T) {
+	// 未配置 proto 时不返回错误
+	if schema, err := NewParser(DefaultOptions()).Parse(); schema != nil || err != nil {
+		t.Fatalf("schema = %v, err = %v", schema, err)
+	}
+
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "readme.txt", "not a proto file")
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+
+	schema, err := NewParser(opts).Parse()
+	if schema != nil || !errors.Is(err, ErrNoProtoFiles) {
+		t.Fatalf("schema = %v, err = %v, want ErrNoProtoFiles", schema, err)
+	}
+}
+
+func TestParseFailOnUnknownTypes(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", `
+message HeroResponse {
+    int32 code = 1;
+}
+
+message Unrouted {
+    int23 level = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.heroHandler.get"] = "HeroResponse"
+
+	// 未开启时按未定义的消息类型处理
+	parser := NewParser(opts)
+	if _, err := parser.Parse(); err != nil {
+		t.Fatalf("unknown type should be tolerated by default, got %v", err)
+	}
+	msg, _ := parser.GetMessage("Unrouted")
+	if field := msg.Fields[0]; field.Type != TypeMessage || field.TypeName != "int23" {
+		t.Fatalf("field = %+v", field)
+	}
+
+	opts.FailOnUnknownTypes = true
+	_, err := NewParser(opts).Parse()
+	if err == nil || !strings.Contains(err.Error(), "Unrouted.level(type=int23)") {
+		t.Fatalf("unknown type should return error, got %v", err)
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared")
+
+	entry := writeProtoFile(t, dir, "api/entry.proto", `
+import "common.proto";
+
+message EntryResponse {
+    int32 code = 1;
+    Player player = 2;
+}
+`)
+	writeProtoFile(t, dir, "api/common.proto", `
+import public "player.proto";
+import "entry.proto";
+`)
+	writeProtoFile(t, shared, "player.proto", `
+message Player {
+    int64 uid = 1;
+    string name = 2;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoFiles = []string{entry}
+	opts.ImportPaths = []string{shared}
+	opts.StrictMode = true
+	opts.ServerRoutes["connector.entryHandler.entry"] = "EntryResponse"
+
+	schema := parseOptions(t, opts)
+	route := schema.Server["connector.entryHandler.entry"].(map[string]interface{})
+
+	messages, ok := route[MessagesKey].(map[string]interface{})
+	if !ok {
+		t.Fatalf("route = %v", route)
+	}
+
+	if _, found := messages["Player"]; !found {
+		t.Fatalf("imported message not resolved. messages = %v", messages)
+	}
+}
+
+func TestParseImportNotFound(t *testing.T) {
+	dir := t.TempDir()
+	entry := writeProtoFile(t, dir, "entry.proto", `
+import "missing.proto";
+
+message EntryResponse {
+    int32 code = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoFiles = []string{entry}
+	opts.StrictMode = true
+
+	_, err := NewParser(opts).Parse()
+	if err == nil {
+		t.Fatal("missing import should return error in strict mode")
+	}
+
+	if !strings.Contains(err.Error(), "missing.proto") || !strings.Contains(err.Error(), "entry.proto") {
+		t.Fatalf("error %q should contain import and file name", err)
+	}
+
+	opts.StrictMode = false
+	if _, err := NewParser(opts).Parse(); err != nil {
+		t.Fatalf("missing import should only warn in lenient mode. err = %v", err)
+	}
+}
+
+func TestParseProtoDirs(t *testing.T) {
+	root := t.TempDir()
+	apiDir := filepath.Join(root, "api")
+	sharedDir := filepath.Join(root, "shared")
+	writeProtoFile(t, apiDir, "hero.proto", `
+message HeroInfoResponse {
+    Hero hero = 1;
+}
+`)
+	writeProtoFile(t, sharedDir, "common/hero.proto", `
+message Hero {
+    int32 id = 1;
+}
+
+message ItemListResponse {
+    repeated int32 ids = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDirs = []string{apiDir, sharedDir}
+	opts.ProtoDir = root // 与 ProtoDirs 重叠的文件只解析一次
+	opts.StrictMode = true
+	opts.StrictRoutes = true
+	opts.ServerRoutes["game.hero.info"] = "HeroInfoResponse"
+	opts.ServerRoutes["game.item.list"] = "ItemListResponse"
+
+	if only := (Options{ProtoDirs: []string{apiDir}}); !only.HasProtoConfig() {
+		t.Fatal("ProtoDirs should count as proto config")
+	}
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files := parser.GetFiles(); len(files) != 2 {
+		t.Fatalf("files = %v, want 2", files)
+	}
+
+	hero := schema.Server["game.hero.info"].(map[string]interface{})[MessagesKey].(map[string]interface{})["Hero"]
+	if hero == nil {
+		t.Fatalf("schema = %v", schema.Server)
+	}
+	if _, found := schema.Server["game.item.list"]; !found {
+		t.Fatalf("schema = %v", schema.Server)
+	}
+}
+
+func TestParsePackage(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "battle.proto", `
+syntax = "proto3";
+package game.battle;
+
+message Hero {
+    int32 hp = 1;
+}
+
+message BattleResponse {
+    Hero hero = 1;
+    Skill skill = 2;
+}
+
+message Skill {
+    int32 id = 1;
+}
+`)
+	writeProtoFile(t, dir, "hall.proto", `
+syntax = "proto3";
+package game.hall;
+
+message Hero {
+    string name = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.ServerRoutes["battle.heroHandler.get"] = "game.battle.Hero"
+	opts.ServerRoutes["hall.heroHandler.get"] = "game.hall.Hero"
+	opts.ServerRoutes["battle.battleHandler.start"] = "BattleResponse"
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, ok := parser.GetMessages()["game.battle.Hero"]
+	if !ok || msg.Package != "game.battle" || msg.Name != "Hero" {
+		t.Fatalf("game.battle.Hero = %+v", msg)
+	}
+
+	battleHero := schema.Server["battle.heroHandler.get"].(map[string]interface{})
+	if battleHero["optional int32 hp"] != 1 {
+		t.Fatalf("battle hero = %v", battleHero)
+	}
+
+	hallHero := schema.Server["hall.heroHandler.get"].(map[string]interface{})
+	if hallHero["optional string name"] != 1 {
+		t.Fatalf("hall hero = %v", hallHero)
+	}
+
+	// Hero 在两个包中同名，使用完整名称；Skill 唯一，保持短名称
+	battle := schema.Server["battle.battleHandler.start"].(map[string]interface{})
+	if battle["optional message game.battle.Hero hero"] != 1 || battle["optional message Skill skill"] != 2 {
+		t.Fatalf("battle = %v", battle)
+	}
+
+	messages := battle[MessagesKey].(map[string]interface{})
+	if _, found := messages["game.battle.Hero"]; !found {
+		t.Fatalf("messages = %v", messages)
+	}
+	if _, found := messages["Skill"]; !found {
+		t.Fatalf("messages = %v", messages)
+	}
+}
+
+func TestParseServiceRoutes(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "entry.proto", `
+syntax = "proto3";
+package game;
+
+message EntryRequest {
+    string token = 1;
+}
+
+message EntryResponse {
+    int32 code = 1;
+}
+
+message LogoutRequest {
+    int64 uid = 1;
+}
+
+message Custom {
+    bool ok = 1;
+}
+
+service EntryHandler {
+    rpc Entry (EntryRequest) returns (EntryResponse);
+    rpc Logout(LogoutRequest) returns (EntryResponse) {}
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.AutoRouteFromService = true
+	opts.RoutePrefix = "connector"
+	opts.ServerRoutes["connector.entryHandler.logout"] = "Custom"
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parser.GetServices()) != 1 || len(parser.GetServices()[0].Methods) != 2 {
+		t.Fatalf("services = %+v", parser.GetServices())
+	}
+
+	wantServer := map[string]string{
+		"connector.entryHandler.entry":  "game.EntryResponse",
+		"connector.entryHandler.logout": "Custom",
+	}
+	wantClient := map[string]string{
+		"connector.entryHandler.entry":  "game.EntryRequest",
+		"connector.entryHandler.logout": "game.LogoutRequest",
+	}
+
+	if !reflect.DeepEqual(parser.serverRoutes, wantServer) {
+		t.Fatalf("server routes = %v, want %v", parser.serverRoutes, wantServer)
+	}
+
+	if !reflect.DeepEqual(parser.clientRoutes, wantClient) {
+		t.Fatalf("client routes = %v, want %v", parser.clientRoutes, wantClient)
+	}
+
+	logout := schema.Server["connector.entryHandler.logout"].(map[string]interface{})
+	if logout["optional bool ok"] != 1 {
+		t.Fatalf("manual route should take precedence. logout = %v", logout)
+	}
+
+	entry := schema.Client["connector.entryHandler.entry"].(map[string]interface{})
+	if entry["optional string token"] != 1 {
+		t.Fatalf("entry = %v", entry)
+	}
+}
+
+func TestParseStreamRPC(t *testing.T) {
+	opts := DefaultOptions()
+	opts.AutoRouteFromService = true
+	opts.RoutePrefix = "chat"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("chat.proto", `
+syntax = "proto3";
+
+message SubRequest {
+    string channel = 1;
+}
+
+message ChatMessage {
+    string text = 1;
+}
+
+service ChatHandler {
+    rpc Sub (SubRequest) returns (stream ChatMessage);
+    rpc Send (stream ChatMessage) returns (SubRequest);
+    rpc Join (SubRequest) returns (SubRequest);
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := parser.GetServices()[0].Methods[0]
+	if sub.RequestType != "SubRequest" || sub.ResponseType != "ChatMessage" || sub.RequestStream || !sub.ResponseStream {
+		t.Fatalf("sub = %+v", sub)
+	}
+	if _, found := schema.Server["chat.chatHandler.sub"]; !found {
+		t.Fatalf("server routes = %v", schema.Server)
+	}
+
+	want := map[string]RouteStream{
+		"chat.chatHandler.sub":  {Response: true},
+		"chat.chatHandler.send": {Request: true},
+	}
+	if !reflect.DeepEqual(schema.StreamRoutes, want) {
+		t.Fatalf("stream routes = %v, want %v", schema.StreamRoutes, want)
+	}
+	if !schema.IsStreamRoute("chat.chatHandler.sub") || schema.IsStreamRoute("chat.chatHandler.join") {
+		t.Fatal("IsStreamRoute mismatch")
+	}
+
+	// 流式声明只在服务端使用，不下发给客户端
+	data, err := schema.MarshalClientJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "streamRoutes") {
+		t.Fatalf("client json should not contain stream routes: %s", data)
+	}
+
+	merged := MergeSchema(schema, &ProtoSchema{Server: map[string]interface{}{"chat.chatHandler.sub": map[string]interface{}{}}})
+	if merged.IsStreamRoute("chat.chatHandler.sub") || !merged.IsStreamRoute("chat.chatHandler.send") {
+		t.Fatalf("merged stream routes = %v", merged.StreamRoutes)
+	}
+}
+
+func TestParserAddMessage(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	opts.ServerRoutes["game.bagHandler.info"] = "BagResponse"
+	opts.ClientRoutes["game.bagHandler.info"] = "game.BagRequest"
+
+	parser := NewParser(opts)
+	messages := []*ProtoMessage{
+		{Name: "Item", Fields: []*ProtoField{
+			{Name: "id", Type: TypeUInt32, Tag: 1},
+		}},
+		{Name: "BagResponse", Fields: []*ProtoField{
+			{Name: "code", Type: TypeInt32, Tag: 1},
+			{Name: "items", Type: TypeMessage, TypeName: "Item", Tag: 2, Repeated: true},
+		}},
+		{Name: "BagRequest", Package: "game", Fields: []*ProtoField{
+			{Name: "page", Type: TypeInt32, Tag: 1, Required: true},
+		}},
+	}
+	for _, msg := range messages {
+		if err := parser.AddMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := parser.AddMessage(&ProtoMessage{Name: "Item"}); err == nil {
+		t.Fatal("duplicate message should return error")
+	}
+	if err := parser.AddMessage(&ProtoMessage{Name: "Bad", Fields: []*ProtoField{
+		{Name: "a", Type: TypeInt32, Tag: 1},
+		{Name: "b", Type: TypeInt32, Tag: 1},
+	}}); err == nil {
+		t.Fatal("invalid message should return error in strict mode")
+	}
+	if err := parser.ParseString("item.proto", "message Item { int32 id = 1; }\n"); err == nil {
+		t.Fatal("message defined in file should conflict with registered message")
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantServer := map[string]interface{}{
+		"optional int32 code":         1,
+		"repeated message Item items": 2,
+		MessagesKey:                   map[string]interface{}{"Item": map[string]interface{}{"optional uInt32 id": 1}},
+	}
+	if !reflect.DeepEqual(schema.Server["game.bagHandler.info"], wantServer) {
+		t.Fatalf("server = %v", schema.Server["game.bagHandler.info"])
+	}
+
+	wantClient := map[string]interface{}{"required int32 page": 1}
+	if !reflect.DeepEqual(schema.Client["game.bagHandler.info"], wantClient) {
+		t.Fatalf("client = %v", schema.Client["game.bagHandler.info"])
+	}
+}
+
+func TestParseString(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("hero.proto", heroProto); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := schema.Server["game.heroHandler.list"].(map[string]interface{})
+	if route["optional uInt32 code"] != 1 || route["repeated message Hero heroes"] != 2 {
+		t.Fatalf("route = %v", route)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	parser := NewParser(DefaultOptions())
+	if err := parser.ParseReader("hero.proto", strings.NewReader(heroProto)); err != nil {
+		t.Fatal(err)
+	}
+
+	hero, ok := parser.GetMessages()["Hero"]
+	if !ok || len(hero.Fields) != 2 {
+		t.Fatalf("Hero = %+v", hero)
+	}
+}
+
+func TestParseProtoFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"protos/hero.proto": &fstest.MapFile{Data: []byte(`
+import "common/item.proto";
+
+message HeroResponse {
+    int32 id = 1;
+    repeated Item items = 2;
+}
+`)},
+		"protos/common/item.proto": &fstest.MapFile{Data: []byte(`
+message Item {
+    int32 itemId = 1;
+}
+`)},
+		"other/ignored.proto": &fstest.MapFile{Data: []byte(`
+message Ignored {
+    int32 id = 1;
+}
+`)},
+	}
+
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "entry.proto", `
+message EntryResponse {
+    int32 code = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ProtoFS = fsys
+	opts.ProtoFSDir = "protos"
+	opts.StrictMode = true
+	opts.ServerRoutes["game.heroHandler.get"] = "HeroResponse"
+	opts.ServerRoutes["connector.entryHandler.entry"] = "EntryResponse"
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := parser.GetMessages()["Ignored"]; found {
+		t.Fatal("files outside ProtoFSDir should not be parsed")
+	}
+
+	hero := schema.Server["game.heroHandler.get"].(map[string]interface{})
+	messages := hero[MessagesKey].(map[string]interface{})
+	if _, found := messages["Item"]; !found {
+		t.Fatalf("hero = %v", hero)
+	}
+
+	if _, found := schema.Server["connector.entryHandler.entry"]; !found {
+		t.Fatal("disk proto should be parsed together with ProtoFS")
+	}
+
+	fsOnly := DefaultOptions()
+	fsOnly.ProtoFS = fsys
+	if !fsOnly.HasProtoConfig() {
+		t.Fatal("HasProtoConfig should be true when ProtoFS is set")
+	}
+}
+
+func TestParseLongLine(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "long.proto", `
+message LongResponse {
+    // `+strings.Repeat("x", 100*1024)+`
+    int32 code = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.ServerRoutes["game.handler.long"] = "LongResponse"
+
+	schema := parseOptions(t, opts)
+	if _, found := schema.Server["game.handler.long"]; !found {
+		t.Fatal("line longer than 64KB should be parsed by default")
+	}
+
+	opts.MaxLineBytes = 64 * 1024
+	_, err := NewParser(opts).Parse()
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("err = %v, want bufio.ErrTooLong", err)
+	}
+}
+
+func writeManyProtoFiles(tb testing.TB, dir string, count int) {
+	tb.Helper()
+	for i := 0; i < count; i++ {
+		content := fmt.Sprintf(`
+package game;
+
+enum Status%d {
+    OK = 0;
+    FAIL = 1;
+}
+
+message Item%d {
+    int32 id = 1;
+    Status%d status = 2;
+}
+
+message Item%dResponse {
+    repeated Item%d items = 1;
+    map<string, Item%d> index = 2;
+}
+`, i, i, i, i, i, i)
+		path := filepath.Join(dir, fmt.Sprintf("item%d.proto", i))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			tb.Fatalf("write proto file failed: %v", err)
+		}
+	}
+}
+
+func TestParseConcurrentMatchesSequential(t *testing.T) {
+	dir := t.TempDir()
+	writeManyProtoFiles(t, dir, 50)
+
+	parse := func(concurrency int) (*Parser, *ProtoSchema) {
+		opts := DefaultOptions()
+		opts.ProtoDir = dir
+		opts.StrictMode = true
+		opts.ParseConcurrency = concurrency
+		for i := 0; i < 50; i++ {
+			opts.ServerRoutes[fmt.Sprintf("game.item.list%d", i)] = fmt.Sprintf("Item%dResponse", i)
+		}
+
+		parser := NewParser(opts)
+		schema, err := parser.Parse()
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		return parser, schema
+	}
+
+	sequentialParser, sequential := parse(1)
+	for _, concurrency := range []int{2, 8, 0} {
+		concurrentParser, concurrent := parse(concurrency)
+		if !reflect.DeepEqual(sequential, concurrent) {
+			t.Fatalf("concurrency %d: schema differs from sequential parse", concurrency)
+		}
+		if !reflect.DeepEqual(sequentialParser.GetMessages(), concurrentParser.GetMessages()) {
+			t.Fatalf("concurrency %d: messages differ from sequential parse", concurrency)
+		}
+	}
+}
+
+func TestParseDuplicateMessage(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "a.proto", `
+message Hero {
+    int32 id = 1;
+}
+`)
+	writeProtoFile(t, dir, "b.proto", `
+message Hero {
+    string name = 1;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.hero.info"] = "Hero"
+
+	schema := parseOptions(t, opts)
+	route := schema.Server["game.hero.info"].(map[string]interface{})
+	if _, found := route["optional int32 id"]; !found {
+		t.Fatalf("first definition should win, got %v", route)
+	}
+
+	opts.StrictMode = true
+	_, err := NewParser(opts).Parse()
+	if err == nil || !strings.Contains(err.Error(), "类型重复定义") {
+		t.Fatalf("strict mode should report duplicate definition, got %v", err)
+	}
+	for _, file := range []string{"a.proto", "b.proto"} {
+		if !strings.Contains(err.Error(), file) {
+			t.Fatalf("error should name both files, got %v", err)
+		}
+	}
+}
+
+func TestParseDuplicateMessageInFile(t *testing.T) {
+	content := `
+message Hero { int32 id = 1; }
+enum Color { RED = 0; }
+message Hero { string name = 1; }
+message Color { int32 id = 1; }
+`
+
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.hero.info"] = "Hero"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("hero.proto", content); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg, _ := parser.GetMessage("Hero"); len(msg.Fields) != 1 || msg.Fields[0].Name != "id" {
+		t.Fatalf("first definition should win, got %+v", msg)
+	}
+	if _, found := parser.GetMessage("Color"); found {
+		t.Fatal("message with the same name as an enum should be ignored")
+	}
+
+	opts.StrictMode = true
+	err := NewParser(opts).ParseString("hero.proto", content)
+	if err == nil || !strings.Contains(err.Error(), "类型重复定义: Hero") {
+		t.Fatalf("strict mode should report duplicate definition, got %v", err)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	dir := b.TempDir()
+	writeManyProtoFiles(b, dir, 200)
+
+	for _, concurrency := range []int{1, 0} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.ProtoDir = dir
+			opts.ParseConcurrency = concurrency
+			opts.ServerRoutes["game.item.list"] = "Item0Response"
+
+			for i := 0; i < b.N; i++ {
+				if _, err := NewParser(opts).Parse(); err != nil {
+					b.Fatalf("parse failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetProtoFilesGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+	writeProtoFile(t, dir, "item.proto", "message Item {}\n")
+	writeProtoFile(t, filepath.Join(dir, "sub"), "skill.proto", "message Skill {}\n")
+	writeProtoFile(t, filepath.Join(dir, "sub", "deep"), "buff.proto", "message Buff {}\n")
+	writeProtoFile(t, dir, "readme.txt", "")
+
+	rel := func(files []string) []string {
+		result := make([]string, 0, len(files))
+		for _, file := range files {
+			r, err := filepath.Rel(dir, file)
+			if err != nil {
+				t.Fatalf("rel failed: %v", err)
+			}
+			result = append(result, filepath.ToSlash(r))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"star", []string{filepath.Join(dir, "*.proto")}, []string{"hero.proto", "item.proto"}},
+		{"recursive", []string{filepath.Join(dir, "**", "*.proto")}, []string{"hero.proto", "item.proto", "sub/deep/buff.proto", "sub/skill.proto"}},
+		{"literal", []string{filepath.Join(dir, "sub", "skill.proto")}, []string{"sub/skill.proto"}},
+		{"dedupe", []string{filepath.Join(dir, "hero.proto"), filepath.Join(dir, "*.proto")}, []string{"hero.proto", "item.proto"}},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.ProtoFiles = tt.files
+
+		files, err := NewParser(opts).getProtoFiles()
+		if err != nil {
+			t.Fatalf("%s: getProtoFiles failed: %v", tt.name, err)
+		}
+		if got := rel(files); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: files = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.ProtoFiles = []string{filepath.Join(dir, "**", "*.pb")}
+	if _, err := NewParser(opts).getProtoFiles(); err == nil {
+		t.Fatal("glob matching no files should return error")
+	}
+}
+
+func TestGetProtoFilesExclude(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+	writeProtoFile(t, dir, "hero_test.proto", "message HeroFixture {}\n")
+	writeProtoFile(t, filepath.Join(dir, "vendor", "google"), "any.proto", "message Any {}\n")
+	writeProtoFile(t, filepath.Join(dir, "sub"), "skill_test.proto", "message SkillFixture {}\n")
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ProtoFiles = []string{filepath.Join(dir, "vendor", "google", "any.proto")}
+	opts.ExcludePatterns = []string{"vendor/**", "*_test.proto"}
+
+	files, err := NewParser(opts).getProtoFiles()
+	if err != nil {
+		t.Fatalf("getProtoFiles failed: %v", err)
+	}
+
+	want := []string{filepath.Join(dir, "hero.proto")}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("files = %v, want %v", files, want)
+	}
+
+	fsOpts := DefaultOptions()
+	fsOpts.ProtoFS = fstest.MapFS{
+		"proto/hero.proto":          {Data: []byte(heroProto)},
+		"proto/vendor/any.proto":    {Data: []byte("message Any {}\n")},
+		"proto/sub/item_test.proto": {Data: []byte("message ItemFixture {}\n")},
+	}
+	fsOpts.ProtoFSDir = "proto"
+	fsOpts.ExcludePatterns = []string{"vendor/**", "*_test.proto"}
+
+	fsFiles, err := NewParser(fsOpts).getFSProtoFiles()
+	if err != nil {
+		t.Fatalf("getFSProtoFiles failed: %v", err)
+	}
+	if !reflect.DeepEqual(fsFiles, []string{"proto/hero.proto"}) {
+		t.Fatalf("fs files = %v", fsFiles)
+	}
+}
+
+func TestGetProtoFilesExtensions(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+	writeProtoFile(t, dir, "item.PROTO3", "message Item {}\n")
+	writeProtoFile(t, dir, "readme.txt", "not a proto file")
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+
+	files, err := NewParser(opts).getProtoFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "hero.proto")}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("files = %v, want %v", files, want)
+	}
+
+	opts.Extensions = []string{".proto", ".proto3"}
+	files, err = NewParser(opts).getProtoFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "hero.proto"), filepath.Join(dir, "item.PROTO3")}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("files = %v, want %v", files, want)
+	}
+
+	fsOpts := DefaultOptions()
+	fsOpts.ProtoFS = fstest.MapFS{
+		"hero.proto":  {Data: []byte(heroProto)},
+		"item.proto3": {Data: []byte("message Item {}\n")},
+	}
+	fsOpts.Extensions = []string{".Proto3"}
+
+	fsFiles, err := NewParser(fsOpts).getFSProtoFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fsFiles, []string{"item.proto3"}) {
+		t.Fatalf("fs files = %v", fsFiles)
+	}
+}
+
+func TestParseManifestFile(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", "syntax = \"proto3\";\nmessage Hero {\n    int32 id = 1;\n}\n")
+	writeProtoFile(t, filepath.Join(dir, "bag"), "item.proto", "syntax = \"proto3\";\nmessage Item {\n    int32 id = 1;\n}\n")
+	writeProtoFile(t, dir, "ignored.proto", "syntax = \"proto3\";\nmessage Ignored {}\n")
+
+	want := []string{filepath.Join(dir, "bag", "item.proto"), filepath.Join(dir, "hero.proto")}
+
+	for _, manifest := range []string{
+		"# proto 文件清单\nbag/item.proto\r\n\nhero.proto\n",
+		`["bag/item.proto", "hero.proto"]`,
+	} {
+		writeProtoFile(t, dir, "protos.txt", manifest)
+
+		opts := DefaultOptions()
+		opts.ManifestFile = filepath.Join(dir, "protos.txt")
+		opts.ProtoDir = dir // 设置清单后不再扫描目录
+		opts.ServerRoutes["game.heroHandler.info"] = "Hero"
+
+		parser := NewParser(opts)
+		schema, err := parser.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files := parser.GetFiles(); !reflect.DeepEqual(files, want) {
+			t.Fatalf("files = %v, want %v", files, want)
+		}
+		if _, found := parser.GetMessage("Ignored"); found {
+			t.Fatal("file not in manifest should not be parsed")
+		}
+		if schema.Server["game.heroHandler.info"] == nil {
+			t.Fatalf("schema = %v", schema.Server)
+		}
+	}
+
+	for _, manifest := range []string{"hero.proto\nhero.proto\n", "missing.proto\n", `["hero.proto",`} {
+		writeProtoFile(t, dir, "protos.txt", manifest)
+
+		opts := DefaultOptions()
+		opts.ManifestFile = filepath.Join(dir, "protos.txt")
+		if _, err := NewParser(opts).Parse(); err == nil {
+			t.Fatalf("manifest %q should return error", manifest)
+		}
+	}
+}
+
+func TestParseRouteMappings(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "entry.proto", `
+message EntryRequest {
+    string token = 1;
+}
+
+message EntryResponse {
+    int32 code = 1;
+}
+
+message ChatRequest {
+    string content = 1;
+}
+`)
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.RouteMappings = []RouteMapping{
+		{Route: "connector.entryHandler.entry", RequestMsg: "EntryRequest", ResponseMsg: "EntryResponse"},
+		{Route: "game.chatHandler.send", RequestMsg: "ChatRequest"},
+	}
+	opts.ServerRoutes["game.hero.list"] = "HeroListResponse"
+
+	schema := parseOptions(t, opts)
+
+	if _, found := schema.Client["connector.entryHandler.entry"].(map[string]interface{})["optional string token"]; !found {
+		t.Fatalf("client routes = %v", schema.Client)
+	}
+	if _, found := schema.Server["connector.entryHandler.entry"].(map[string]interface{})["optional int32 code"]; !found {
+		t.Fatalf("server routes = %v", schema.Server)
+	}
+	if _, found := schema.Client["game.chatHandler.send"]; !found {
+		t.Fatalf("client routes = %v", schema.Client)
+	}
+	if _, found := schema.Server["game.chatHandler.send"]; found {
+		t.Fatal("route without ResponseMsg should not have server schema")
+	}
+	if _, found := schema.Server["game.hero.list"]; !found {
+		t.Fatal("manual ServerRoutes should be merged")
+	}
+}
+
+func TestParseStrictRoutes(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.hero.list"] = "HeroListResponse"
+	opts.ServerRoutes["game.hero.info"] = "HeroInfoRespnse"
+	opts.ClientRoutes["game.hero.list"] = "HeroListReqest"
+
+	// 默认只输出警告，缺失的路由不生成
+	schema := parseOptions(t, opts)
+	if _, found := schema.Server["game.hero.info"]; found {
+		t.Fatal("missing route should be skipped")
+	}
+	if _, found := schema.Server["game.hero.list"]; !found {
+		t.Fatal("valid route should be generated")
+	}
+
+	opts.StrictRoutes = true
+	_, err := NewParser(opts).Parse()
+	if err == nil {
+		t.Fatal("strict routes should return error")
+	}
+
+	for _, want := range []string{
+		"服务端路由: [game.hero.info(message=HeroInfoRespnse)]",
+		"客户端路由: [game.hero.list(message=HeroListReqest)]",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q should contain %q", err, want)
+		}
+	}
+}
+
+func TestParseFieldModifiers(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "login.proto", `
+syntax = "proto2";
+
+message LoginRequest {
+    required string account = 1;
+    optional string password = 2;
+    repeated int32 servers = 3;
+    int64 timestamp = 4;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ClientRoutes["connector.entryHandler.login"] = "LoginRequest"
+
+	schema := parseOptions(t, opts)
+	route := schema.Client["connector.entryHandler.login"].(map[string]interface{})
+
+	want := map[string]interface{}{
+		"required string account":  1,
+		"optional string password": 2,
+		"repeated int32 servers":   3,
+		"optional int64 timestamp": 4,
+	}
+	if !reflect.DeepEqual(route, want) {
+		t.Fatalf("route = %v, want %v", route, want)
+	}
+}
+
+func TestParsePackedFields(t *testing.T) {
+	parser := NewParser(DefaultOptions())
+	if err := parser.ParseString("proto3.proto", `
+syntax = "proto3";
+
+enum Color {
+    RED = 0;
+    BLUE = 1;
+}
+
+message Item {
+    int32 id = 1;
+}
+
+message Proto3Message {
+    repeated int32 ids = 1;
+    repeated int32 unpacked = 2 [packed = false];
+    repeated Color colors = 3;
+    repeated string names = 4;
+    repeated Item items = 5;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.ParseString("proto2.proto", `
+syntax = "proto2";
+
+message Proto2Message {
+    repeated int32 ids = 1;
+    repeated int32 packed = 2 [packed = true];
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.BuildSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		message string
+		field   string
+		packed  bool
+	}{
+		{"Proto3Message", "ids", true},
+		{"Proto3Message", "unpacked", false},
+		{"Proto3Message", "colors", true},
+		{"Proto3Message", "names", false},
+		{"Proto3Message", "items", false},
+		{"Proto2Message", "ids", false},
+		{"Proto2Message", "packed", true},
+	} {
+		var field *ProtoField
+		for _, f := range parser.GetMessages()[tt.message].Fields {
+			if f.Name == tt.field {
+				field = f
+			}
+		}
+		if field == nil {
+			t.Fatalf("%s.%s not found", tt.message, tt.field)
+		}
+		if field.Packed != tt.packed {
+			t.Fatalf("%s.%s packed = %v, want %v", tt.message, tt.field, field.Packed, tt.packed)
+		}
+	}
+}
+
+func TestParseSyntax(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ClientRoutes["game.handler.proto2"] = "Proto2Request"
+	opts.ClientRoutes["game.handler.proto3"] = "Proto3Request"
+
+	parser := NewParser(opts)
+	for name, content := range map[string]string{
+		"proto2.proto": `
+syntax = "proto2";
+
+message Proto2Request {
+    required int32 id = 1;
+    int32 count = 2;
+}
+`,
+		"proto3.proto": `
+syntax = "proto3";
+
+message Proto3Request {
+    required int32 id = 1;
+    int32 count = 2;
+}
+`,
+		"none.proto": `
+message NoSyntax {
+    int32 id = 1;
+}
+`,
+	} {
+		if err := parser.ParseString(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"Proto2Request": SyntaxProto2,
+		"Proto3Request": SyntaxProto3,
+		"NoSyntax":      SyntaxProto2,
+	} {
+		if msg, _ := parser.GetMessage(name); msg == nil || msg.Syntax != want {
+			t.Fatalf("%s syntax = %v, want %s", name, msg, want)
+		}
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// proto3 不支持 required，按 optional 处理
+	for route, want := range map[string]map[string]interface{}{
+		"game.handler.proto2": {"required int32 id": 1, "optional int32 count": 2},
+		"game.handler.proto3": {"optional int32 id": 1, "optional int32 count": 2},
+	} {
+		if !reflect.DeepEqual(schema.Client[route], want) {
+			t.Fatalf("%s = %v, want %v", route, schema.Client[route], want)
+		}
+	}
+
+	// 严格模式下 proto3 的 required 和 proto2 未声明修饰符的字段返回错误
+	opts.StrictMode = true
+	for name, content := range map[string]string{
+		"required.proto": "syntax = \"proto3\";\nmessage A { required int32 id = 1; }\n",
+		"label.proto":    "syntax = \"proto2\";\nmessage A { int32 id = 1; }\n",
+	} {
+		if err := NewParser(opts).ParseString(name, content); err == nil {
+			t.Fatalf("%s should fail in strict mode", name)
+		}
+	}
+
+	// 不支持的 syntax 版本
+	for _, mode := range []bool{false, true} {
+		opts.StrictMode = mode
+		err := NewParser(opts).ParseString("proto4.proto", "syntax = \"proto4\";\nmessage A { int32 id = 1; }\n")
+		if err == nil || !strings.Contains(err.Error(), "proto4") {
+			t.Fatalf("unsupported syntax err = %v", err)
+		}
+	}
+}
+
+func TestParseTypeOverrides(t *testing.T) {
+	opts := DefaultOptions()
+	opts.TypeOverrides["int64"] = TypeString
+	opts.ServerRoutes["game.player.info"] = "PlayerInfo"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("player.proto", `
+syntax = "proto3";
+
+message PlayerInfo {
+    int64 uid = 1;
+    repeated int64 friends = 2;
+    uint64 gold = 3;
+    map<int32, int64> scores = 4;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := schema.Server["game.player.info"].(map[string]interface{})
+	for key, tag := range map[string]int{
+		"optional string uid":     1,
+		"repeated string friends": 2,
+		"optional uInt64 gold":    3,
+	} {
+		if route[key] != tag {
+			t.Fatalf("%q = %v, want %d. route = %v", key, route[key], tag, route)
+		}
+	}
+
+	entry := route[MessagesKey].(map[string]interface{})["PlayerInfo_scoresEntry"].(map[string]interface{})
+	if entry["optional string value"] != 2 {
+		t.Fatalf("map value should use override. entry = %v", entry)
+	}
+
+	msg, _ := parser.GetMessage("PlayerInfo")
+	if msg.Fields[1].Packed {
+		t.Fatal("repeated string field should not be packed")
+	}
+}
+
+func TestParseInt64AsString(t *testing.T) {
+	content := `
+syntax = "proto2";
+
+message PlayerInfo {
+    optional int64 uid = 1;
+    optional uint64 gold = 2 [default = 100];
+    optional sint64 delta = 3;
+    repeated fixed64 ids = 4;
+    optional int32 level = 5;
+}
+`
+
+	for _, asString := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.Int64AsString = asString
+		opts.ServerRoutes["game.player.info"] = "PlayerInfo"
+
+		parser := NewParser(opts)
+		if err := parser.ParseString("player.proto", content); err != nil {
+			t.Fatal(err)
+		}
+
+		schema, err := parser.BuildSchema()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]interface{}{
+			"optional int64 uid":    1,
+			"optional uInt64 gold":  2,
+			"optional sInt64 delta": 3,
+			"repeated uInt64 ids":   4,
+			"optional int32 level":  5,
+			DefaultsKey:             map[string]interface{}{"gold": uint64(100)},
+		}
+		if asString {
+			want = map[string]interface{}{
+				"optional string uid":   1,
+				"optional string gold":  2,
+				"optional string delta": 3,
+				"repeated string ids":   4,
+				"optional int32 level":  5,
+				DefaultsKey:             map[string]interface{}{"gold": "100"},
+			}
+		}
+
+		if route := schema.Server["game.player.info"]; !reflect.DeepEqual(route, want) {
+			t.Fatalf("Int64AsString=%v route = %v, want %v", asString, route, want)
+		}
+
+		// 解析结果仍保留整数类型
+		if msg, _ := parser.GetMessage("PlayerInfo"); msg.Fields[0].Type != TypeInt64 {
+			t.Fatalf("field type = %s, want %s", msg.Fields[0].Type, TypeInt64)
+		}
+	}
+}
+
+func TestParseWellKnownTypes(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "event.proto", `
+syntax = "proto3";
+
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+import "google/protobuf/empty.proto";
+
+message EventResponse {
+    google.protobuf.Timestamp createdAt = 1;
+    google.protobuf.StringValue title = 2;
+    .google.protobuf.Int64Value score = 3;
+    google.protobuf.Empty nothing = 4;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.StrictMode = true
+	opts.MapWellKnownTypes = true
+	opts.ServerRoutes["game.event.get"] = "EventResponse"
+
+	schema := parseOptions(t, opts)
+
+	want := map[string]interface{}{
+		"optional int64 createdAt":       1,
+		"optional string title":          2,
+		"optional int64 score":           3,
+		"optional message Empty nothing": 4,
+		MessagesKey: map[string]interface{}{
+			"Empty": map[string]interface{}{},
+		},
+	}
+	if route := schema.Server["game.event.get"]; !reflect.DeepEqual(route, want) {
+		t.Fatalf("route = %v, want %v", route, want)
+	}
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未开启时 well-known type 按未定义的消息处理
+	opts.MapWellKnownTypes = false
+	if _, err := NewParser(opts).Parse(); err == nil {
+		t.Fatal("well-known types should be unresolved without MapWellKnownTypes")
+	}
+}
+
+func TestParseFieldDefaults(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "player.proto", `
+syntax = "proto2";
+
+enum Camp {
+    RED = 1;
+    BLUE = 2;
+}
+
+message PlayerResponse {
+    optional int32 level = 1 [default = 1];
+    optional string name = 2 [default = "new, player"];
+    optional bool online = 3 [deprecated = true, default = true];
+    optional double rate = 4 [default = 0.5];
+    optional uint32 gold = 5 [default = 100];
+    optional Camp camp = 6 [default = BLUE];
+    optional int64 exp = 7;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.player.info"] = "PlayerResponse"
+
+	parser := NewParser(opts)
+	schema, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	online := parser.GetMessages()["PlayerResponse"].Fields[2]
+	if online.Options["deprecated"] != "true" || online.Options["default"] != "true" {
+		t.Fatalf("options = %v", online.Options)
+	}
+
+	route := schema.Server["game.player.info"].(map[string]interface{})
+	want := map[string]interface{}{
+		"level":  int64(1),
+		"name":   "new, player",
+		"online": true,
+		"rate":   0.5,
+		"gold":   uint64(100),
+		"camp":   2,
+	}
+	if !reflect.DeepEqual(route[DefaultsKey], want) {
+		t.Fatalf("defaults = %#v, want %#v", route[DefaultsKey], want)
+	}
+}
+
+func TestParseDeprecatedFields(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.hero.info"] = "HeroResponse"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("hero.proto", `
+message Hero {
+    int32 id = 1;
+    int32 oldLevel = 2 [deprecated = true];
+}
+
+message HeroResponse {
+    Hero hero = 1;
+    int32 code = 2 [deprecated=false];
+    string tip = 3 [json_name = "tips", deprecated = true];
+}
+
+message Unrouted {
+    int32 legacy = 1 [deprecated = true];
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.BuildSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Hero.oldLevel", "HeroResponse.tip"}
+	if got := parser.DeprecatedFields(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("deprecated fields = %v, want %v", got, want)
+	}
+
+	if report := parser.Report(); !strings.Contains(report, "optional int32 oldLevel [deprecated]") {
+		t.Fatalf("report should mark deprecated field:\n%s", report)
+	}
+}
+
+func TestParseSharedMessages(t *testing.T) {
+	content := `
+message HeroResponse {
+    int32 code = 1;
+}
+
+message HeroRequest {
+    int32 id = 1;
+}
+`
+
+	parse := func(opts Options) *Parser {
+		opts.ServerRoutes["game.hero.info"] = "HeroResponse"
+		opts.ServerRoutes["game.hero.detail"] = "HeroResponse"
+		opts.ClientRoutes["game.hero.info"] = "HeroRequest"
+		opts.ClientRoutes["game.hero.detail"] = "HeroResponse"
+
+		parser := NewParser(opts)
+		if err := parser.ParseString("hero.proto", content); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parser.BuildSchema(); err != nil {
+			t.Fatal(err)
+		}
+		return parser
+	}
+
+	want := []SharedMessage{{Side: "server", Message: "HeroResponse", Routes: []string{"game.hero.detail", "game.hero.info"}}}
+	if got := parse(DefaultOptions()).SharedMessages(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("shared messages = %+v, want %+v", got, want)
+	}
+
+	opts := DefaultOptions()
+	opts.AllowSharedMessages = []string{"HeroResponse"}
+	if got := parse(opts).SharedMessages(); len(got) != 0 {
+		t.Fatalf("allowed shared message should not be reported: %+v", got)
+	}
+}
+
+func TestParseJSONName(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.player.info"] = "PlayerResponse"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("player.proto", `
+syntax = "proto2";
+
+message PlayerResponse {
+    optional int32 player_id = 1 [json_name = "playerId"];
+    optional string nick_name = 2 [default = "guest", json_name = 'nick'];
+    repeated int32 item_ids = 3;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := schema.Server["game.player.info"].(map[string]interface{})
+	for key, tag := range map[string]int{
+		"optional int32 playerId": 1,
+		"optional string nick":    2,
+		"repeated int32 item_ids": 3,
+	} {
+		if route[key] != tag {
+			t.Fatalf("route[%q] = %v, want %d, schema = %v", key, route[key], tag, route)
+		}
+	}
+	if defaults := route[DefaultsKey].(map[string]interface{}); defaults["nick"] != "guest" {
+		t.Fatalf("defaults = %v", defaults)
+	}
+
+	// json_name 与其他字段名冲突，严格模式下报错
+	opts.StrictMode = true
+	err = NewParser(opts).ParseString("conflict.proto", `
+message Conflict {
+    int32 id = 1;
+    int32 uid = 2 [json_name = "id"];
+}
+`)
+	if err == nil || !strings.Contains(err.Error(), "字段名重复") {
+		t.Fatalf("strict mode should report conflicting json_name, got %v", err)
+	}
+}
+
+func TestParseFieldNameCase(t *testing.T) {
+	content := `
+syntax = "proto2";
+
+message PlayerResponse {
+    optional int32 player_id = 1;
+    optional string nick_name = 2 [default = "guest"];
+    repeated PlayerItem item_list_v2 = 3;
+    optional int32 level = 4 [json_name = "lv"];
+}
+
+message PlayerItem {
+    optional int32 config_id = 1;
+}
+`
+
+	opts := DefaultOptions()
+	opts.FieldNameCase = FieldNameCaseCamel
+	opts.ServerRoutes["game.player.info"] = "PlayerResponse"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("player.proto", content); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := schema.Server["game.player.info"].(map[string]interface{})
+	for key, tag := range map[string]int{
+		"optional int32 playerId":                1,
+		"optional string nickName":               2,
+		"repeated message PlayerItem itemListV2": 3,
+		"optional int32 lv":                      4,
+	} {
+		if route[key] != tag {
+			t.Fatalf("route[%q] = %v, schema = %v", key, route[key], route)
+		}
+	}
+	if defaults := route[DefaultsKey].(map[string]interface{}); defaults["nickName"] != "guest" {
+		t.Fatalf("defaults = %v", defaults)
+	}
+	item := route[MessagesKey].(map[string]interface{})["PlayerItem"].(map[string]interface{})
+	if item["optional int32 configId"] != 1 {
+		t.Fatalf("nested message = %v", item)
+	}
+
+	// 解析结果保持原字段名
+	if msg, _ := parser.GetMessage("PlayerResponse"); msg.Fields[0].Name != "player_id" {
+		t.Fatalf("field name = %s, want player_id", msg.Fields[0].Name)
+	}
+
+	// 转换后与其他字段重名，严格模式下报错
+	opts.StrictMode = true
+	err = NewParser(opts).ParseString("conflict.proto", `
+message Conflict {
+    int32 player_id = 1;
+    int32 playerId = 2;
+}
+`)
+	if err == nil || !strings.Contains(err.Error(), "字段名重复") {
+		t.Fatalf("strict mode should report conflicting field names, got %v", err)
+	}
+}
+
+func TestFieldNameCaseConversion(t *testing.T) {
+	tests := []struct {
+		name, camel, snake string
+	}{
+		{"player_id", "playerId", "player_id"},
+		{"item_list_v2", "itemListV2", "item_list_v2"},
+		{"playerId", "playerId", "player_id"},
+		{"playerID", "playerID", "player_id"},
+		{"HTTPServer", "HTTPServer", "http_server"},
+		{"level", "level", "level"},
+	}
+
+	for _, tt := range tests {
+		if got := toCamelCase(tt.name); got != tt.camel {
+			t.Fatalf("toCamelCase(%q) = %q, want %q", tt.name, got, tt.camel)
+		}
+		if got := toSnakeCase(tt.name); got != tt.snake {
+			t.Fatalf("toSnakeCase(%q) = %q, want %q", tt.name, got, tt.snake)
+		}
+		// 转换结果再次转换保持不变
+		if got := toCamelCase(toCamelCase(tt.name)); got != tt.camel {
+			t.Fatalf("toCamelCase is not idempotent for %q: %q", tt.name, got)
+		}
+		if got := toSnakeCase(toSnakeCase(tt.name)); got != tt.snake {
+			t.Fatalf("toSnakeCase is not idempotent for %q: %q", tt.name, got)
+		}
+	}
+}
+
+func TestParseReserved(t *testing.T) {
+	parser := NewParser(DefaultOptions())
+	if err := parser.ParseString("reserved.proto", `
+message Reserved {
+    reserved 2, 15, 9 to 11, 100 to max;
+    reserved "foo", 'bar';
+    int32 id = 1;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	reserved := parser.GetMessages()["Reserved"].Reserved
+	wantRanges := []ProtoTagRange{{2, 2}, {15, 15}, {9, 11}, {100, MaxFieldTag}}
+	if !reflect.DeepEqual(reserved.Ranges, wantRanges) {
+		t.Fatalf("ranges = %v, want %v", reserved.Ranges, wantRanges)
+	}
+	if !reflect.DeepEqual(reserved.Names, []string{"foo", "bar"}) {
+		t.Fatalf("names = %v", reserved.Names)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"range", "message A {\n    reserved 9 to 11;\n    int32 id = 10;\n}\n", "字段标签号已被 reserved"},
+		{"name", "message A {\n    reserved \"foo\";\n    int32 foo = 1;\n}\n", "字段名已被 reserved"},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.StrictMode = true
+		err := NewParser(opts).ParseString(tt.name+".proto", tt.content)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: err = %v, want %s", tt.name, err, tt.want)
+		}
+
+		if err := NewParser(DefaultOptions()).ParseString(tt.name+".proto", tt.content); err != nil {
+			t.Fatalf("%s: lenient mode should only warn, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestParseTagRange(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		want string
+	}{
+		{"reserved", "19000", "保留范围 19000~19999"},
+		{"reservedEnd", "19999", "保留范围 19000~19999"},
+		{"overMax", "536870912", "超出范围 1~536870911"},
+	}
+
+	for _, tt := range tests {
+		content := "message A {\n    int32 id = " + tt.tag + ";\n}\n"
+
+		opts := DefaultOptions()
+		opts.StrictMode = true
+		err := NewParser(opts).ParseString(tt.name+".proto", content)
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "field=id") {
+			t.Fatalf("%s: err = %v, want %s", tt.name, err, tt.want)
+		}
+
+		if err := NewParser(DefaultOptions()).ParseString(tt.name+".proto", content); err != nil {
+			t.Fatalf("%s: lenient mode should only warn, got %v", tt.name, err)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	if err := NewParser(opts).ParseString("max.proto", "message A {\n    int32 id = 536870911;\n    int32 b = 18999;\n}\n"); err != nil {
+		t.Fatalf("valid tags should pass, got %v", err)
+	}
+}
+
+func TestParseTagLiterals(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	parser := NewParser(opts)
+	if err := parser.ParseString("literal.proto", `
+message A {
+    int32 hex = 0x10;
+    int32 grouped = 1_000;
+    map<string, int32> values = 0X1_1;
+    int32 octal = 012;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := parser.GetMessage("A")
+	var tags []int
+	for _, field := range msg.Fields {
+		tags = append(tags, field.Tag)
+	}
+	if !reflect.DeepEqual(tags, []int{16, 1000, 17, 10}) {
+		t.Fatalf("tags = %v", tags)
+	}
+
+	for _, tag := range []string{"0x", "abc", "0"} {
+		content := "message B {\n    int32 id = " + tag + ";\n    int32 ok = 2;\n}\n"
+		if err := NewParser(opts).ParseString("invalid.proto", content); err == nil || !strings.Contains(err.Error(), "field=id") {
+			t.Fatalf("tag %s: err = %v", tag, err)
+		}
+
+		lenient := NewParser(DefaultOptions())
+		if err := lenient.ParseString("invalid.proto", content); err != nil {
+			t.Fatalf("tag %s: lenient mode should only warn, got %v", tag, err)
+		}
+		if msg, _ := lenient.GetMessage("B"); msg.Fields[len(msg.Fields)-1].Name != "ok" {
+			t.Fatalf("tag %s: following fields should still be parsed", tag)
+		}
+	}
+}
+
+func TestParseMultipleMessagesPerLine(t *testing.T) {
+	parser := NewParser(DefaultOptions())
+	content := `message A { int32 x = 1; string s = 2 [default = "a;b}"]; } message B { int32 y = 1; }
+message Outer { message Inner { int32 z = 1; } Inner inner = 1; } enum Color { RED = 0; BLUE = 1; }
+`
+	if err := parser.ParseString("minified.proto", content); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := parser.GetMessages()
+	tests := []struct {
+		message string
+		fields  []string
+	}{
+		{"A", []string{"x", "s"}},
+		{"B", []string{"y"}},
+		{"Outer.Inner", []string{"z"}},
+		{"Outer", []string{"inner"}},
+	}
+
+	for _, tt := range tests {
+		msg, found := messages[tt.message]
+		if !found {
+			t.Fatalf("message %s not parsed. messages = %v", tt.message, sortedKeys(messages))
+		}
+
+		var names []string
+		for _, field := range msg.Fields {
+			names = append(names, field.Name)
+		}
+		if !reflect.DeepEqual(names, tt.fields) {
+			t.Fatalf("%s fields = %v, want %v", tt.message, names, tt.fields)
+		}
+	}
+
+	if messages["A"].Fields[1].Options["default"] != "a;b}" {
+		t.Fatalf("options = %v", messages["A"].Fields[1].Options)
+	}
+
+	if enum, found := parser.GetEnums()["Color"]; !found || len(enum.Values) != 2 {
+		t.Fatalf("enums = %v", parser.GetEnums())
+	}
+}
+
+func TestParseBOMAndCRLF(t *testing.T) {
+	content := "\uFEFFmessage LoginResponse {\r\n    int32 code = 1;\r\n    string token = 2;\r\n}\r\n"
+
+	parser := NewParser(DefaultOptions())
+	if err := parser.ParseString("windows.proto", content); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, found := parser.GetMessages()["LoginResponse"]
+	if !found {
+		t.Fatalf("BOM-prefixed message not parsed. messages = %v", sortedKeys(parser.GetMessages()))
+	}
+	if len(msg.Fields) != 2 || msg.Fields[1].Name != "token" {
+		t.Fatalf("fields = %v", msg.Fields)
+	}
+}
+
+func TestParseGlobalMessages(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto+`
+message HeroInfoResponse {
+    int32 code = 1;
+    Hero hero = 2;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.GlobalMessages = true
+	opts.ServerRoutes["game.hero.list"] = "HeroListResponse"
+	opts.ServerRoutes["game.hero.info"] = "HeroInfoResponse"
+
+	schema := parseOptions(t, opts)
+
+	wantHero := map[string]interface{}{
+		"optional int32 configId": 1,
+		"optional string name":    2,
+	}
+	if len(schema.Messages) != 1 || !reflect.DeepEqual(schema.Messages["Hero"], wantHero) {
+		t.Fatalf("global messages = %v", schema.Messages)
+	}
+
+	for _, route := range []string{"game.hero.list", "game.hero.info"} {
+		routeSchema := schema.Server[route].(map[string]interface{})
+		if _, found := routeSchema[MessagesKey]; found {
+			t.Fatalf("route %s should not embed __messages__: %v", route, routeSchema)
+		}
+	}
+
+	if _, found := schema.Server["game.hero.info"].(map[string]interface{})["optional message Hero hero"]; !found {
+		t.Fatalf("route should reference global message by name: %v", schema.Server["game.hero.info"])
+	}
+}
+
+func TestParseCircularMessages(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.handler.a"] = "A"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("circular.proto", `
+message A { B b = 1; }
+message B { A a = 1; }
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := schema.Server["game.handler.a"].(map[string]interface{})
+	messages := route[MessagesKey].(map[string]interface{})
+
+	want := map[string]interface{}{
+		"A": map[string]interface{}{"optional message B b": 1},
+		"B": map[string]interface{}{"optional message A a": 1},
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Fatalf("__messages__ = %v, want %v", messages, want)
+	}
+}
+
+func TestParseUnroutedMessages(t *testing.T) {
+	for _, global := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.GlobalMessages = global
+		opts.ServerRoutes["game.hero.list"] = "HeroListResponse"
+
+		parser := NewParser(opts)
+		if err := parser.ParseString("hero.proto", heroProto+`
+message Orphan {
+    map<string, Hero> heroes = 1;
+}
+`); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := parser.BuildSchema(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Hero 通过 HeroListResponse 间接引用，map entry 不单独报告
+		if unrouted := parser.UnroutedMessages(); !reflect.DeepEqual(unrouted, []string{"Orphan"}) {
+			t.Fatalf("GlobalMessages=%v unrouted = %v, want [Orphan]", global, unrouted)
+		}
+
+		if report := parser.Report(); !strings.Contains(report, "未被路由引用的消息:\n  Orphan\n") {
+			t.Fatalf("report should list orphan message\n%s", report)
+		}
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	longLine := "// " + strings.Repeat("x", 100*1024) + "\n"
+	badA := writeProtoFile(t, dir, "a.proto", longLine+"message A { int32 id = 1; }\n")
+	writeProtoFile(t, dir, "b.proto", "message B { int32 id = 1; }\n")
+	badC := writeProtoFile(t, dir, "c.proto", longLine+"message C { int32 id = 1; }\n")
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.MaxLineBytes = 64 * 1024
+	opts.ServerRoutes["game.handler.b"] = "B"
+
+	schema, err := NewParser(opts).Parse()
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("err = %v, want *ParseError", err)
+	}
+	if len(parseErr.Files) != 2 {
+		t.Fatalf("failed files = %d, want 2: %v", len(parseErr.Files), err)
+	}
+	if parseErr.Files[0].File != badA || parseErr.Files[1].File != badC {
+		t.Fatalf("failed files = [%s %s], want [%s %s]", parseErr.Files[0].File, parseErr.Files[1].File, badA, badC)
+	}
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("err = %v, want bufio.ErrTooLong", err)
+	}
+
+	if schema == nil {
+		t.Fatal("best-effort schema should be returned in lenient mode")
+	}
+	if _, found := schema.Server["game.handler.b"]; !found {
+		t.Fatal("route of the valid file should be in schema")
+	}
+
+	opts.StrictMode = true
+	schema, err = NewParser(opts).Parse()
+	if err == nil || schema != nil {
+		t.Fatalf("strict mode should abort on the first error, err = %v", err)
+	}
+	if errors.As(err, &parseErr) {
+		t.Fatal("strict mode should not aggregate errors")
+	}
+	if !strings.Contains(err.Error(), badA) {
+		t.Fatalf("err = %v, want first failed file %s", err, badA)
+	}
+}
+
+func TestFieldKeyFormatter(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictMode = true
+	opts.ClientRoutes["game.heroHandler.info"] = "HeroRequest"
+	opts.FieldKeyFormatter = func(field *ProtoField) string {
+		if field.Name == "id" {
+			return "" // 使用默认格式
+		}
+		return field.Name + ":" + string(field.Type)
+	}
+	parser := NewParser(opts)
+	err := parser.ParseString("hero.proto", `
+syntax = "proto3";
+
+message HeroRequest {
+    int32 id = 1;
+    repeated string names = 2;
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := schema.Client["game.heroHandler.info"].(map[string]interface{})
+	if msg["names:string"] != 2 || msg["optional int32 id"] != 1 {
+		t.Fatalf("schema = %+v", msg)
+	}
+}
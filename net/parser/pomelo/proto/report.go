@@ -0,0 +1,126 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Report 返回解析结果的文本报告，用于上线前核对解析器看到的内容
+// 不需要先生成 schema，可在 Parse/ParseReader 之后直接调用
+func (p *Parser) Report() string {
+	var buf bytes.Buffer
+	_ = p.ReportTo(&buf)
+	return buf.String()
+}
+
+// ReportTo 将解析结果的文本报告写入 w
+// 包含每个消息的字段（标签号、类型）、嵌套引用是否已解析、消息绑定的路由，以及找不到消息的路由
+func (p *Parser) ReportTo(w io.Writer) error {
+	p.resolveFieldTypes()
+	p.collectRoutes()
+
+	bindings, missing := p.routeBindings()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "消息: %d, 枚举: %d, service: %d\n", len(p.messages), len(p.enums), len(p.services))
+
+	for _, name := range sortedKeys(p.messages) {
+		msg := p.messages[name]
+
+		buf.WriteString("\nmessage " + name)
+		if msg.MapEntry {
+			buf.WriteString(" (map entry)")
+		}
+		buf.WriteString("\n")
+
+		if routes := bindings[name]; len(routes) > 0 {
+			fmt.Fprintf(&buf, "  路由: %s\n", strings.Join(routes, ", "))
+		}
+
+		fields := make([]*ProtoField, len(msg.Fields))
+		copy(fields, msg.Fields)
+		sort.Slice(fields, func(i, j int) bool {
+			return fields[i].Tag < fields[j].Tag
+		})
+
+		for _, field := range fields {
+			flags := ""
+			if field.Packed {
+				flags += " [packed]"
+			}
+			if field.Deprecated() {
+				flags += " [deprecated]"
+			}
+			fmt.Fprintf(&buf, "  %-4d %s%s%s\n", field.Tag, p.buildFieldKey(field), flags, p.reportReference(field))
+		}
+	}
+
+	for _, name := range sortedKeys(p.enums) {
+		fmt.Fprintf(&buf, "\nenum %s\n", name)
+		for _, value := range p.enums[name].Values {
+			fmt.Fprintf(&buf, "  %s = %d\n", value.Name, value.Value)
+		}
+	}
+
+	if len(missing) > 0 {
+		buf.WriteString("\n未找到消息的路由:\n")
+		for _, route := range missing {
+			fmt.Fprintf(&buf, "  %s\n", route)
+		}
+	}
+
+	if unrouted := p.UnroutedMessages(); len(unrouted) > 0 {
+		buf.WriteString("\n未被路由引用的消息:\n")
+		for _, name := range unrouted {
+			fmt.Fprintf(&buf, "  %s\n", name)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// reportReference 返回字段引用类型的解析状态
+func (p *Parser) reportReference(field *ProtoField) string {
+	switch field.Type {
+	case TypeMessage:
+		if _, found := p.messages[field.TypeName]; found {
+			return " -> " + field.TypeName
+		}
+		return " -> [未解析] " + field.TypeName
+	case TypeEnum:
+		return " -> enum " + field.TypeName
+	default:
+		return ""
+	}
+}
+
+// routeBindings 返回消息全名到绑定路由的映射，以及找不到消息的路由
+func (p *Parser) routeBindings() (map[string][]string, []string) {
+	bindings := make(map[string][]string)
+	var missing []string
+
+	sides := []struct {
+		name   string
+		routes map[string]string
+	}{
+		{"server", p.serverRoutes},
+		{"client", p.clientRoutes},
+	}
+
+	for _, side := range sides {
+		for _, route := range sortedKeys(side.routes) {
+			msgName := side.routes[route]
+			if msg, found := p.lookupMessage(msgName); found {
+				bindings[msg.FullName()] = append(bindings[msg.FullName()], side.name+" "+route)
+			} else {
+				missing = append(missing, fmt.Sprintf("%s %s (message=%s)", side.name, route, msgName))
+			}
+		}
+	}
+
+	return bindings, missing
+}
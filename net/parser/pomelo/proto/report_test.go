@@ -0,0 +1,39 @@
+package pomeloProto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParserReport(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.hero.list"] = "HeroListResponse"
+	opts.ServerRoutes["game.hero.info"] = "HeroInfoResponse"
+	opts.ClientRoutes["game.bag.list"] = "BagRequest"
+
+	parser := NewParser(opts)
+	if err := parser.ParseString("hero.proto", heroProto); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.ParseString("bag.proto", `
+message BagRequest {
+    Item item = 1;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	report := parser.Report()
+
+	for _, want := range []string{
+		"message HeroListResponse\n  路由: server game.hero.list\n",
+		"  2    repeated message Hero heroes -> Hero\n",
+		"message BagRequest\n  路由: client game.bag.list\n",
+		"  1    optional message Item item -> [未解析] Item\n",
+		"未找到消息的路由:\n  server game.hero.info (message=HeroInfoResponse)\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("report should contain %q\n%s", want, report)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	clog "github.com/cherry-game/cherry/logger"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// schemaJSON 序列化 schema 使用的 json 配置，map 按 key 排序，保证输出稳定
+var schemaJSON = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// MarshalClientJSON 将 schema 序列化为 key 有序的 JSON
+// 内容与握手时下发给客户端的 protos 一致，可用于客户端预置解码协议
+func (s *ProtoSchema) MarshalClientJSON() ([]byte, error) {
+	data, err := schemaJSON.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// jsoniter 对嵌套 interface{} 的缩进输出不正确，使用标准库格式化
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// EstimateHandshakeSize 估算 schema 在握手数据中序列化后的字节数，序列化失败时返回 0
+func (s *ProtoSchema) EstimateHandshakeSize() int {
+	data, err := schemaJSON.Marshal(s)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// MarshalGzip 将 schema 序列化为 JSON 后 gzip 压缩
+func (s *ProtoSchema) MarshalGzip() ([]byte, error) {
+	data, err := schemaJSON.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalGzipSchema 解压并解析 MarshalGzip 生成的数据
+func UnmarshalGzipSchema(data []byte) (*ProtoSchema, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解压 schema 失败: %w", err)
+	}
+	defer r.Close()
+
+	schema := &ProtoSchema{}
+	if err := schemaJSON.NewDecoder(r).Decode(schema); err != nil {
+		return nil, fmt.Errorf("解析 schema 失败: %w", err)
+	}
+
+	return schema, nil
+}
+
+// WriteSchemaFile 将 schema 导出到 JSON 文件
+func WriteSchemaFile(schema *ProtoSchema, path string) error {
+	data, err := schema.MarshalClientJSON()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReadSchemaFile 从 JSON 文件加载预先生成的 schema
+func ReadSchemaFile(path string) (*ProtoSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 schema 文件失败: %w", err)
+	}
+
+	schema := &ProtoSchema{}
+	if err := schemaJSON.Unmarshal(data, schema); err != nil {
+		return nil, fmt.Errorf("解析 schema 文件失败: %s, %w", path, err)
+	}
+
+	for name, routes := range map[string]map[string]interface{}{"server": schema.Server, "client": schema.Client} {
+		for route, routeSchema := range routes {
+			if _, ok := routeSchema.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("schema 文件格式错误: %s, %s 路由 %s 不是对象", path, name, route)
+			}
+		}
+	}
+
+	return schema, nil
+}
+
+// RouteIndex 返回路由到源消息全名的映射（服务端、客户端），返回的 map 为副本
+// 只包含构建时记录了消息名的路由，消息名不写入 schema 文件，从 schema 文件加载的路由没有记录
+func (s *ProtoSchema) RouteIndex() (serverByRoute, clientByRoute map[string]string) {
+	serverByRoute = make(map[string]string, len(s.ServerMsgNames))
+	for route, name := range s.ServerMsgNames {
+		serverByRoute[route] = name
+	}
+
+	clientByRoute = make(map[string]string, len(s.ClientMsgNames))
+	for route, name := range s.ClientMsgNames {
+		clientByRoute[route] = name
+	}
+
+	return serverByRoute, clientByRoute
+}
+
+// IsStreamRoute 路由是否由声明了 stream 的 rpc 生成（请求或响应为流）
+func (s *ProtoSchema) IsStreamRoute(route string) bool {
+	stream := s.StreamRoutes[route]
+	return stream.Request || stream.Response
+}
+
+// MergeSchema 将 partial 合并到 base，返回新的 schema，不修改 base 和 partial
+// partial 中的路由新增或覆盖 base 中的同名路由，同名路由内的 __messages__ 取并集；
+// 全局 __messages__ 取并集。定义不同的同名路由/消息以 partial 为准并输出警告。合并后重新计算版本号
+func MergeSchema(base, partial *ProtoSchema) *ProtoSchema {
+	merged := &ProtoSchema{
+		Server:         make(map[string]interface{}),
+		Client:         make(map[string]interface{}),
+		ServerMsgNames: make(map[string]string),
+		ClientMsgNames: make(map[string]string),
+	}
+
+	for _, schema := range []*ProtoSchema{base, partial} {
+		if schema == nil {
+			continue
+		}
+		mergeRoutes("server", merged.Server, schema.Server)
+		mergeRoutes("client", merged.Client, schema.Client)
+		mergeMsgNames(merged.ServerMsgNames, schema.Server, schema.ServerMsgNames)
+		mergeMsgNames(merged.ClientMsgNames, schema.Client, schema.ClientMsgNames)
+		mergeStreamRoutes(merged, schema)
+		mergeConstraints(merged, schema)
+		merged.Messages = mergeMessages("", merged.Messages, schema.Messages)
+	}
+
+	if len(merged.StreamRoutes) == 0 {
+		merged.StreamRoutes = nil
+	}
+	if len(merged.Constraints) == 0 {
+		merged.Constraints = nil
+	}
+
+	merged.Version = SchemaVersion(merged)
+	return merged
+}
+
+// mergeRoutes 将 src 中的路由合并到 dst
+func mergeRoutes(side string, dst, src map[string]interface{}) {
+	for _, route := range sortedKeys(src) {
+		srcSchema, ok := src[route].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		routeSchema := withoutMessages(srcSchema)
+
+		var nested map[string]interface{}
+		if exist, found := dst[route].(map[string]interface{}); found {
+			if !reflect.DeepEqual(withoutMessages(exist), routeSchema) {
+				clog.Warnf("[ProtoParser] 合并 schema 时路由冲突，使用合并的定义: %s %s", side, route)
+			}
+			nested, _ = exist[MessagesKey].(map[string]interface{})
+		}
+
+		srcNested, _ := srcSchema[MessagesKey].(map[string]interface{})
+		if nested = mergeMessages(side+" "+route+" ", nested, srcNested); len(nested) > 0 {
+			routeSchema[MessagesKey] = nested
+		}
+
+		dst[route] = routeSchema
+	}
+}
+
+// mergeMsgNames 合并路由对应的消息名，被覆盖的路由没有记录消息名时删除原有的消息名
+func mergeMsgNames(dst map[string]string, routes map[string]interface{}, names map[string]string) {
+	for route := range routes {
+		if name, found := names[route]; found {
+			dst[route] = name
+		} else {
+			delete(dst, route)
+		}
+	}
+}
+
+// mergeStreamRoutes 合并流式路由，被覆盖的路由没有流式声明时删除原有的声明
+func mergeStreamRoutes(dst, src *ProtoSchema) {
+	if dst.StreamRoutes == nil {
+		dst.StreamRoutes = make(map[string]RouteStream)
+	}
+
+	for _, routes := range []map[string]interface{}{src.Server, src.Client} {
+		for route := range routes {
+			if stream, found := src.StreamRoutes[route]; found {
+				dst.StreamRoutes[route] = stream
+			} else {
+				delete(dst.StreamRoutes, route)
+			}
+		}
+	}
+}
+
+// mergeConstraints 合并客户端路由的校验约束，被覆盖的路由没有约束时删除原有的约束
+func mergeConstraints(dst, src *ProtoSchema) {
+	if dst.Constraints == nil {
+		dst.Constraints = make(map[string]map[string]FieldConstraints)
+	}
+
+	for route := range src.Client {
+		if constraints, found := src.Constraints[route]; found {
+			dst.Constraints[route] = constraints
+		} else {
+			delete(dst.Constraints, route)
+		}
+	}
+}
+
+// mergeMessages 合并 __messages__，返回新的 map，src 中的定义优先
+func mergeMessages(scope string, dst, src map[string]interface{}) map[string]interface{} {
+	if len(dst) == 0 && len(src) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for name, msg := range dst {
+		merged[name] = msg
+	}
+
+	for _, name := range sortedKeys(src) {
+		if exist, found := merged[name]; found && !reflect.DeepEqual(exist, src[name]) {
+			clog.Warnf("[ProtoParser] 合并 schema 时消息冲突，使用合并的定义: %s%s", scope, name)
+		}
+		merged[name] = src[name]
+	}
+
+	return merged
+}
+
+// withoutMessages 返回去掉 __messages__ 后的路由 schema
+func withoutMessages(routeSchema map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(routeSchema))
+	for key, value := range routeSchema {
+		if key != MessagesKey {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// Validate 校验 schema 的内部一致性
+// 遍历 Server 和 Client 路由，确认字段引用的每个 "message <Name>" 都在路由的 __messages__
+// 或全局 __messages__ 中有定义（递归检查嵌套消息），解析生成和外部加载的 schema 都适用
+func (s *ProtoSchema) Validate() error {
+	var problems []string
+
+	for _, side := range []struct {
+		name   string
+		routes map[string]interface{}
+	}{
+		{"server", s.Server},
+		{"client", s.Client},
+	} {
+		for _, route := range sortedKeys(side.routes) {
+			routeSchema, ok := side.routes[route].(map[string]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s 路由 %s 不是对象", side.name, route))
+				continue
+			}
+
+			nested, _ := routeSchema[MessagesKey].(map[string]interface{})
+			for _, ref := range s.danglingReferences(route, routeSchema, nested) {
+				problems = append(problems, fmt.Sprintf("%s 路由 %s", side.name, ref))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("schema 存在未定义的消息引用: %s", strings.Join(problems, "; "))
+}
+
+// danglingReferences 从路由消息开始递归查找未定义的消息引用，返回 "消息.字段(message=类型)" 列表
+func (s *ProtoSchema) danglingReferences(route string, routeSchema, nested map[string]interface{}) []string {
+	var dangling []string
+	visited := make(map[string]bool)
+
+	var walk func(name string, msg map[string]interface{})
+	walk = func(name string, msg map[string]interface{}) {
+		for _, key := range sortedKeys(msg) {
+			parts := strings.Fields(key)
+			if strings.HasPrefix(key, "__") || len(parts) != 4 || parts[1] != string(TypeMessage) {
+				continue
+			}
+
+			typeName := parts[2]
+			if visited[typeName] {
+				continue
+			}
+
+			definition, found := nested[typeName]
+			if !found {
+				definition, found = s.Messages[typeName]
+			}
+
+			definitionMap, ok := definition.(map[string]interface{})
+			if !found || !ok {
+				dangling = append(dangling, fmt.Sprintf("%s.%s(message=%s)", name, parts[3], typeName))
+				continue
+			}
+
+			visited[typeName] = true
+			walk(typeName, definitionMap)
+		}
+	}
+
+	walk(route, routeSchema)
+	return dangling
+}
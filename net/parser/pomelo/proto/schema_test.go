@@ -0,0 +1,195 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestWriteSchemaFileGolden(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.Version = 1
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+	opts.ClientRoutes["game.heroHandler.get"] = "Hero"
+
+	schema := parseOptions(t, opts)
+
+	path := filepath.Join(dir, "schema.json")
+	if err := WriteSchemaFile(schema, path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile(filepath.Join("testdata", "schema.golden.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("schema json mismatch.\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProtoSchemaValidate(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto+`
+message Skill {
+    int32 id = 1;
+}
+
+message HeroSkillResponse {
+    Hero hero = 1;
+    repeated Skill skills = 2;
+}
+`)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+	opts.ServerRoutes["game.heroHandler.skill"] = "HeroSkillResponse"
+
+	schema := parseOptions(t, opts)
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("parsed schema should be valid: %v", err)
+	}
+
+	opts.GlobalMessages = true
+	if err := parseOptions(t, opts).Validate(); err != nil {
+		t.Fatalf("schema with global messages should be valid: %v", err)
+	}
+
+	path := writeSchema(t, `{
+  "version": 1,
+  "server": {
+    "game.heroHandler.skill": {
+      "optional message Hero hero": 1,
+      "repeated message Skill skills": 2,
+      "__messages__": {
+        "Hero": {"optional int32 id": 1, "optional message Buff buff": 2}
+      }
+    }
+  }
+}`)
+
+	loaded, err := ReadSchemaFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = loaded.Validate()
+	if err == nil {
+		t.Fatal("schema missing nested message should be invalid")
+	}
+
+	for _, want := range []string{
+		"server 路由 game.heroHandler.skill.skills(message=Skill)",
+		"server 路由 Hero.buff(message=Buff)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q should contain %q", err, want)
+		}
+	}
+}
+
+func writeSchema(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestProtoSchemaRouteIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", strings.Replace(heroProto, "\n\n", "\n\npackage game;\n\n", 1))
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+	opts.ServerRoutes["game.heroHandler.get"] = "game.Hero"
+	opts.ClientRoutes["game.heroHandler.list"] = "Hero"
+
+	schema := parseOptions(t, opts)
+
+	server, client := schema.RouteIndex()
+	wantServer := map[string]string{"game.heroHandler.list": "game.HeroListResponse", "game.heroHandler.get": "game.Hero"}
+	wantClient := map[string]string{"game.heroHandler.list": "game.Hero"}
+	if !reflect.DeepEqual(server, wantServer) || !reflect.DeepEqual(client, wantClient) {
+		t.Fatalf("route index = %v, %v", server, client)
+	}
+
+	// 消息名只在服务端使用，不写入下发给客户端的 JSON，也不影响版本号
+	data, err := schema.MarshalClientJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("MsgNames")) {
+		t.Fatalf("client json should not contain message names: %s", data)
+	}
+	loaded := &ProtoSchema{}
+	if err := jsoniter.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if server, client := loaded.RouteIndex(); len(server) != 0 || len(client) != 0 {
+		t.Fatalf("loaded route index = %v, %v", server, client)
+	}
+	if SchemaVersion(loaded) != schema.Version {
+		t.Fatal("message names should not affect version")
+	}
+
+	// 合并时覆盖的路由使用新的消息名，没有记录消息名的路由删除原有记录
+	merged := MergeSchema(schema, &ProtoSchema{
+		Server:         map[string]interface{}{"game.heroHandler.get": map[string]interface{}{}, "game.heroHandler.info": map[string]interface{}{}},
+		ServerMsgNames: map[string]string{"game.heroHandler.info": "game.HeroInfo"},
+	})
+	server, client = merged.RouteIndex()
+	wantServer = map[string]string{"game.heroHandler.list": "game.HeroListResponse", "game.heroHandler.info": "game.HeroInfo"}
+	if !reflect.DeepEqual(server, wantServer) || !reflect.DeepEqual(client, wantClient) {
+		t.Fatalf("merged route index = %v, %v", server, client)
+	}
+}
+
+func TestProtoSchemaEstimateHandshakeSize(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+	opts.ServerRoutes["game.heroHandler.rank"] = "HeroListResponse"
+	opts.ClientRoutes["game.heroHandler.get"] = "Hero"
+
+	schema := parseOptions(t, opts)
+
+	data, err := jsoniter.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimate := schema.EstimateHandshakeSize()
+	if diff := estimate - len(data); diff < -len(data)/100 || diff > len(data)/100 {
+		t.Fatalf("estimate = %d, marshaled = %d", estimate, len(data))
+	}
+
+	// 共享的嵌套消息只下发一次
+	opts.GlobalMessages = true
+	if global := parseOptions(t, opts).EstimateHandshakeSize(); global >= estimate {
+		t.Fatalf("global messages estimate = %d, should be smaller than %d", global, estimate)
+	}
+}
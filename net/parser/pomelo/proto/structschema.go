@@ -0,0 +1,197 @@
+package pomeloProto
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// StructTagName 结构体字段声明标签号和类型使用的 tag 名称
+const StructTagName = "pomelo"
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// SchemaFromStruct 根据结构体字段的 pomelo tag 生成路由 schema，结构与解析 proto 文件生成的路由 schema 一致
+// tag 格式为 pomelo:"标签号[,类型[,required]]"，如 pomelo:"1,int32"、pomelo:"2,sint32,required"，
+// 类型支持 proto 类型名（int32、uint32、sint64 等）和 Pomelo 类型名（uInt32、sInt64 等），省略时根据 Go 类型推断；
+// 切片（[]byte 除外）生成 repeated 字段，结构体（或其指针）生成嵌套消息，消息名为结构体类型名，收集到 __messages__ 中。
+// 字段名优先使用 json tag 中的名称，没有 pomelo tag 或为 pomelo:"-" 的字段忽略
+func SchemaFromStruct(route string, v interface{}) (MessageSchema, error) {
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("路由 %s 的消息必须为结构体: %T", route, v)
+	}
+
+	builder := &structSchemaBuilder{
+		nested: make(map[string]interface{}),
+		types:  make(map[string]reflect.Type),
+	}
+
+	result, err := builder.message(typ)
+	if err != nil {
+		return nil, fmt.Errorf("路由 %s 生成 schema 失败: %w", route, err)
+	}
+
+	if len(builder.nested) > 0 {
+		result[MessagesKey] = builder.nested
+	}
+
+	return result, nil
+}
+
+// structSchemaBuilder 生成一个路由 schema 时收集的嵌套消息
+type structSchemaBuilder struct {
+	nested map[string]interface{}  // 嵌套消息名 -> 字段定义
+	types  map[string]reflect.Type // 嵌套消息名 -> 结构体类型，用于发现同名的不同结构体
+}
+
+// message 生成结构体的字段定义
+func (b *structSchemaBuilder) message(typ reflect.Type) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	tags := make(map[int]string)
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag, found := sf.Tag.Lookup(StructTagName)
+		if !found || tag == "-" || !sf.IsExported() {
+			continue
+		}
+
+		key, number, err := b.field(sf, tag)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typ.Name(), sf.Name, err)
+		}
+
+		if exist, found := tags[number]; found {
+			return nil, fmt.Errorf("%s.%s: 标签号 %d 与字段 %s 重复", typ.Name(), sf.Name, number, exist)
+		}
+		tags[number] = sf.Name
+
+		result[key] = number
+	}
+
+	return result, nil
+}
+
+// field 解析单个字段的 tag，返回字段 key 和标签号，嵌套的结构体会递归生成消息定义
+func (b *structSchemaBuilder) field(sf reflect.StructField, tag string) (string, int, error) {
+	parts := strings.Split(tag, ",")
+
+	number, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || number < 1 || number > MaxFieldTag {
+		return "", 0, fmt.Errorf("标签号无效: %q", parts[0])
+	}
+
+	modifier := ModifierOptional
+	if len(parts) > 2 && strings.TrimSpace(parts[2]) == string(ModifierRequired) {
+		modifier = ModifierRequired
+	}
+
+	typ := sf.Type
+	if typ.Kind() == reflect.Slice && typ != bytesType {
+		modifier = ModifierRepeated
+		typ = typ.Elem()
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	var typeStr string
+	if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+		typeStr, err = structFieldType(strings.TrimSpace(parts[1]))
+	} else {
+		typeStr, err = inferFieldType(typ)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if typeStr == string(TypeMessage) {
+		if typ.Kind() != reflect.Struct {
+			return "", 0, fmt.Errorf("message 类型的字段必须为结构体: %s", typ)
+		}
+		if err := b.collect(typ); err != nil {
+			return "", 0, err
+		}
+		typeStr += " " + typ.Name()
+	}
+
+	return string(modifier) + " " + typeStr + " " + structFieldName(sf), number, nil
+}
+
+// collect 将嵌套结构体的定义收集到 __messages__ 中，已收集（包括正在生成）的结构体不重复展开
+func (b *structSchemaBuilder) collect(typ reflect.Type) error {
+	if exist, found := b.types[typ.Name()]; found {
+		if exist != typ {
+			return fmt.Errorf("存在同名的不同结构体: %s, %s", exist, typ)
+		}
+		return nil
+	}
+	b.types[typ.Name()] = typ
+
+	msg, err := b.message(typ)
+	if err != nil {
+		return err
+	}
+	b.nested[typ.Name()] = msg
+	return nil
+}
+
+// structFieldType 将 tag 中声明的类型转换为 Pomelo 类型
+func structFieldType(name string) (string, error) {
+	if t, ok := GetPomeloType(name); ok {
+		return string(t), nil
+	}
+
+	switch FieldType(name) {
+	case TypeUInt32, TypeSInt32, TypeUInt64, TypeSInt64, TypeMessage:
+		return name, nil
+	case TypeEnum:
+		// pomelo 没有枚举类型，按 varint 编码
+		return string(TypeUInt32), nil
+	}
+
+	return "", fmt.Errorf("不支持的类型: %s", name)
+}
+
+// inferFieldType 根据 Go 类型推断 Pomelo 类型
+func inferFieldType(typ reflect.Type) (string, error) {
+	if typ == bytesType {
+		return string(TypeBytes), nil
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return string(TypeString), nil
+	case reflect.Bool:
+		return string(TypeBool), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return string(TypeInt32), nil
+	case reflect.Int, reflect.Int64:
+		return string(TypeInt64), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return string(TypeUInt32), nil
+	case reflect.Uint, reflect.Uint64:
+		return string(TypeUInt64), nil
+	case reflect.Float32:
+		return string(TypeFloat), nil
+	case reflect.Float64:
+		return string(TypeDouble), nil
+	case reflect.Struct:
+		return string(TypeMessage), nil
+	}
+
+	return "", fmt.Errorf("无法推断类型: %s", typ)
+}
+
+// structFieldName 字段名，优先使用 json tag 中的名称
+func structFieldName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return sf.Name
+}
@@ -0,0 +1,94 @@
+package pomeloProto
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type structItem struct {
+	ID    uint32 `json:"id" pomelo:"1"`
+	Count int32  `json:"count" pomelo:"2,sint32"`
+}
+
+type structHero struct {
+	ID      int64        `json:"id" pomelo:"1,int64,required"`
+	Name    string       `json:"name" pomelo:"2"`
+	Level   int32        `json:"level" pomelo:"3,int32"`
+	Rate    float64      `json:"rate" pomelo:"4"`
+	Avatar  []byte       `json:"avatar" pomelo:"5"`
+	Skills  []uint32     `json:"skills" pomelo:"6,uint32"`
+	Items   []structItem `json:"items" pomelo:"7"`
+	Weapon  *structItem  `json:"weapon" pomelo:"8"`
+	Ignored string       `json:"ignored"`
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	got, err := SchemaFromStruct("game.heroHandler.info", &structHero{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.ServerRoutes["game.heroHandler.info"] = "structHero"
+	parser := NewParser(opts)
+	if err := parser.ParseString("hero.proto", `
+message structItem {
+    optional uint32 id = 1;
+    optional sint32 count = 2;
+}
+
+message structHero {
+    required int64 id = 1;
+    optional string name = 2;
+    optional int32 level = 3;
+    optional double rate = 4;
+    optional bytes avatar = 5;
+    repeated uint32 skills = 6;
+    repeated structItem items = 7;
+    optional structItem weapon = 8;
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := parser.BuildSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := schema.Server["game.heroHandler.info"]
+	if !reflect.DeepEqual(map[string]interface{}(got), want) {
+		t.Fatalf("schema = %v, want %v", got, want)
+	}
+}
+
+func TestSchemaFromStructErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"not struct", 1, "必须为结构体"},
+		{"invalid tag", struct {
+			A int32 `pomelo:"x"`
+		}{}, "标签号无效"},
+		{"duplicate tag", struct {
+			A int32 `pomelo:"1"`
+			B int32 `pomelo:"1"`
+		}{}, "重复"},
+		{"unknown type", struct {
+			A int32 `pomelo:"1,int23"`
+		}{}, "不支持的类型"},
+		{"map field", struct {
+			A map[string]int32 `pomelo:"1"`
+		}{}, "无法推断类型"},
+	}
+
+	for _, tt := range tests {
+		_, err := SchemaFromStruct("game.handler.test", tt.v)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
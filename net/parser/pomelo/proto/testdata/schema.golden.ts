@@ -0,0 +1,27 @@
+// Code generated by cherry pomelo proto. DO NOT EDIT.
+// schema version: 1
+
+export interface GameHeroHandlerListResponse {
+  code?: number;
+  heroes?: GameHeroHandlerListResponse.Hero[];
+}
+
+export namespace GameHeroHandlerListResponse {
+  export interface Hero {
+    configId?: number;
+    name?: string;
+  }
+}
+
+export interface GameHeroHandlerGetRequest {
+  configId?: number;
+  name?: string;
+}
+
+export interface ServerRoutes {
+  "game.heroHandler.list": GameHeroHandlerListResponse;
+}
+
+export interface ClientRoutes {
+  "game.heroHandler.get": GameHeroHandlerGetRequest;
+}
@@ -2,10 +2,37 @@ package pomeloProto
 
 // ProtoSchema Pomelo 标准 Protobuf Schema 定义
 type ProtoSchema struct {
-	Version int                      `json:"version"`          // 协议版本号
-	Server  map[string]interface{}   `json:"server,omitempty"` // 服务端消息协议（用于客户端解码）
-	Client  map[string]interface{}   `json:"client,omitempty"` // 客户端消息协议（用于客户端编码）
-	Messages map[string]interface{}  `json:"__messages__,omitempty"`
+	Version  int                    `json:"version"`          // 协议版本号
+	Server   map[string]interface{} `json:"server,omitempty"` // 服务端消息协议（用于客户端解码）
+	Client   map[string]interface{} `json:"client,omitempty"` // 客户端消息协议（用于客户端编码）
+	Messages map[string]interface{} `json:"__messages__,omitempty"`
+
+	// ServerMsgNames/ClientMsgNames 路由对应的源消息全名，路由 schema 展开为字段后无法还原消息名，构建时单独记录
+	// 只在服务端使用（见 RouteIndex），不下发给客户端，也不参与版本号计算
+	ServerMsgNames map[string]string `json:"-"`
+	ClientMsgNames map[string]string `json:"-"`
+
+	// StreamRoutes service 中声明了 stream 的 rpc 生成的路由，只记录流式路由
+	// 只在服务端使用，不下发给客户端，也不参与版本号计算
+	StreamRoutes map[string]RouteStream `json:"-"`
+
+	// Constraints 客户端路由的字段校验约束（路由 -> 字段），由 ValidateMessage 使用
+	// 路由消息的字段 key 为字段名，嵌套消息的字段 key 为 "消息名.字段名"
+	// 只在服务端使用，不下发给客户端，也不参与版本号计算；不写入 schema 文件
+	Constraints map[string]map[string]FieldConstraints `json:"-"`
+}
+
+// FieldConstraints 字段注释中以 @ 声明的校验约束，如 // @min=0 @max=120 @required
+type FieldConstraints struct {
+	Min      *float64 `json:"min,omitempty"`      // 数值的最小值（包含），重复字段校验每个元素
+	Max      *float64 `json:"max,omitempty"`      // 数值的最大值（包含），重复字段校验每个元素
+	Required bool     `json:"required,omitempty"` // 字段必须存在，用于 proto3 等无法声明 required 的字段
+}
+
+// RouteStream 路由请求/响应的流式声明
+type RouteStream struct {
+	Request  bool `json:"request,omitempty"`  // 客户端持续发送请求（rpc 参数声明了 stream）
+	Response bool `json:"response,omitempty"` // 服务端以推送流返回（returns 声明了 stream），而不是单次响应
 }
 
 // MessageSchema 消息 Schema 定义
@@ -30,6 +57,13 @@ const (
 	TypeDouble  FieldType = "double"
 	TypeBytes   FieldType = "bytes"
 	TypeMessage FieldType = "message" // 嵌套消息类型
+	TypeEnum    FieldType = "enum"    // 枚举类型（pomelo 中按 uInt32 编码）
+)
+
+// proto 文件的语法版本
+const (
+	SyntaxProto2 = "proto2"
+	SyntaxProto3 = "proto3"
 )
 
 // FieldModifier 字段修饰符
@@ -44,28 +78,145 @@ const (
 // 特殊字段名
 const (
 	MessagesKey = "__messages__" // 嵌套消息定义的 key
+	EnumsKey    = "__enums__"    // 枚举定义的 key
+	DefaultsKey = "__defaults__" // 字段默认值的 key
 )
 
 // RouteMapping 路由到消息的映射配置
 type RouteMapping struct {
 	Route       string // 路由名称，如 "connector.entryHandler.entry"
-	RequestMsg  string // 请求消息名称（客户端发送）
-	ResponseMsg string // 响应消息名称（服务端返回）
+	RequestMsg  string // 请求消息名称（客户端发送），为空时不生成客户端路由
+	ResponseMsg string // 响应消息名称（服务端返回），为空时不生成服务端路由（如 notify）
+}
+
+// SharedMessage 被多个同方向路由引用的消息
+type SharedMessage struct {
+	Side    string   // 路由方向：server 或 client
+	Message string   // 消息全名
+	Routes  []string // 引用该消息的路由，按字典序排列
 }
 
 // ProtoMessage 解析后的 Proto 消息定义
 type ProtoMessage struct {
-	Name   string                 // 消息名称
-	Fields []*ProtoField          // 字段列表（保持顺序）
+	Name       string        // 消息名称（嵌套消息为 Outer.Inner，不含包名）
+	Package    string        // 所属包名，如 game.battle
+	Fields     []*ProtoField // 字段列表（保持顺序）
+	MapEntry   bool          // 是否为 map 字段生成的 entry 消息
+	Reserved   ProtoReserved // reserved 声明的标签号和字段名
+	Syntax     string        // 所在文件的语法版本，未声明 syntax 时为 proto2
+	Doc        string        // 声明前紧邻的 // 注释，多行以换行符连接
+	SourceFile string        // 定义所在的文件（与日志、错误信息中的文件名一致，ProtoFS 中的文件以 fs: 开头）
+}
+
+const (
+	MaxFieldTag      = 1<<29 - 1 // 字段标签号的最大值（2^29 - 1），reserved 中的 max 即为该值
+	FirstReservedTag = 19000     // protobuf 内部保留标签号的起始值
+	LastReservedTag  = 19999     // protobuf 内部保留标签号的结束值
+)
+
+// ProtoReserved message 中 reserved 声明的标签号范围和字段名
+type ProtoReserved struct {
+	Ranges []ProtoTagRange // 保留的标签号范围
+	Names  []string        // 保留的字段名
+}
+
+// ProtoTagRange 标签号范围（闭区间），单个标签号的 Start 与 End 相同
+type ProtoTagRange struct {
+	Start int
+	End   int
+}
+
+// HasTag 标签号是否被保留
+func (r ProtoReserved) HasTag(tag int) bool {
+	for _, tagRange := range r.Ranges {
+		if tag >= tagRange.Start && tag <= tagRange.End {
+			return true
+		}
+	}
+	return false
+}
+
+// HasName 字段名是否被保留
+func (r ProtoReserved) HasName(name string) bool {
+	for _, reserved := range r.Names {
+		if reserved == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FullName 返回包含包名的完整名称，如 game.battle.Hero
+func (m *ProtoMessage) FullName() string {
+	return qualifyName(m.Package, m.Name)
+}
+
+// ProtoEnum 解析后的 Proto 枚举定义
+type ProtoEnum struct {
+	Name    string            // 枚举名称（嵌套枚举为 Outer.Inner，不含包名）
+	Package string            // 所属包名
+	Values  []*ProtoEnumValue // 枚举值列表（保持顺序）
+}
+
+// FullName 返回包含包名的完整名称
+func (e *ProtoEnum) FullName() string {
+	return qualifyName(e.Package, e.Name)
+}
+
+func qualifyName(pkg, name string) string {
+	if pkg == "" {
+		return name
+	}
+	return pkg + "." + name
+}
+
+// ProtoEnumValue 枚举值定义
+type ProtoEnumValue struct {
+	Name  string // 枚举值名称
+	Value int    // 枚举值
+}
+
+// ProtoService 解析后的 Proto service 定义
+type ProtoService struct {
+	Name       string      // service 名称，如 EntryHandler
+	Package    string      // 所属包名
+	Methods    []*ProtoRPC // rpc 列表（保持顺序）
+	SourceFile string      // 定义所在的文件
+}
+
+// ProtoRPC service 中的 rpc 定义
+type ProtoRPC struct {
+	Name           string // rpc 名称，如 Entry
+	RequestType    string // 请求消息类型
+	ResponseType   string // 响应消息类型
+	RequestStream  bool   // 请求是否声明了 stream
+	ResponseStream bool   // 响应是否声明了 stream
 }
 
 // ProtoField Proto 字段定义
 type ProtoField struct {
-	Name     string    // 字段名称
-	Type     FieldType // 字段类型
-	Tag      int       // 字段标签号
-	Repeated bool      // 是否为数组
-	TypeName string    // 自定义类型名称（用于嵌套消息）
+	Name      string            // 字段名称
+	Type      FieldType         // 字段类型
+	Tag       int               // 字段标签号
+	Repeated  bool              // 是否为数组
+	Required  bool              // 是否为 proto2 的 required 字段
+	Packed    bool              // 重复的数值/枚举字段是否为 packed 编码（proto3 默认 packed，proto2 需要 [packed = true]）
+	TypeName  string            // 自定义类型名称（用于嵌套消息、枚举）
+	OneofName string            // 所属 oneof 名称（pomelo 没有 oneof，按 optional 字段处理）
+	Options   map[string]string // 字段选项，如 [default = 1] 解析为 {"default": "1"}
+	Doc       string            // 字段前紧邻的 // 注释，多行以换行符连接
+
+	// Constraints 字段前或行尾注释中声明的校验约束（见 FieldConstraints），没有声明时为 nil
+	Constraints *FieldConstraints
+
+	// 解析引用前声明的类型名和 packed，ReparseFile 后重新解析引用时从声明的类型开始
+	declaredType   string
+	declaredPacked bool
+}
+
+// Deprecated 字段是否声明了 [deprecated = true]
+func (f *ProtoField) Deprecated() bool {
+	return f.Options["deprecated"] == "true"
 }
 
 // protoTypeMapping Proto 类型到 Pomelo 类型的映射
@@ -87,6 +238,43 @@ var protoTypeMapping = map[string]FieldType{
 	"sfixed64": TypeInt64,
 }
 
+// wellKnownTypeMapping protobuf well-known type 到 Pomelo 类型的映射（Options.MapWellKnownTypes 开启时生效）
+// Timestamp 按 Unix 毫秒数、Duration 按毫秒数下发，包装类型按其包装的基础类型下发
+var wellKnownTypeMapping = map[string]FieldType{
+	"google.protobuf.Timestamp":   TypeInt64,
+	"google.protobuf.Duration":    TypeInt64,
+	"google.protobuf.StringValue": TypeString,
+	"google.protobuf.BytesValue":  TypeBytes,
+	"google.protobuf.BoolValue":   TypeBool,
+	"google.protobuf.Int32Value":  TypeInt32,
+	"google.protobuf.UInt32Value": TypeUInt32,
+	"google.protobuf.Int64Value":  TypeInt64,
+	"google.protobuf.UInt64Value": TypeUInt64,
+	"google.protobuf.FloatValue":  TypeFloat,
+	"google.protobuf.DoubleValue": TypeDouble,
+}
+
+const (
+	wellKnownEmpty        = "google.protobuf.Empty" // 映射为没有字段的空消息
+	wellKnownImportPrefix = "google/protobuf/"      // well-known type 所在的 import 路径
+)
+
+// mapKeyTypes map 字段允许的 key 类型（整型或 string，不允许浮点、bytes、消息和枚举）
+var mapKeyTypes = map[string]bool{
+	"string":   true,
+	"bool":     true,
+	"int32":    true,
+	"uint32":   true,
+	"sint32":   true,
+	"int64":    true,
+	"uint64":   true,
+	"sint64":   true,
+	"fixed32":  true,
+	"fixed64":  true,
+	"sfixed32": true,
+	"sfixed64": true,
+}
+
 // GetPomeloType 获取 Pomelo 风格的类型名称
 func GetPomeloType(protoType string) (FieldType, bool) {
 	t, ok := protoTypeMapping[protoType]
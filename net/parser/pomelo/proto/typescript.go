@@ -0,0 +1,184 @@
+package pomeloProto
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// tsTypeMapping Pomelo 类型到 TypeScript 类型的映射
+var tsTypeMapping = map[FieldType]string{
+	TypeString: "string",
+	TypeBool:   "boolean",
+	TypeInt32:  "number",
+	TypeUInt32: "number",
+	TypeSInt32: "number",
+	TypeInt64:  "number",
+	TypeUInt64: "number",
+	TypeSInt64: "number",
+	TypeFloat:  "number",
+	TypeDouble: "number",
+	TypeBytes:  "Uint8Array",
+}
+
+// tsField 从 schema 字段 key 中解析出的字段信息
+type tsField struct {
+	modifier string
+	typ      string
+	message  string // 消息类型名，非消息类型为空
+	name     string
+	tag      int
+}
+
+// GenerateTypeScript 根据 schema 生成 TypeScript 类型定义，用于客户端解码
+// 每个路由生成一个 interface（Server 路由后缀 Response，Client 路由后缀 Request），
+// 路由内的 __messages__ 生成同名 namespace 中的 interface，全局 __messages__ 生成顶层 interface，
+// 最后生成路由到消息类型的 ServerRoutes/ClientRoutes 映射。仅用于构建期，不在运行时调用
+func GenerateTypeScript(schema *ProtoSchema, w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "// Code generated by cherry pomelo proto. DO NOT EDIT.\n")
+	fmt.Fprintf(out, "// schema version: %d\n", schema.Version)
+
+	// 全局消息
+	for _, name := range sortedKeys(schema.Messages) {
+		msg, _ := schema.Messages[name].(map[string]interface{})
+		out.WriteString("\n")
+		writeTSInterface(out, "", tsIdentifier(name), msg, nil, schema.Messages)
+	}
+
+	sides := []struct {
+		routes    map[string]interface{}
+		suffix    string
+		tableName string
+	}{
+		{schema.Server, "Response", "ServerRoutes"},
+		{schema.Client, "Request", "ClientRoutes"},
+	}
+
+	for _, side := range sides {
+		for _, route := range sortedKeys(side.routes) {
+			msg, _ := side.routes[route].(map[string]interface{})
+			typeName := tsRouteTypeName(route, side.suffix)
+			nested, _ := msg[MessagesKey].(map[string]interface{})
+
+			out.WriteString("\n")
+			writeTSInterface(out, "", typeName, msg, tsNamespace(typeName, nested), schema.Messages)
+
+			if len(nested) > 0 {
+				fmt.Fprintf(out, "\nexport namespace %s {\n", typeName)
+				for i, name := range sortedKeys(nested) {
+					if i > 0 {
+						out.WriteString("\n")
+					}
+					nestedMsg, _ := nested[name].(map[string]interface{})
+					writeTSInterface(out, "  ", tsIdentifier(name), nestedMsg, tsNamespace("", nested), schema.Messages)
+				}
+				out.WriteString("}\n")
+			}
+		}
+	}
+
+	// 路由到消息类型的映射
+	for _, side := range sides {
+		fmt.Fprintf(out, "\nexport interface %s {\n", side.tableName)
+		for _, route := range sortedKeys(side.routes) {
+			fmt.Fprintf(out, "  %q: %s;\n", route, tsRouteTypeName(route, side.suffix))
+		}
+		out.WriteString("}\n")
+	}
+
+	return out.Flush()
+}
+
+// tsNamespace 返回嵌套消息名到 TypeScript 类型名的映射，prefix 不为空时类型名带 namespace 前缀
+func tsNamespace(prefix string, nested map[string]interface{}) map[string]string {
+	names := make(map[string]string, len(nested))
+	for name := range nested {
+		if prefix == "" {
+			names[name] = tsIdentifier(name)
+		} else {
+			names[name] = prefix + "." + tsIdentifier(name)
+		}
+	}
+	return names
+}
+
+// writeTSInterface 输出单个消息的 interface，字段按标签号排序
+// 引用的消息优先在 nested 中查找，其次在全局消息中查找，都找不到时为 unknown
+func writeTSInterface(out *bufio.Writer, indent, name string, msg map[string]interface{}, nested map[string]string, global map[string]interface{}) {
+	fields := tsFields(msg)
+
+	fmt.Fprintf(out, "%sexport interface %s {\n", indent, name)
+	for _, field := range fields {
+		typ := tsTypeMapping[FieldType(field.typ)]
+		if field.message != "" {
+			if nestedName, found := nested[field.message]; found {
+				typ = nestedName
+			} else if _, found := global[field.message]; found {
+				typ = tsIdentifier(field.message)
+			} else {
+				typ = "unknown"
+			}
+		} else if typ == "" {
+			typ = "unknown"
+		}
+
+		if field.modifier == string(ModifierRepeated) {
+			typ += "[]"
+		}
+
+		optional := "?"
+		if field.modifier == string(ModifierRequired) {
+			optional = ""
+		}
+
+		fmt.Fprintf(out, "%s  %s%s: %s;\n", indent, field.name, optional, typ)
+	}
+	fmt.Fprintf(out, "%s}\n", indent)
+}
+
+// tsFields 从 schema 消息中解析字段，忽略 __ 开头的元数据 key
+func tsFields(msg map[string]interface{}) []tsField {
+	fields := make([]tsField, 0, len(msg))
+	for key, value := range msg {
+		if strings.HasPrefix(key, "__") {
+			continue
+		}
+
+		parts := strings.Fields(key)
+		field := tsField{tag: schemaTag(value)}
+		switch {
+		case len(parts) == 4 && parts[1] == string(TypeMessage):
+			field.modifier, field.typ, field.message, field.name = parts[0], parts[1], parts[2], parts[3]
+		case len(parts) == 3:
+			field.modifier, field.typ, field.name = parts[0], parts[1], parts[2]
+		default:
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].tag < fields[j].tag
+	})
+	return fields
+}
+
+// tsRouteTypeName 路由名转换为类型名，如 game.heroHandler.list -> GameHeroHandlerListResponse
+func tsRouteTypeName(route, suffix string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(route, func(r rune) bool {
+		return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String() + suffix
+}
+
+// tsIdentifier 消息名转换为合法的标识符，如 Outer.Inner -> Outer_Inner
+func tsIdentifier(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
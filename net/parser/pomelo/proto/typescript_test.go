@@ -0,0 +1,35 @@
+package pomeloProto
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateTypeScriptGolden(t *testing.T) {
+	dir := t.TempDir()
+	writeProtoFile(t, dir, "hero.proto", heroProto)
+
+	opts := DefaultOptions()
+	opts.ProtoDir = dir
+	opts.Version = 1
+	opts.ServerRoutes["game.heroHandler.list"] = "HeroListResponse"
+	opts.ClientRoutes["game.heroHandler.get"] = "Hero"
+
+	schema := parseOptions(t, opts)
+
+	var got bytes.Buffer
+	if err := GenerateTypeScript(schema, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile(filepath.Join("testdata", "schema.golden.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("typescript mismatch.\ngot:\n%s\nwant:\n%s", got.Bytes(), want)
+	}
+}
@@ -0,0 +1,59 @@
+package pomelo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+)
+
+const (
+	shutdownPollInterval = 10 * time.Millisecond // Shutdown 检查进行中 data 包的间隔
+)
+
+// Shutdown 优雅关闭：不再处理新的 data 包（直接丢弃）和心跳，等待进行中的 data 路由分发完成
+// ctx 到期时仍有未完成的分发则返回 ctx.Err()
+// 注意：只等待 onDataRouteFunc 返回，路由函数投递到 actor 的消息由 actor 自身的关闭流程处理
+func Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&cmd.shuttingDown, 1)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		inflight := atomic.LoadInt64(&cmd.inflight)
+		if inflight == 0 {
+			clog.Info("[initCommand] shutdown complete.")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			clog.Warnf("[initCommand] shutdown timeout. [inflight = %d]", inflight)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isShuttingDown 是否已调用 Shutdown
+func (p *Command) isShuttingDown() bool {
+	return atomic.LoadInt32(&p.shuttingDown) == 1
+}
+
+// beginDispatch 登记一个进行中的 data 包，已调用 Shutdown 时返回 false
+// 先增加计数再检查状态，保证 Shutdown 看到计数为 0 后不会再有新的分发
+func (p *Command) beginDispatch() bool {
+	atomic.AddInt64(&p.inflight, 1)
+	if p.isShuttingDown() {
+		atomic.AddInt64(&p.inflight, -1)
+		return false
+	}
+	return true
+}
+
+// endDispatch data 包处理完成
+func (p *Command) endDispatch() {
+	atomic.AddInt64(&p.inflight, -1)
+}